@@ -25,6 +25,7 @@ import (
 
 	"github.com/goharbor/acceleration-service/pkg/config"
 	"github.com/goharbor/acceleration-service/pkg/daemon"
+	"github.com/goharbor/acceleration-service/pkg/remote"
 )
 
 var versionTag string
@@ -39,6 +40,9 @@ func main() {
 
 	version := fmt.Sprintf("%s %s.%s", versionTag, versionGitCommit, versionBuildTime)
 	logrus.Infof("Version: %s\n", version)
+	if versionTag != "" {
+		remote.DefaultUserAgent = "acceleration-service/" + versionTag
+	}
 
 	app := &cli.App{
 		Name:    "acceld",
@@ -47,11 +47,12 @@ type Adapter interface {
 }
 
 type LocalAdapter struct {
-	cfg     *config.Config
-	rule    *Rule
-	worker  *Worker
-	cvt     *converter.Converter
-	content *Content
+	cfg      *config.Config
+	rule     *Rule
+	worker   *Worker
+	cvt      *converter.Converter
+	content  *Content
+	provider *content.LocalProvider
 }
 
 func NewLocalAdapter(cfg *config.Config) (*LocalAdapter, error) {
@@ -61,11 +62,21 @@ func NewLocalAdapter(cfg *config.Config) (*LocalAdapter, error) {
 		return nil, errors.Wrap(err, "invalid platform configuration")
 	}
 
-	provider, db, err := content.NewLocalProvider(cfg.Provider.WorkDir, cfg.Host, platformMC)
+	var opts []content.LocalProviderOpt
+	if cfg.Provider.IngestDir != "" {
+		opts = append(opts, content.WithIngestDir(cfg.Provider.IngestDir))
+	}
+	provider, db, err := content.NewLocalProvider(cfg.Provider.WorkDir, cfg.Host, platformMC, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "create content provider")
 	}
-	content, err := NewContent(db, cfg)
+	provider.SetResolverOpts(cfg.ResolverOpts()...)
+	provider.SetBandwidthLimit(cfg.Provider.BandwidthLimit)
+	provider.SetMaxImageSize(cfg.Provider.MaxImageSize)
+	if cfg.Provider.MaxConcurrentUploads != 0 {
+		provider.SetMaxConcurrentUploads(cfg.Provider.MaxConcurrentUploads)
+	}
+	content, err := NewContent(provider, db, cfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "create Content in LocalProvider")
 	}
@@ -88,11 +99,12 @@ func NewLocalAdapter(cfg *config.Config) (*LocalAdapter, error) {
 	}
 
 	handler := &LocalAdapter{
-		cfg:     cfg,
-		rule:    rule,
-		worker:  worker,
-		cvt:     cvt,
-		content: content,
+		cfg:      cfg,
+		rule:     rule,
+		worker:   worker,
+		cvt:      cvt,
+		content:  content,
+		provider: provider,
 	}
 
 	return handler, nil
@@ -107,7 +119,17 @@ func (adp *LocalAdapter) Convert(ctx context.Context, source string) error {
 		}
 		return errors.Wrap(err, "create target reference by rule")
 	}
-	if _, err = adp.cvt.Convert(ctx, source, target); err != nil {
+	// The lease protects the pulled and converted blobs from the GC of
+	// concurrent conversions until the target image is pushed.
+	leaseCtx, done, err := adp.provider.WithLease(ctx)
+	if err != nil {
+		return errors.Wrap(err, "create lease")
+	}
+	_, err = adp.cvt.Convert(leaseCtx, source, target)
+	if err := done(); err != nil {
+		logrus.Warnf("release lease for %s: %s", source, err)
+	}
+	if err != nil {
 		return err
 	}
 	if err := adp.content.GC(ctx); err != nil {
@@ -123,7 +145,8 @@ func (adp *LocalAdapter) Dispatch(ctx context.Context, ref string, sync bool) er
 		// FIXME: The synchronous conversion task should also be
 		// executed in a limited worker queue.
 		return metrics.Conversion.OpWrap(func() error {
-			err := adp.Convert(namespaces.WithNamespace(ctx, "acceleration-service"), ref)
+			ctx := content.WithJobID(namespaces.WithNamespace(ctx, content.DefaultNamespace), taskID)
+			err := adp.Convert(ctx, ref)
 			task.Manager.Finish(taskID, err)
 			return err
 		}, "convert")
@@ -133,7 +156,8 @@ func (adp *LocalAdapter) Dispatch(ctx context.Context, ref string, sync bool) er
 		// If the ref is same, we only convert once in the same time.
 		_, err, _ := dispatchSingleflight.Do(ref, func() (interface{}, error) {
 			return nil, metrics.Conversion.OpWrap(func() error {
-				return adp.Convert(namespaces.WithNamespace(context.Background(), "acceleration-service"), ref)
+				ctx := content.WithJobID(namespaces.WithNamespace(context.Background(), content.DefaultNamespace), taskID)
+				return adp.Convert(ctx, ref)
 			}, "convert")
 		})
 		task.Manager.Finish(taskID, err)
@@ -22,6 +22,7 @@ import (
 	"github.com/containerd/containerd/metadata"
 	"github.com/dustin/go-humanize"
 	"github.com/goharbor/acceleration-service/pkg/config"
+	pkgcontent "github.com/goharbor/acceleration-service/pkg/content"
 	"github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
 )
@@ -35,16 +36,18 @@ var (
 )
 
 type Content struct {
+	provider  *pkgcontent.LocalProvider
 	db        *metadata.DB
 	threshold int64
 }
 
-func NewContent(db *metadata.DB, cfg *config.Config) (*Content, error) {
+func NewContent(provider *pkgcontent.LocalProvider, db *metadata.DB, cfg *config.Config) (*Content, error) {
 	threshold, err := humanize.ParseBytes(cfg.Provider.GCPolicy.Threshold)
 	if err != nil {
 		return nil, err
 	}
 	return &Content{
+		provider:  provider,
 		db:        db,
 		threshold: int64(threshold),
 	}, nil
@@ -54,7 +57,7 @@ func NewContent(db *metadata.DB, cfg *config.Config) (*Content, error) {
 func (content *Content) Size() (int64, error) {
 	var contentSize int64
 	if err := content.db.View(func(tx *bolt.Tx) error {
-		bucket := getBucket(tx, bucketKeyVersion, []byte("acceleration-service"), bucketKeyObjectContent, bucketKeyObjectBlob)
+		bucket := getBucket(tx, bucketKeyVersion, []byte(pkgcontent.DefaultNamespace), bucketKeyObjectContent, bucketKeyObjectBlob)
 		// if can't find blob bucket, it maens content store is empty
 		if bucket == nil {
 			return nil
@@ -83,11 +86,13 @@ func (content *Content) GC(ctx context.Context) error {
 	}
 	if size > content.threshold {
 		// TODO: *metadata.DB.GarbageCollect will clear all caches, we need to rewrite gc
-		gcStatus, err := content.db.GarbageCollect(ctx)
+		// The pulled images are the GC roots, release them through provider
+		// to reclaim all caches, so the images of provider are kept in sync.
+		gcStats, err := content.provider.DeleteAllImages(ctx)
 		if err != nil {
 			return err
 		}
-		logrus.Infof("garbage collect, elapse %s", gcStatus.Elapsed())
+		logrus.Infof("garbage collect, elapse %s", gcStats.Elapsed())
 	}
 	return nil
 }
@@ -42,7 +42,7 @@ func TestContenSize(t *testing.T) {
 			},
 		},
 	}
-	content, err := NewContent(db, &cfg)
+	content, err := NewContent(nil, db, &cfg)
 	require.NoError(t, err)
 	size, err := content.Size()
 	require.NoError(t, err)
@@ -17,6 +17,7 @@ package adapter
 import (
 	"errors"
 
+	"github.com/goharbor/acceleration-service/pkg/metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -41,6 +42,7 @@ func NewWorker(count int) (*Worker, error) {
 		go func() {
 			for {
 				job := <-worker.jobs
+				metrics.WorkerQueueDepth.Dec()
 				if err := job(); err != nil {
 					logrus.Errorf("convert in worker: %s", err)
 				}
@@ -52,6 +54,7 @@ func NewWorker(count int) (*Worker, error) {
 }
 
 func (worker *Worker) Dispatch(job func() error) {
+	metrics.WorkerQueueDepth.Inc()
 	go func() {
 		worker.jobs <- job
 	}()
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/goharbor/acceleration-service/pkg/remote"
 	"github.com/pkg/errors"
@@ -49,6 +50,20 @@ type ProviderConfig struct {
 	Source   map[string]SourceConfig `yaml:"source"`
 	WorkDir  string                  `yaml:"work_dir"`
 	GCPolicy GCPolicy                `yaml:"gcpolicy"`
+	Proxy    string                  `yaml:"proxy"`
+	// CircuitBreaker fails the requests to unhealthy registries fast.
+	CircuitBreaker CircuitBreaker `yaml:"circuit_breaker"`
+	// BandwidthLimit limits the bytes per second used by blob transfers.
+	BandwidthLimit int64 `yaml:"bandwidth_limit"`
+	// MaxConcurrentUploads limits the layers uploaded in parallel by push,
+	// the default limit is used if it's zero.
+	MaxConcurrentUploads int `yaml:"max_concurrent_uploads"`
+	// IngestDir keeps the blobs being pulled, the work directory is used
+	// if it's empty.
+	IngestDir string `yaml:"ingest_dir"`
+	// MaxImageSize rejects the images whose layers exceed the bytes before
+	// pulling, unlimited if it's zero.
+	MaxImageSize int64 `yaml:"max_image_size"`
 }
 
 type GCPolicy struct {
@@ -59,10 +74,33 @@ type Webhook struct {
 	AuthHeader string `yaml:"auth_header"`
 }
 
+type TLSConfig struct {
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type RateLimit struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+}
+
+type CircuitBreaker struct {
+	// Consecutive failures to open the circuit of registry, the circuit
+	// breaker is disabled if it's zero.
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+}
+
 type SourceConfig struct {
-	Auth     string  `yaml:"auth"`
-	Insecure bool    `yaml:"insecure"`
-	Webhook  Webhook `yaml:"webhook"`
+	Auth      string    `yaml:"auth"`
+	Insecure  bool      `yaml:"insecure"`
+	Webhook   Webhook   `yaml:"webhook"`
+	Mirrors   []string  `yaml:"mirrors"`
+	TLS       TLSConfig `yaml:"tls"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Address   string    `yaml:"address"`
 }
 
 type ConversionRule struct {
@@ -96,6 +134,45 @@ func Parse(configPath string) (*Config, error) {
 	return &config, nil
 }
 
+// ResolverOpts returns the resolver options configured by sources, the
+// resolvers created with the options share the cache of registry tokens
+// and the cache of manifests resolved by tag.
+func (cfg *Config) ResolverOpts() []remote.ResolverOpt {
+	opts := []remote.ResolverOpt{
+		remote.WithTokenCache(remote.NewTokenCache(remote.DefaultTokenRefreshMargin)),
+		remote.WithManifestCache(remote.NewManifestCache(remote.DefaultManifestCacheSize)),
+	}
+	if cfg.Provider.Proxy != "" {
+		opts = append(opts, remote.WithProxy(cfg.Provider.Proxy))
+	}
+	if breaker := cfg.Provider.CircuitBreaker; breaker.Threshold > 0 {
+		opts = append(opts, remote.WithCircuitBreaker(remote.NewCircuitBreaker(remote.CircuitBreakerConfig{
+			Threshold: breaker.Threshold,
+			Window:    breaker.Window,
+			Cooldown:  breaker.Cooldown,
+		})))
+	}
+	for host, source := range cfg.Provider.Source {
+		if len(source.Mirrors) > 0 {
+			opts = append(opts, remote.WithMirrors(host, source.Mirrors...))
+		}
+		if source.TLS != (TLSConfig{}) {
+			opts = append(opts, remote.WithTLSConfig(host, remote.TLSConfig{
+				CAFile:   source.TLS.CAFile,
+				CertFile: source.TLS.CertFile,
+				KeyFile:  source.TLS.KeyFile,
+			}))
+		}
+		if source.RateLimit.RPS > 0 {
+			opts = append(opts, remote.WithRateLimit(host, source.RateLimit.RPS, source.RateLimit.Burst))
+		}
+		if source.Address != "" {
+			opts = append(opts, remote.WithHostOverride(host, source.Address))
+		}
+	}
+	return opts
+}
+
 func (cfg *Config) Host(ref string) (remote.CredentialFunc, bool, error) {
 	authorizer := func(ref string) (*SourceConfig, error) {
 		refURL, err := url.Parse(fmt.Sprintf("dummy://%s", ref))
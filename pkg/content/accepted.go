@@ -0,0 +1,77 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// acceptedPollConfig bounds the polling of the manifest pushed
+// asynchronously, it's about 50s in total without jitter.
+var acceptedPollConfig = RetryConfig{
+	MaxAttempts:    10,
+	InitialBackoff: 100 * time.Millisecond,
+	Multiplier:     2,
+	DisableJitter:  true,
+}
+
+// acceptedRecorder records whether a manifest push is answered by 202
+// Accepted, that is, the registry makes the manifest visible later.
+type acceptedRecorder struct {
+	accepted int32
+}
+
+func (r *acceptedRecorder) record(req *http.Request) {
+	atomic.StoreInt32(&r.accepted, 1)
+}
+
+func (r *acceptedRecorder) isAccepted() bool {
+	return atomic.LoadInt32(&r.accepted) == 1
+}
+
+// waitManifest polls the registry with backoff until ref resolves to the
+// pushed manifest dgst, so the image can be pulled once Push returns.
+func waitManifest(ctx context.Context, resolver remotes.Resolver, ref string, dgst digest.Digest) error {
+	for attempt := 1; ; attempt++ {
+		_, desc, err := resolver.Resolve(ctx, ref)
+		if err == nil && desc.Digest == dgst {
+			return nil
+		}
+		if attempt >= acceptedPollConfig.MaxAttempts {
+			if err == nil {
+				err = errors.Errorf("resolved to %s", desc.Digest)
+			}
+			return errors.Wrapf(err, "manifest %s accepted but not visible after %d polls", dgst, attempt)
+		}
+
+		backoff := acceptedPollConfig.backoff(attempt)
+		log.G(ctx).Debugf("poll accepted manifest %s after %s", dgst, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
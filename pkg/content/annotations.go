@@ -0,0 +1,50 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/images"
+	"github.com/pkg/errors"
+)
+
+// Annotations returns the annotations of the manifest or index of pulled
+// image ref, nil is returned if there are none. The manifests and indexes
+// are kept in content store as pulled, so the annotations are pushed
+// unchanged, and the converted ones keep the annotations of source.
+func (pvd *LocalProvider) Annotations(ref string) (map[string]string, error) {
+	ctx := context.Background()
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	target, err := pvd.getImage(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !images.IsManifestType(target.MediaType) && !images.IsIndexType(target.MediaType) {
+		return nil, errors.Errorf("unsupported media type %s of %s", target.MediaType, ref)
+	}
+
+	var manifest struct {
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+	if err := readJSON(ctx, *pvd.store, *target, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "read manifest %s", target.Digest)
+	}
+
+	return manifest.Annotations, nil
+}
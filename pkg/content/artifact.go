@@ -0,0 +1,79 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// artifactChildrenHandler returns the children of descriptor like
+// images.ChildrenHandler, and the blobs of OCI artifact manifest as well,
+// the subject of artifact isn't a child.
+func artifactChildrenHandler(provider content.Provider) images.HandlerFunc {
+	return func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if desc.MediaType != ocispec.MediaTypeArtifactManifest {
+			return images.Children(ctx, provider, desc)
+		}
+		data, err := content.ReadBlob(ctx, provider, desc)
+		if err != nil {
+			return nil, err
+		}
+		var artifact ocispec.Artifact
+		if err := json.Unmarshal(data, &artifact); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal artifact manifest %s", desc.Digest)
+		}
+		return artifact.Blobs, nil
+	}
+}
+
+// artifactResolver fetches the OCI artifact manifests from the manifests
+// endpoint of registry, which is unknown to the fetcher of containerd, so
+// they're fetched from the blobs endpoint otherwise.
+type artifactResolver struct {
+	remotes.Resolver
+}
+
+func newArtifactResolver(resolver remotes.Resolver) remotes.Resolver {
+	return &artifactResolver{Resolver: resolver}
+}
+
+func (r *artifactResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &artifactFetcher{Fetcher: fetcher}, nil
+}
+
+type artifactFetcher struct {
+	remotes.Fetcher
+}
+
+func (f *artifactFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if desc.MediaType == ocispec.MediaTypeArtifactManifest {
+		// The fetcher accepts any media type of manifest, and only the
+		// digest of fetched content is verified.
+		desc.MediaType = ocispec.MediaTypeImageManifest
+	}
+	return f.Fetcher.Fetch(ctx, desc)
+}
@@ -0,0 +1,110 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// The outcomes of AuditRecord.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditRecord is the record of a Pull or Push written to the audit log.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Direction is either "pull" or "push".
+	Direction string `json:"direction"`
+	Ref       string `json:"ref"`
+	// JobID is the job id carried by the context of operation, see also
+	// WithJobID, or the request id generated for the operation.
+	JobID string `json:"job_id"`
+	// Digest is the manifest digest of image, it's empty if the pull fails.
+	Digest digest.Digest `json:"digest,omitempty"`
+	// Bytes is the size of blobs transferred from or to registry.
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration_seconds"`
+	Outcome  string  `json:"outcome"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// auditLog writes the records in JSON Lines, that is, a JSON object per
+// line, the records of concurrent operations aren't interleaved.
+type auditLog struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// SetAuditLog enables the provider to write an AuditRecord to w for each
+// Pull and Push, including the failed ones. The audit log is separated
+// from the logger, so the records are complete whatever the log level.
+func (pvd *LocalProvider) SetAuditLog(w io.Writer) {
+	if w == nil {
+		pvd.auditLog = nil
+		return
+	}
+	pvd.auditLog = &auditLog{encoder: json.NewEncoder(w)}
+}
+
+func (l *auditLog) write(record AuditRecord) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.encoder.Encode(record)
+}
+
+// audit records the operation started at start with the transferred
+// bytes counted by counter.
+func (pvd *LocalProvider) audit(ctx context.Context, direction, ref string, dgst digest.Digest, start time.Time, counter *int64, err error) {
+	record := AuditRecord{
+		Time:      start.UTC(),
+		Direction: direction,
+		Ref:       ref,
+		JobID:     JobIDFromContext(ctx),
+		Digest:    dgst,
+		Bytes:     atomic.LoadInt64(counter),
+		Duration:  time.Since(start).Seconds(),
+		Outcome:   AuditSuccess,
+	}
+	if err != nil {
+		record.Outcome = AuditFailure
+		record.Error = err.Error()
+	}
+	if err := pvd.auditLog.write(record); err != nil {
+		pvd.logger.Warnf("write audit record of %s: %s", ref, err)
+	}
+}
+
+type transferCounterKey struct{}
+
+// withTransferCounter returns the context counting the bytes transferred
+// by the resolver of operation.
+func withTransferCounter(ctx context.Context) (context.Context, *int64) {
+	counter := new(int64)
+	return context.WithValue(ctx, transferCounterKey{}, counter), counter
+}
+
+func transferCounter(ctx context.Context) *int64 {
+	counter, _ := ctx.Value(transferCounterKey{}).(*int64)
+	return counter
+}
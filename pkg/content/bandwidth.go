@@ -0,0 +1,164 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/time/rate"
+)
+
+// maxBandwidthBurst limits the bytes transferred at once, which smooths
+// the bandwidth used by the transfers.
+const maxBandwidthBurst = 32 * 1024
+
+// SetBandwidthLimit limits the total bandwidth in bytes per second used
+// by the blob transfers of all Pull and Push calls, the limit is disabled
+// if limit <= 0.
+func (pvd *LocalProvider) SetBandwidthLimit(limit int64) {
+	if limit <= 0 {
+		pvd.bandwidthLimiter = nil
+		return
+	}
+	burst := maxBandwidthBurst
+	if limit < int64(burst) {
+		burst = int(limit)
+	}
+	limiter := rate.NewLimiter(rate.Limit(limit), burst)
+	// The limiter starts with a full bucket, which isn't counted.
+	limiter.AllowN(time.Now(), burst)
+	pvd.bandwidthLimiter = limiter
+}
+
+// bandwidthResolver throttles the blob reads of fetcher and the blob
+// writes of pusher by the limiter shared by the resolvers.
+type bandwidthResolver struct {
+	remotes.Resolver
+	limiter *rate.Limiter
+}
+
+func newBandwidthResolver(resolver remotes.Resolver, limiter *rate.Limiter) remotes.Resolver {
+	if limiter == nil {
+		return resolver
+	}
+	return &bandwidthResolver{
+		Resolver: resolver,
+		limiter:  limiter,
+	}
+}
+
+func (resolver *bandwidthResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := resolver.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		reader := &bandwidthReader{
+			ReadCloser: rc,
+			ctx:        ctx,
+			limiter:    resolver.limiter,
+		}
+		if _, ok := rc.(io.Seeker); ok {
+			return &bandwidthReadSeeker{reader}, nil
+		}
+		return reader, nil
+	}), nil
+}
+
+func (resolver *bandwidthResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	pusher, err := resolver.Resolver.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.PusherFunc(func(ctx context.Context, desc ocispec.Descriptor) (content.Writer, error) {
+		cw, err := pusher.Push(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		return &bandwidthWriter{
+			Writer:  cw,
+			ctx:     ctx,
+			limiter: resolver.limiter,
+		}, nil
+	}), nil
+}
+
+type bandwidthReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+// Read reads at most a burst of bytes, and waits for the limiter until
+// the bytes are allowed or the context is done.
+func (reader *bandwidthReader) Read(p []byte) (int, error) {
+	if burst := reader.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := reader.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := reader.limiter.WaitN(reader.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// bandwidthReadSeeker keeps the seeker of fetched blob, which is used to
+// resume the download from the offset of partially written blob.
+type bandwidthReadSeeker struct {
+	*bandwidthReader
+}
+
+func (reader *bandwidthReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return reader.ReadCloser.(io.Seeker).Seek(offset, whence)
+}
+
+type bandwidthWriter struct {
+	content.Writer
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+// Write writes the bytes by bursts, each burst waits for the limiter
+// until it's allowed or the context is done.
+func (writer *bandwidthWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if burst := writer.limiter.Burst(); len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+		if err := writer.limiter.WaitN(writer.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := writer.Writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
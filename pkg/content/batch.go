@@ -0,0 +1,77 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"sync"
+)
+
+// PullAll pulls the references with at most concurrency pulls in parallel,
+// and returns the error of each reference, a nil error means the reference
+// is pulled successfully. Once the context is canceled, no more pulls are
+// started and the context error is returned along with the results, the
+// references not pulled are reported with the context error.
+func (pvd *LocalProvider) PullAll(ctx context.Context, refs []string, concurrency int, opts ...PullOpt) (map[string]error, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		mutex   sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]error, len(refs))
+		tokens  = make(chan struct{}, concurrency)
+	)
+	setResult := func(ref string, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		results[ref] = err
+	}
+
+	for _, ref := range refs {
+		mutex.Lock()
+		_, scheduled := results[ref]
+		mutex.Unlock()
+		if scheduled {
+			continue
+		}
+
+		select {
+		case tokens <- struct{}{}:
+		case <-ctx.Done():
+			setResult(ref, ctx.Err())
+			continue
+		}
+		// The token may be selected even if the context is canceled.
+		if err := ctx.Err(); err != nil {
+			<-tokens
+			setResult(ref, err)
+			continue
+		}
+
+		// Mark the reference as scheduled to skip the duplicated ones.
+		setResult(ref, nil)
+		wg.Add(1)
+		go func(ref string) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+			setResult(ref, pvd.Pull(ctx, ref, opts...))
+		}(ref)
+	}
+	wg.Wait()
+
+	return results, ctx.Err()
+}
@@ -0,0 +1,108 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrClosed is returned by the methods of provider after Close.
+var ErrClosed = errors.New("local provider is closed")
+
+// ErrShuttingDown is returned by Pull and Push once the provider starts
+// draining the in-flight operations for Shutdown or Close.
+var ErrShuttingDown = errors.New("local provider is shutting down")
+
+// Close waits for the running operations and releases the metadata
+// database, so the work directory can be opened by another provider.
+// The local content store holds no handles between operations, so
+// there is nothing to release for it.
+func (pvd *LocalProvider) Close() error {
+	return pvd.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting new Pull and Push calls, and waits for the
+// in-flight ones to finish before releasing the metadata database like
+// Close. The database is not released if the context is done before
+// the operations finish, the operations can be canceled by caller and
+// Shutdown or Close can be called again.
+func (pvd *LocalProvider) Shutdown(ctx context.Context) error {
+	pvd.mutex.Lock()
+	if pvd.closed {
+		pvd.mutex.Unlock()
+		return ErrClosed
+	}
+	pvd.shuttingDown = true
+	pvd.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		pvd.operations.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait for in-flight operations")
+	}
+
+	pvd.gcMutex.Lock()
+	defer pvd.gcMutex.Unlock()
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+
+	if pvd.closed {
+		return ErrClosed
+	}
+	pvd.closed = true
+
+	// The database injected by NewProviderWithStore is released by caller.
+	if pvd.bdb != nil {
+		if err := pvd.bdb.Close(); err != nil {
+			return errors.Wrap(err, "close local provider database")
+		}
+	}
+	if pvd.tempDir != "" {
+		if err := os.RemoveAll(pvd.tempDir); err != nil {
+			return errors.Wrap(err, "remove memory provider database")
+		}
+	}
+
+	return nil
+}
+
+func (pvd *LocalProvider) isClosed() bool {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	return pvd.closed
+}
+
+// beginOperation tracks an in-flight Pull or Push for Shutdown, the
+// returned function must be called once the operation finishes.
+func (pvd *LocalProvider) beginOperation() (func(), error) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	if pvd.closed {
+		return nil, ErrClosed
+	}
+	if pvd.shuttingDown {
+		return nil, ErrShuttingDown
+	}
+	pvd.operations.Add(1)
+	return pvd.operations.Done, nil
+}
@@ -0,0 +1,67 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PushResult reports whether the image is uploaded by a conditional push.
+type PushResult int
+
+const (
+	// PushUploaded means the image is pushed to the destination.
+	PushUploaded PushResult = iota
+	// PushSkipped means the destination already has the same manifest,
+	// none of the blobs and manifests are uploaded.
+	PushSkipped
+)
+
+// WithSkipExisting skips the push if the manifest of destination exists
+// and has the same digest of the image, which is checked by a HEAD request
+// of the manifest, the result of push is set to result if it isn't nil.
+func WithSkipExisting(result *PushResult) PushOpt {
+	return func(opts *PushOpts) error {
+		opts.skipExisting = true
+		opts.result = result
+		return nil
+	}
+}
+
+// WithOverwrite pushes the image even if the destination has the same
+// manifest, it takes precedence over WithSkipExisting.
+func WithOverwrite() PushOpt {
+	return func(opts *PushOpts) error {
+		opts.overwrite = true
+		return nil
+	}
+}
+
+// pushed returns true if the manifest of ref has the digest of desc.
+func pushed(ctx context.Context, resolver remotes.Resolver, desc ocispec.Descriptor, ref string) bool {
+	_, existing, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			log.G(ctx).WithError(err).Debug("failed to resolve destination manifest")
+		}
+		return false
+	}
+	return existing.Digest == desc.Digest
+}
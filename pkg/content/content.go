@@ -33,13 +33,24 @@ type Provider interface {
 	// Pull pulls source image from remote registry by specified reference.
 	// This pulls all platforms of the image but Image() returns containerd.Image for
 	// the default platform.
-	Pull(ctx context.Context, ref string) error
+	Pull(ctx context.Context, ref string, opts ...PullOpt) error
 	// Push pushes target image to remote registry by specified reference,
 	// the desc parameter represents the manifest of targe image.
-	Push(ctx context.Context, desc ocispec.Descriptor, ref string) error
+	Push(ctx context.Context, desc ocispec.Descriptor, ref string, opts ...PushOpt) error
 
 	// Image gets the source image descriptor.
 	Image(ctx context.Context, ref string) (*ocispec.Descriptor, error)
+	// DeleteImage deletes the source image by specified reference, and
+	// reclaims the blobs which are no longer referenced by other images.
+	DeleteImage(ctx context.Context, ref string) error
 	// ContentStore gets the content store object of containerd.
 	ContentStore() content.Store
 }
+
+// Acquirer is implemented by the provider which may evict images, the
+// acquired images are never evicted until they are released.
+type Acquirer interface {
+	// Acquire marks the image of reference as in use, and returns
+	// the function to release it.
+	Acquire(ref string) (release func())
+}
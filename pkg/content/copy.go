@@ -0,0 +1,54 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// Copy pulls the image of srcRef and pushes it to dstRef, which may be in
+// another registry, the credentials of both registries are resolved by the
+// hosts of provider. The blobs are protected by a lease until the push is
+// finished, so they can't be reclaimed by garbage collection in between.
+func (pvd *LocalProvider) Copy(ctx context.Context, srcRef, dstRef string) error {
+	ctx, release, err := pvd.WithLease(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := release(); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to release lease of copy")
+		}
+	}()
+	// The pulled image isn't evicted before it's pushed.
+	done := pvd.Acquire(srcRef)
+	defer done()
+
+	if err := pvd.Pull(ctx, srcRef); err != nil {
+		return errors.Wrapf(err, "pull source image %s", srcRef)
+	}
+	desc, err := pvd.Image(ctx, srcRef)
+	if err != nil {
+		return errors.Wrapf(err, "get source image %s", srcRef)
+	}
+	if err := pvd.Push(ctx, *desc, dstRef); err != nil {
+		return errors.Wrapf(err, "push target image %s", dstRef)
+	}
+
+	return nil
+}
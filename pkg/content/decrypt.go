@@ -0,0 +1,177 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/converter"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containers/ocicrypt"
+	encconfig "github.com/containers/ocicrypt/config"
+	"github.com/containers/ocicrypt/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// encryptedSuffix is the suffix of media type of ocicrypt encrypted layers.
+// https://github.com/containers/ocicrypt/blob/main/docs/spec.md
+const encryptedSuffix = "+encrypted"
+
+// WithDecryptionKeys decrypts the ocicrypt encrypted layers on Pull by the
+// private keys (PEM, DER or JWK) of the JWE or PKCS7 recipients, like RSA
+// or EC keys, so the converter sees the plaintext layers, the layers not
+// encrypted are kept untouched.
+func WithDecryptionKeys(keys ...[]byte) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		for _, key := range keys {
+			if _, err := utils.IsPrivateKey(key, nil); err != nil {
+				return errors.Wrap(err, "parse decryption key")
+			}
+		}
+		cc, err := encconfig.DecryptWithPrivKeys(keys, make([][]byte, len(keys)))
+		if err != nil {
+			return errors.Wrap(err, "create decryption config")
+		}
+		return WithDecryptConfig(cc.DecryptConfig)(opts)
+	}
+}
+
+// WithDecryptConfig decrypts the ocicrypt encrypted layers on Pull by the
+// decryption config of ocicrypt, e.g. the PGP private keys, or the x509
+// certificates for PKCS7 recipients. It's combined with the keys given by
+// WithDecryptionKeys.
+func WithDecryptConfig(dc *encconfig.DecryptConfig) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		if opts.decryptConfig == nil {
+			opts.decryptConfig = &encconfig.DecryptConfig{Parameters: map[string][][]byte{}}
+		}
+		for key, values := range dc.Parameters {
+			opts.decryptConfig.Parameters[key] = append(opts.decryptConfig.Parameters[key], values...)
+		}
+		return nil
+	}
+}
+
+// decryptImage converts the image by replacing the encrypted layers with
+// the decrypted ones in content store, nil is returned if there are no
+// encrypted layers.
+func (pvd *LocalProvider) decryptImage(ctx context.Context, target ocispec.Descriptor, platformMC platforms.MatchComparer) (*ocispec.Descriptor, error) {
+	if pvd.decryptConfig == nil {
+		return nil, nil
+	}
+	convertFunc := converter.DefaultIndexConvertFunc(pvd.decryptLayer, false, platformMC)
+	return convertFunc(ctx, *pvd.store, target)
+}
+
+// decryptLayer writes the decrypted layer into content store and returns
+// its descriptor, nil is returned if the layer isn't encrypted.
+func (pvd *LocalProvider) decryptLayer(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	if !images.IsLayerType(desc.MediaType) || !strings.HasSuffix(desc.MediaType, encryptedSuffix) {
+		return nil, nil
+	}
+
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open layer %s", desc.Digest)
+	}
+	defer ra.Close()
+	reader, dgst, err := ocicrypt.DecryptLayer(pvd.decryptConfig, content.NewReader(ra), desc, false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decrypt layer %s", desc.Digest)
+	}
+
+	newDesc := ocispec.Descriptor{
+		MediaType: strings.TrimSuffix(desc.MediaType, encryptedSuffix),
+		URLs:      desc.URLs,
+		Platform:  desc.Platform,
+	}
+	if annotations := ocicrypt.FilterOutAnnotations(desc.Annotations); len(annotations) > 0 {
+		newDesc.Annotations = annotations
+	}
+	// The digest of plaintext is validated on commit, it's computed if not
+	// returned by ocicrypt. The HMAC of ciphertext is verified once the
+	// layer is fully read.
+	w, err := content.OpenWriter(ctx, cs, content.WithRef("decrypt-"+desc.Digest.String()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "open writer of decrypted layer %s", desc.Digest)
+	}
+	defer w.Close()
+	if err := w.Truncate(0); err != nil {
+		return nil, errors.Wrapf(err, "truncate decrypted layer %s", desc.Digest)
+	}
+	size, err := io.Copy(w, reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "write decrypted layer %s", desc.Digest)
+	}
+	if err := w.Commit(ctx, size, dgst); err != nil && !errdefs.IsAlreadyExists(err) {
+		return nil, errors.Wrapf(err, "commit decrypted layer %s", desc.Digest)
+	}
+	newDesc.Digest, newDesc.Size = w.Digest(), size
+
+	pvd.mutex.Lock()
+	if pvd.ciphertexts == nil {
+		pvd.ciphertexts = map[digest.Digest]struct{}{}
+	}
+	pvd.ciphertexts[desc.Digest] = struct{}{}
+	pvd.mutex.Unlock()
+
+	return &newDesc, nil
+}
+
+// deleteCiphertexts deletes the decrypted encrypted layers which aren't
+// referenced by the images, rather than keeping them until the garbage
+// collection. It waits for the in-flight pulls which may be decrypting
+// the same layers.
+func (pvd *LocalProvider) deleteCiphertexts(ctx context.Context) error {
+	pvd.mutex.Lock()
+	pending := len(pvd.ciphertexts) > 0
+	pvd.mutex.Unlock()
+	if !pending {
+		return nil
+	}
+
+	pvd.gcMutex.Lock()
+	defer pvd.gcMutex.Unlock()
+
+	pvd.mutex.Lock()
+	ciphertexts := pvd.ciphertexts
+	pvd.ciphertexts = nil
+	targets := make([]ocispec.Descriptor, 0, len(pvd.images))
+	for _, desc := range pvd.images {
+		targets = append(targets, *desc)
+	}
+	pvd.mutex.Unlock()
+
+	if err := pvd.walkBlobs(ctx, func(desc ocispec.Descriptor) {
+		delete(ciphertexts, desc.Digest)
+	}, targets...); err != nil {
+		return errors.Wrap(err, "walk images")
+	}
+	store := *pvd.store
+	for dgst := range ciphertexts {
+		if err := store.Delete(ctx, dgst); err != nil && !errdefs.IsNotFound(err) {
+			return errors.Wrapf(err, "delete encrypted layer %s", dgst)
+		}
+	}
+
+	return nil
+}
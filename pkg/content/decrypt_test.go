@@ -0,0 +1,138 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containers/ocicrypt"
+	encconfig "github.com/containers/ocicrypt/config"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptLayer encrypts the layer by ocicrypt with the JWE recipient of
+// public key, and returns the encrypted layer and annotations.
+func encryptLayer(t *testing.T, pub crypto.PublicKey, layer []byte) ([]byte, map[string]string) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	cc, err := encconfig.EncryptWithJwe([][]byte{pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})})
+	require.NoError(t, err)
+	reader, finalizer, err := ocicrypt.EncryptLayer(cc.EncryptConfig, bytes.NewReader(layer), ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	})
+	require.NoError(t, err)
+	encrypted, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	annotations, err := finalizer()
+	require.NoError(t, err)
+	return encrypted, annotations
+}
+
+func privateKeyPEM(t *testing.T, key crypto.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestDecryptLayers(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	t.Run("rsa", func(t *testing.T) {
+		testDecryptLayers(t, privateKeyPEM(t, rsaKey), &rsaKey.PublicKey)
+	})
+	t.Run("ec", func(t *testing.T) {
+		testDecryptLayers(t, privateKeyPEM(t, ecKey), &ecKey.PublicKey)
+	})
+}
+
+func testDecryptLayers(t *testing.T, keyPEM []byte, pub crypto.PublicKey) {
+	reg := newTestRegistry(t)
+	plain := []byte("foo-layer-1")
+	encrypted, annotations := encryptLayer(t, pub, plain)
+	encLayer := reg.addBlob(ocispec.MediaTypeImageLayer+encryptedSuffix, encrypted)
+	encLayer.Annotations = annotations
+	plainLayer := reg.addBlob(ocispec.MediaTypeImageLayer, []byte("foo-layer-2"))
+	config := reg.addJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+		RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromBytes(plain), plainLayer.Digest}},
+	})
+	reg.tag("library/foo", "latest", reg.addJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{encLayer, plainLayer},
+	}))
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.All, WithDecryptionKeys(keyPEM))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	data, err := content.ReadBlob(ctx, pvd.ContentStore(), *desc)
+	require.NoError(t, err)
+	var manifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	require.Len(t, manifest.Layers, 2)
+	require.Equal(t, ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(plain),
+		Size:      int64(len(plain)),
+	}, manifest.Layers[0])
+	require.Equal(t, plainLayer, manifest.Layers[1])
+	data, err = content.ReadBlob(ctx, pvd.ContentStore(), manifest.Layers[0])
+	require.NoError(t, err)
+	require.Equal(t, plain, data)
+	// The encrypted layer is deleted once decrypted.
+	_, err = pvd.ContentStore().Info(ctx, encLayer.Digest)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	// The layers can't be decrypted without the matching key.
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pvd, _, err = NewLocalProvider(t.TempDir(), hosts, platforms.All, WithDecryptionKeys(privateKeyPEM(t, otherKey)))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	require.ErrorContains(t, pvd.Pull(ctx, ref), "none of the private keys could be used")
+
+	_, _, err = NewLocalProvider(t.TempDir(), hosts, platforms.All, WithDecryptionKeys([]byte("invalid")))
+	require.ErrorContains(t, err, "parse decryption key")
+}
@@ -0,0 +1,161 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// WithDedupRoot shares the blobs of providers with different work
+// directories through the directory root: the committed blobs are
+// hard-linked into root, and the blobs found in root are hard-linked
+// into the content directory instead of being pulled again. The blobs
+// are copied if hard-linking isn't possible, e.g. root is on another
+// device. The blobs in root are never removed by provider.
+func WithDedupRoot(root string) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.dedupRoot = root
+		return nil
+	}
+}
+
+// dedupStore links the blobs of local store with the shared directory,
+// both of them use the layout blobs/<algorithm>/<encoded>.
+type dedupStore struct {
+	content.Store
+	root   string
+	shared string
+}
+
+func newDedupStore(store content.Store, root, shared string) (*dedupStore, error) {
+	if err := os.MkdirAll(filepath.Join(shared, "blobs"), defaultDirPerm); err != nil {
+		return nil, errors.Wrap(err, "create dedup directory")
+	}
+	return &dedupStore{Store: store, root: root, shared: shared}, nil
+}
+
+func blobPath(root string, dgst digest.Digest) string {
+	return filepath.Join(root, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// Info is called by the metadata database before writing a blob, so the
+// blob found in shared directory is linked here to skip the writing.
+func (s *dedupStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	info, err := s.Store.Info(ctx, dgst)
+	if err == nil || !errdefs.IsNotFound(err) || dgst.Validate() != nil {
+		return info, err
+	}
+	shared := blobPath(s.shared, dgst)
+	if _, statErr := os.Stat(shared); statErr != nil {
+		return info, err
+	}
+	if linkErr := linkOrCopy(shared, blobPath(s.root, dgst)); linkErr != nil && !os.IsExist(linkErr) {
+		return info, errors.Wrapf(linkErr, "link shared blob %s", dgst)
+	}
+	return s.Store.Info(ctx, dgst)
+}
+
+func (s *dedupStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	w, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &dedupWriter{Writer: w, store: s}, nil
+}
+
+// publish links the committed blob into shared directory, it's skipped
+// if the blob has been shared by another provider.
+func (s *dedupStore) publish(dgst digest.Digest) error {
+	if err := linkOrCopy(blobPath(s.root, dgst), blobPath(s.shared, dgst)); err != nil && !os.IsExist(err) {
+		return errors.Wrapf(err, "share blob %s", dgst)
+	}
+	return nil
+}
+
+type dedupWriter struct {
+	content.Writer
+	store *dedupStore
+}
+
+func (w *dedupWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	dgst := expected
+	if dgst == "" {
+		dgst = w.Writer.Digest()
+	}
+	if err := w.Writer.Commit(ctx, size, expected, opts...); err != nil {
+		if !errdefs.IsAlreadyExists(err) {
+			return err
+		}
+		if perr := w.store.publish(dgst); perr != nil {
+			return perr
+		}
+		return err
+	}
+	return w.store.publish(dgst)
+}
+
+// linkOrCopy hard-links src to dst, src is copied to a temporary file
+// renamed to dst if hard-linking fails, an error satisfying os.IsExist
+// is returned if dst exists.
+func linkOrCopy(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return os.ErrExist
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), defaultDirPerm); err != nil {
+		return err
+	}
+	err := os.Link(src, dst)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+	return copyFile(src, dst)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".dedup-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
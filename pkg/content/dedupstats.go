@@ -0,0 +1,126 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// DedupStats accounts the storage of an image in content store, where
+// the blobs are stored once by digest however many images reference them.
+// It's a blob-level approximation computed from the images in provider
+// when queried, the chunks shared by different blobs, e.g. the nydus
+// chunks deduplicated by the conversion, aren't accounted.
+type DedupStats struct {
+	// LogicalBytes is the size of image without dedup, the blob referenced
+	// more than once, e.g. the empty layer, is counted for each reference.
+	LogicalBytes int64
+	// StoredBytes is the size of distinct blobs of image.
+	StoredBytes int64
+	// UniqueBytes is the size of blobs referenced by the image only, that
+	// is, the bytes reclaimed if the image is deleted.
+	UniqueBytes int64
+}
+
+// Ratio returns the fraction of LogicalBytes saved by dedup, i.e. not
+// stored for the image only, which is 0 if no blob is shared and 1 if all
+// the blobs are shared with other images.
+func (stats DedupStats) Ratio() float64 {
+	if stats.LogicalBytes == 0 {
+		return 0
+	}
+	return 1 - float64(stats.UniqueBytes)/float64(stats.LogicalBytes)
+}
+
+// DedupStats returns the storage accounting of image ref against the other
+// images in provider, the blobs of image not in content store, e.g. the
+// manifests of other platforms, are excluded.
+func (pvd *LocalProvider) DedupStats(ctx context.Context, ref string) (DedupStats, error) {
+	if pvd.isClosed() {
+		return DedupStats{}, ErrClosed
+	}
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return DedupStats{}, err
+	}
+	pvd.mutex.Lock()
+	desc, ok := pvd.images[ref]
+	if !ok {
+		pvd.mutex.Unlock()
+		return DedupStats{}, errdefs.ErrNotFound
+	}
+	target := *desc
+	others := make([]ocispec.Descriptor, 0, len(pvd.images))
+	for name, desc := range pvd.images {
+		if name != ref {
+			others = append(others, *desc)
+		}
+	}
+	pvd.mutex.Unlock()
+
+	var stats DedupStats
+	blobs := map[digest.Digest]int64{}
+	if err := pvd.walkBlobs(ctx, func(desc ocispec.Descriptor) {
+		stats.LogicalBytes += desc.Size
+		blobs[desc.Digest] = desc.Size
+	}, target); err != nil {
+		return DedupStats{}, errors.Wrapf(err, "walk image %s", ref)
+	}
+
+	shared := map[digest.Digest]struct{}{}
+	if err := pvd.walkBlobs(ctx, func(desc ocispec.Descriptor) {
+		if _, ok := blobs[desc.Digest]; ok {
+			shared[desc.Digest] = struct{}{}
+		}
+	}, others...); err != nil {
+		return DedupStats{}, errors.Wrap(err, "walk other images")
+	}
+
+	for dgst, size := range blobs {
+		stats.StoredBytes += size
+		if _, ok := shared[dgst]; !ok {
+			stats.UniqueBytes += size
+		}
+	}
+
+	return stats, nil
+}
+
+// walkBlobs calls fn with each reference to the blobs of targets in
+// content store.
+func (pvd *LocalProvider) walkBlobs(ctx context.Context, fn func(desc ocispec.Descriptor), targets ...ocispec.Descriptor) error {
+	store := *pvd.store
+	childrenHandler := artifactChildrenHandler(store)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, err := store.Info(ctx, desc.Digest); err != nil {
+			if errdefs.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		fn(desc)
+		return childrenHandler(ctx, desc)
+	})
+	return images.Walk(ctx, handler, targets...)
+}
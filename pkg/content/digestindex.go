@@ -0,0 +1,76 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// WithDigestIndex indexes the images by manifest digest besides the
+// references, so Image accepts the digest like `sha256:...` of the image
+// pulled or tagged by any reference.
+func WithDigestIndex() LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.digestIndex = true
+		return nil
+	}
+}
+
+type indexedImage struct {
+	desc *ocispec.Descriptor
+	// refs are the references of image, the digest is unindexed once
+	// all of them are deleted.
+	refs map[string]struct{}
+}
+
+// digestIndex maps the manifest digests to images, it's nil if the
+// index is disabled, then all the methods are no-op.
+type digestIndex map[digest.Digest]*indexedImage
+
+func (idx digestIndex) add(ref string, desc *ocispec.Descriptor) {
+	if idx == nil {
+		return
+	}
+	image, ok := idx[desc.Digest]
+	if !ok {
+		image = &indexedImage{refs: map[string]struct{}{}}
+		idx[desc.Digest] = image
+	}
+	image.desc = desc
+	image.refs[ref] = struct{}{}
+}
+
+func (idx digestIndex) remove(ref string, dgst digest.Digest) {
+	if idx == nil {
+		return
+	}
+	image, ok := idx[dgst]
+	if !ok {
+		return
+	}
+	delete(image.refs, ref)
+	if len(image.refs) == 0 {
+		delete(idx, dgst)
+	}
+}
+
+func (idx digestIndex) get(dgst digest.Digest) (*ocispec.Descriptor, bool) {
+	image, ok := idx[dgst]
+	if !ok {
+		return nil, false
+	}
+	return image.desc, true
+}
@@ -0,0 +1,232 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// dockerArchiveManifest is the entry of `manifest.json` in the archive
+// created by `docker save`.
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// ExportDockerArchive streams the pulled image of reference to w in the
+// tar format of `docker save`, which is accepted by `docker load`. The
+// pulled manifest best matching the platforms of provider is exported for
+// an image index, the other references of the same image are recorded as
+// its tags, and the layers are decompressed into the `layer.tar` entries.
+func (pvd *LocalProvider) ExportDockerArchive(ctx context.Context, ref string, w io.Writer) error {
+	if pvd.isClosed() {
+		return ErrClosed
+	}
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return err
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	target, err := pvd.getImage(ctx, ref)
+	if err != nil {
+		return errors.Wrapf(err, "get image %s", ref)
+	}
+	store := *pvd.store
+	manifestDesc, err := matchManifest(ctx, store, *target, pvd.platformMC)
+	if err != nil {
+		return err
+	}
+	var manifest ocispec.Manifest
+	if err := readJSON(ctx, store, manifestDesc, &manifest); err != nil {
+		return errors.Wrapf(err, "read manifest %s", manifestDesc.Digest)
+	}
+
+	tw := tar.NewWriter(w)
+	entry := dockerArchiveManifest{
+		Config:   manifest.Config.Digest.Encoded() + ".json",
+		RepoTags: pvd.repoTags(ref, target.Digest),
+		Layers:   []string{},
+	}
+	config, err := content.ReadBlob(ctx, store, manifest.Config)
+	if err != nil {
+		return errors.Wrapf(err, "read config %s", manifest.Config.Digest)
+	}
+	if err := writeTarFile(tw, entry.Config, config); err != nil {
+		return err
+	}
+
+	written := map[digest.Digest]struct{}{}
+	var diffID digest.Digest
+	for _, layer := range manifest.Layers {
+		if diffID, err = exportDockerLayer(ctx, tw, store, layer, written); err != nil {
+			return errors.Wrapf(err, "export layer %s", layer.Digest)
+		}
+		entry.Layers = append(entry.Layers, diffID.Encoded()+"/layer.tar")
+	}
+
+	data, err := json.Marshal([]dockerArchiveManifest{entry})
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "manifest.json", data); err != nil {
+		return err
+	}
+	// The legacy `repositories` file maps the tags to the top layer.
+	repositories := map[string]map[string]string{}
+	for _, tag := range entry.RepoTags {
+		named, err := docker.ParseDockerRef(tag)
+		if err != nil || diffID == "" {
+			continue
+		}
+		tagged, ok := named.(docker.Tagged)
+		if !ok {
+			continue
+		}
+		repo := docker.FamiliarName(named)
+		if repositories[repo] == nil {
+			repositories[repo] = map[string]string{}
+		}
+		repositories[repo][tagged.Tag()] = diffID.Encoded()
+	}
+	if data, err = json.Marshal(repositories); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "repositories", data); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// repoTags returns the familiar tags of the references to the image,
+// starting with ref, the references pinned by digest are excluded.
+func (pvd *LocalProvider) repoTags(ref string, dgst digest.Digest) []string {
+	pvd.mutex.Lock()
+	refs := []string{}
+	for name, desc := range pvd.images {
+		if name != ref && desc.Digest == dgst {
+			refs = append(refs, name)
+		}
+	}
+	pvd.mutex.Unlock()
+	sort.Strings(refs)
+
+	tags := []string{}
+	for _, name := range append([]string{ref}, refs...) {
+		named, err := docker.ParseDockerRef(name)
+		if err != nil {
+			continue
+		}
+		if _, ok := named.(docker.Tagged); ok {
+			tags = append(tags, docker.FamiliarString(named))
+		}
+	}
+	return tags
+}
+
+// exportDockerLayer writes the decompressed layer as `<diff id>/layer.tar`
+// and returns the diff id, the layer written already is skipped. The tar
+// header needs the size in advance, so the compressed layer is read twice:
+// once for the size and diff id, and once for the content.
+func exportDockerLayer(ctx context.Context, tw *tar.Writer, store content.Provider, layer ocispec.Descriptor, written map[digest.Digest]struct{}) (digest.Digest, error) {
+	diffID, size := layer.Digest, layer.Size
+	compressed := !isUncompressedLayer(layer.MediaType)
+	if compressed {
+		digester := digest.Canonical.Digester()
+		n, err := copyLayer(ctx, digester.Hash(), store, layer)
+		if err != nil {
+			return "", err
+		}
+		diffID, size = digester.Digest(), n
+	}
+	if _, ok := written[diffID]; ok {
+		return diffID, nil
+	}
+	written[diffID] = struct{}{}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     diffID.Encoded() + "/",
+		Mode:     0755,
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return "", err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     diffID.Encoded() + "/layer.tar",
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return "", err
+	}
+	if _, err := copyLayer(ctx, tw, store, layer); err != nil {
+		return "", err
+	}
+	return diffID, nil
+}
+
+// copyLayer copies the decompressed layer to w.
+func copyLayer(ctx context.Context, w io.Writer, store content.Provider, layer ocispec.Descriptor) (int64, error) {
+	ra, err := store.ReaderAt(ctx, layer)
+	if err != nil {
+		return 0, err
+	}
+	defer ra.Close()
+	rc, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, rc)
+}
+
+func isUncompressedLayer(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageLayer || mediaType == images.MediaTypeDockerSchema2Layer
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return errors.Wrapf(err, "write header of %s", name)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return errors.Wrapf(err, "write %s", name)
+	}
+	return nil
+}
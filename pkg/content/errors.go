@@ -0,0 +1,107 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+)
+
+// The kinds of registry failures returned by Pull and Push, the errors
+// match the kinds by `errors.Is` and keep the underlying causes, such as
+// the `ErrUnexpectedStatus` of containerd for `errors.As`.
+var (
+	// ErrUnauthorized is returned if the registry rejects the credential
+	// with 401 or 403.
+	ErrUnauthorized = errors.New("registry unauthorized")
+	// ErrNotFound is returned if the image or blob doesn't exist in
+	// registry.
+	ErrNotFound = errors.New("registry not found")
+	// ErrRateLimited is returned if the registry responds with 429.
+	ErrRateLimited = errors.New("registry rate limited")
+	// ErrTimeout is returned if the request to registry times out.
+	ErrTimeout = errors.New("registry timeout")
+)
+
+// registryError tags the error of registry with its kind.
+type registryError struct {
+	kind error
+	err  error
+}
+
+func (e *registryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *registryError) Unwrap() error {
+	return e.err
+}
+
+func (e *registryError) Is(target error) bool {
+	return target == e.kind
+}
+
+// classifyError tags err with the kind of registry failure, err is
+// returned as is if it's not a known registry failure.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var tagged *registryError
+	if errors.As(err, &tagged) {
+		return err
+	}
+	if kind := errorKind(err); kind != nil {
+		return &registryError{kind: kind, err: err}
+	}
+	return err
+}
+
+func errorKind(err error) error {
+	var statusErr remoteerrors.ErrUnexpectedStatus
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ErrUnauthorized
+		case http.StatusNotFound:
+			return ErrNotFound
+		case http.StatusTooManyRequests:
+			return ErrRateLimited
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return ErrTimeout
+		}
+		return nil
+	}
+	if errors.Is(err, docker.ErrInvalidAuthorization) {
+		return ErrUnauthorized
+	}
+	if errdefs.IsNotFound(err) {
+		return ErrNotFound
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryErrors(t *testing.T) {
+	reg := newTestRegistry(t)
+	desc := reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+	ref := reg.ref("library/foo:latest")
+	pvd := newTestProvider(t)
+	ctx := testContext()
+
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return true
+		}
+		return false
+	})
+	err := pvd.Pull(ctx, ref)
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.NotErrorIs(t, err, ErrNotFound)
+	var statusErr remoteerrors.ErrUnexpectedStatus
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusTooManyRequests, statusErr.StatusCode)
+
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return false
+	})
+	pvd.SetPerRequestTimeout(50 * time.Millisecond)
+	require.ErrorIs(t, pvd.Pull(ctx, ref), ErrTimeout)
+	pvd.SetPerRequestTimeout(0)
+
+	reg.setHook(nil)
+	err = pvd.Pull(ctx, reg.ref("library/foo:notfound"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	// The blob upload is rate limited.
+	require.NoError(t, pvd.Pull(ctx, ref))
+	target := newTestRegistry(t)
+	target.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.Contains(r.URL.Path, "/blobs/uploads/") {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return true
+		}
+		return false
+	})
+	require.ErrorIs(t, pvd.Push(ctx, desc, target.ref("library/foo:latest")), ErrRateLimited)
+
+	reg.enableAuth("foo", "bar")
+	pvd = newTestProviderWithCred(t, t.TempDir(), func(string) (string, string, error) {
+		return "foo", "wrong", nil
+	})
+	err = pvd.Pull(ctx, ref)
+	require.ErrorIs(t, err, ErrUnauthorized)
+	require.NotErrorIs(t, err, ErrRateLimited)
+}
@@ -0,0 +1,138 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// lastAccessLabel records the last access time of image in the image
+// store, which is used to evict the least recently used images.
+const lastAccessLabel = "goharbor.io/acceleration-service.last-access"
+
+var _ Acquirer = &LocalProvider{}
+
+// SetMaxSize enables the provider to evict the least recently used
+// images after each successful Pull until the size of content store
+// drops below the max bytes, it's disabled if max <= 0.
+func (pvd *LocalProvider) SetMaxSize(max int64) {
+	pvd.maxSize = max
+}
+
+func (pvd *LocalProvider) Acquire(ref string) func() {
+	// The operations fail on the reference which can't be rewritten, so
+	// the normalized one is acquired instead.
+	if rewritten, err := pvd.rewriteRef(ref); err == nil {
+		ref = rewritten
+	} else {
+		ref = normalizeRef(ref)
+	}
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	pvd.inUse[ref]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			pvd.mutex.Lock()
+			defer pvd.mutex.Unlock()
+			pvd.inUse[ref]--
+			if pvd.inUse[ref] <= 0 {
+				delete(pvd.inUse, ref)
+			}
+		})
+	}
+}
+
+// evict deletes the least recently used images except the pulled one and
+// the acquired ones, until the size of content store drops below max size.
+func (pvd *LocalProvider) evict(ctx context.Context, pulled string) error {
+	pvd.gcMutex.Lock()
+	defer pvd.gcMutex.Unlock()
+
+	size, err := pvd.Usage(ctx)
+	if err != nil {
+		return err
+	}
+	if size <= pvd.maxSize {
+		return nil
+	}
+
+	// Reclaim the unreferenced blobs before evicting any image.
+	if _, err := pvd.garbageCollect(ctx); err != nil {
+		return err
+	}
+
+	imgs, err := pvd.imageStore.List(ctx)
+	if err != nil {
+		return errors.Wrap(err, "list images")
+	}
+	sort.SliceStable(imgs, func(i, j int) bool {
+		return lastAccess(imgs[i]).Before(lastAccess(imgs[j]))
+	})
+
+	for _, img := range imgs {
+		if size, err = pvd.Usage(ctx); err != nil {
+			return err
+		}
+		if size <= pvd.maxSize {
+			return nil
+		}
+		if img.Name == pulled || pvd.isInUse(img.Name) {
+			continue
+		}
+		logrus.Infof("evict image %s, content store size %d exceeds %d", img.Name, size, pvd.maxSize)
+		if err := pvd.deleteImage(ctx, img.Name); err != nil {
+			return errors.Wrapf(err, "delete image %s", img.Name)
+		}
+		if _, err := pvd.garbageCollect(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pvd *LocalProvider) isInUse(ref string) bool {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	return pvd.inUse[ref] > 0
+}
+
+// touchImage updates the last access time of image.
+func (pvd *LocalProvider) touchImage(ctx context.Context, ref string) error {
+	_, err := pvd.imageStore.Update(ctx, images.Image{
+		Name: ref,
+		Labels: map[string]string{
+			lastAccessLabel: time.Now().UTC().Format(time.RFC3339Nano),
+		},
+	}, "labels."+lastAccessLabel)
+	return err
+}
+
+func lastAccess(img images.Image) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, img.Labels[lastAccessLabel])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,57 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Exists checks whether the manifest of ref exists in registry by a HEAD
+// request, the descriptor is built from the response headers (digest, size
+// and media type) without fetching the manifest. The manifest is fetched
+// only if the registry doesn't answer the digest header for HEAD requests.
+// It returns false without error if the manifest is not found.
+func (pvd *LocalProvider) Exists(ctx context.Context, ref string) (bool, ocispec.Descriptor, error) {
+	if pvd.isClosed() {
+		return false, ocispec.Descriptor{}, ErrClosed
+	}
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return false, ocispec.Descriptor{}, err
+	}
+
+	opts := []remote.ResolverOpt{}
+	if pvd.anonymousFallback {
+		opts = append(opts, remote.WithAnonymousFallback())
+	}
+	resolver, err := pvd.newResolver(ref, opts...)
+	if err != nil {
+		return false, ocispec.Descriptor{}, err
+	}
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, ocispec.Descriptor{}, nil
+		}
+		return false, ocispec.Descriptor{}, classifyError(errors.Wrapf(err, "resolve reference %s", ref))
+	}
+
+	return true, desc, nil
+}
@@ -0,0 +1,110 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/remotes"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// foreignResolver fetches the layers with `urls`, like the foreign layers
+// of Windows images, from the URLs in order by client, which has the TLS,
+// proxy and timeout options of registry rather than the default client of
+// containerd, the registry is tried at last.
+type foreignResolver struct {
+	remotes.Resolver
+	client *http.Client
+}
+
+func newForeignResolver(resolver remotes.Resolver, client *http.Client) remotes.Resolver {
+	return &foreignResolver{Resolver: resolver, client: client}
+}
+
+func (r *foreignResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &foreignFetcher{Fetcher: fetcher, client: r.client}, nil
+}
+
+type foreignFetcher struct {
+	remotes.Fetcher
+	client *http.Client
+}
+
+func (f *foreignFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if len(desc.URLs) == 0 || !images.IsLayerType(desc.MediaType) {
+		return f.Fetcher.Fetch(ctx, desc)
+	}
+
+	var firstErr error
+	for _, u := range desc.URLs {
+		rc, err := f.fetchURL(ctx, u)
+		if err == nil {
+			return rc, nil
+		}
+		log.G(ctx).WithError(err).Debugf("fetch layer %s from %s", desc.Digest, u)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// The layer may be mirrored by registry as well.
+	desc.URLs = nil
+	rc, err := f.Fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrapf(firstErr, "fetch layer %s from urls", desc.Digest)
+	}
+	return rc, nil
+}
+
+func (f *foreignFetcher) fetchURL(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Wrapf(errdefs.ErrNotImplemented, "unsupported url scheme %s", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, errors.Wrapf(errdefs.ErrNotFound, "url %s", rawURL)
+		}
+		return nil, remoteerrors.NewUnexpectedStatusErr(resp)
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,242 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ManifestFormat is the media type format of the manifests and indexes
+// pushed to registry.
+type ManifestFormat string
+
+const (
+	// ManifestFormatOCI pushes the OCI image manifests and indexes.
+	ManifestFormatOCI ManifestFormat = "oci"
+	// ManifestFormatDocker pushes the Docker schema2 manifests and manifest
+	// lists, which are understood by the older registries.
+	ManifestFormatDocker ManifestFormat = "docker"
+)
+
+// WithManifestFormat translates the media types of the pushed manifests,
+// indexes, configs and layers into the format, the translated manifests
+// are written into content store and their digests are recomputed. The Push
+// fails if the image uses the features which are not supported by the
+// format, e.g. the annotations of OCI manifest in Docker format.
+func WithManifestFormat(format ManifestFormat) PushOpt {
+	return func(opts *PushOpts) error {
+		switch format {
+		case ManifestFormatOCI, ManifestFormatDocker:
+		default:
+			return errors.Errorf("unsupported manifest format %q", format)
+		}
+		opts.manifestFormat = format
+		return nil
+	}
+}
+
+// The fields of manifest and index, the other fields would be lost by
+// the translation.
+var (
+	ociManifestFields    = []string{"schemaVersion", "mediaType", "config", "layers", "subject", "annotations"}
+	ociIndexFields       = []string{"schemaVersion", "mediaType", "manifests", "annotations"}
+	dockerManifestFields = []string{"schemaVersion", "mediaType", "config", "layers"}
+	dockerIndexFields    = []string{"schemaVersion", "mediaType", "manifests"}
+)
+
+// convertManifestFormat translates the image of desc into the format,
+// the original descriptor is returned if nothing is changed.
+func convertManifestFormat(ctx context.Context, store content.Store, desc ocispec.Descriptor, format ManifestFormat) (ocispec.Descriptor, error) {
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		return convertIndexFormat(ctx, store, desc, format)
+	case images.IsManifestType(desc.MediaType):
+		return convertImageManifestFormat(ctx, store, desc, format)
+	}
+	return desc, errors.Wrapf(errdefs.ErrNotImplemented, "unsupported manifest media type %s", desc.MediaType)
+}
+
+func convertIndexFormat(ctx context.Context, store content.Store, desc ocispec.Descriptor, format ManifestFormat) (ocispec.Descriptor, error) {
+	var index ocispec.Index
+	fields := ociIndexFields
+	if format == ManifestFormatDocker {
+		fields = dockerIndexFields
+	}
+	if err := readManifestJSON(ctx, store, desc, &index, fields); err != nil {
+		return desc, err
+	}
+
+	mediaType, err := formatMediaType(images.MediaTypeDockerSchema2ManifestList, format)
+	if err != nil {
+		return desc, err
+	}
+	changed := index.MediaType != mediaType
+	index.MediaType = mediaType
+	if format == ManifestFormatDocker && len(index.Annotations) > 0 {
+		return desc, errors.Wrapf(errdefs.ErrNotImplemented, "annotations of index %s", desc.Digest)
+	}
+
+	for idx, manifest := range index.Manifests {
+		if err := checkDescriptorFormat(manifest, format); err != nil {
+			return desc, err
+		}
+		converted, err := convertManifestFormat(ctx, store, manifest, format)
+		if err != nil {
+			return desc, errors.Wrapf(err, "convert manifest %s", manifest.Digest)
+		}
+		if converted.Digest != manifest.Digest || converted.MediaType != manifest.MediaType {
+			index.Manifests[idx] = converted
+			changed = true
+		}
+	}
+	if !changed {
+		return desc, nil
+	}
+
+	return writeManifestJSON(ctx, store, desc, mediaType, index)
+}
+
+func convertImageManifestFormat(ctx context.Context, store content.Store, desc ocispec.Descriptor, format ManifestFormat) (ocispec.Descriptor, error) {
+	var manifest ocispec.Manifest
+	fields := ociManifestFields
+	if format == ManifestFormatDocker {
+		fields = dockerManifestFields
+	}
+	if err := readManifestJSON(ctx, store, desc, &manifest, fields); err != nil {
+		return desc, err
+	}
+	if format == ManifestFormatDocker && (len(manifest.Annotations) > 0 || manifest.Subject != nil) {
+		return desc, errors.Wrapf(errdefs.ErrNotImplemented, "annotations or subject of manifest %s", desc.Digest)
+	}
+
+	mediaType, err := formatMediaType(images.MediaTypeDockerSchema2Manifest, format)
+	if err != nil {
+		return desc, err
+	}
+	changed := manifest.MediaType != mediaType
+	manifest.MediaType = mediaType
+
+	blobs := []*ocispec.Descriptor{&manifest.Config}
+	for idx := range manifest.Layers {
+		blobs = append(blobs, &manifest.Layers[idx])
+	}
+	for _, blob := range blobs {
+		if err := checkDescriptorFormat(*blob, format); err != nil {
+			return desc, err
+		}
+		converted, err := formatMediaType(blob.MediaType, format)
+		if err != nil {
+			return desc, err
+		}
+		if converted != blob.MediaType {
+			blob.MediaType = converted
+			changed = true
+		}
+	}
+	if !changed {
+		return desc, nil
+	}
+
+	return writeManifestJSON(ctx, store, desc, mediaType, manifest)
+}
+
+// checkDescriptorFormat checks the fields of descriptor referenced by the
+// manifest or index are supported by the format.
+func checkDescriptorFormat(desc ocispec.Descriptor, format ManifestFormat) error {
+	if format == ManifestFormatDocker && (len(desc.Annotations) > 0 || len(desc.Data) > 0 || desc.ArtifactType != "") {
+		return errors.Wrapf(errdefs.ErrNotImplemented, "annotations, data or artifact type of descriptor %s", desc.Digest)
+	}
+	return nil
+}
+
+// formatMediaType translates the media type into the format, the Docker
+// media types are always able to be translated into OCI.
+func formatMediaType(mediaType string, format ManifestFormat) (string, error) {
+	if format == ManifestFormatOCI {
+		return converter.ConvertDockerMediaTypeToOCI(mediaType), nil
+	}
+
+	switch mediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		return images.MediaTypeDockerSchema2ManifestList, nil
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		return images.MediaTypeDockerSchema2Manifest, nil
+	case ocispec.MediaTypeImageConfig, images.MediaTypeDockerSchema2Config:
+		return images.MediaTypeDockerSchema2Config, nil
+	case ocispec.MediaTypeImageLayerGzip, images.MediaTypeDockerSchema2LayerGzip:
+		return images.MediaTypeDockerSchema2LayerGzip, nil
+	case ocispec.MediaTypeImageLayer, images.MediaTypeDockerSchema2Layer:
+		return images.MediaTypeDockerSchema2Layer, nil
+	case ocispec.MediaTypeImageLayerNonDistributableGzip, images.MediaTypeDockerSchema2LayerForeignGzip:
+		return images.MediaTypeDockerSchema2LayerForeignGzip, nil
+	case ocispec.MediaTypeImageLayerNonDistributable, images.MediaTypeDockerSchema2LayerForeign:
+		return images.MediaTypeDockerSchema2LayerForeign, nil
+	}
+	return "", errors.Wrapf(errdefs.ErrNotImplemented, "media type %s in Docker format", mediaType)
+}
+
+func readManifestJSON(ctx context.Context, store content.Store, desc ocispec.Descriptor, v interface{}, fields []string) error {
+	data, err := content.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return errors.Wrapf(err, "read manifest %s", desc.Digest)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return errors.Wrapf(err, "unmarshal manifest %s", desc.Digest)
+	}
+	for field := range raw {
+		supported := false
+		for _, f := range fields {
+			if f == field {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return errors.Wrapf(errdefs.ErrNotImplemented, "field %q of manifest %s", field, desc.Digest)
+		}
+	}
+
+	return errors.Wrapf(json.Unmarshal(data, v), "unmarshal manifest %s", desc.Digest)
+}
+
+func writeManifestJSON(ctx context.Context, store content.Store, desc ocispec.Descriptor, mediaType string, v interface{}) (ocispec.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return desc, errors.Wrap(err, "marshal manifest")
+	}
+
+	converted := desc
+	converted.MediaType = mediaType
+	converted.Digest = digest.FromBytes(data)
+	converted.Size = int64(len(data))
+	ref := "convert-manifest-format-" + converted.Digest.String()
+	if err := content.WriteBlob(ctx, store, ref, bytes.NewReader(data), converted); err != nil {
+		return desc, errors.Wrapf(err, "write manifest %s", converted.Digest)
+	}
+
+	return converted, nil
+}
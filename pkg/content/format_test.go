@@ -0,0 +1,114 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestFormat(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addIndex("library/foo", "latest",
+		reg.addManifest(&ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("amd64-layer")),
+		reg.addManifest(&ocispec.Platform{OS: "linux", Architecture: "arm64"}, []byte("arm64-layer")),
+	)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	desc, err := pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+	store := pvd.ContentStore()
+
+	readJSON := func(desc ocispec.Descriptor, v interface{}) {
+		data, err := content.ReadBlob(ctx, store, desc)
+		require.NoError(t, err)
+		require.Equal(t, desc.Digest, digest.FromBytes(data))
+		require.Equal(t, desc.Size, int64(len(data)))
+		require.NoError(t, json.Unmarshal(data, v))
+	}
+
+	docker, err := convertManifestFormat(ctx, store, *desc, ManifestFormatDocker)
+	require.NoError(t, err)
+	require.Equal(t, images.MediaTypeDockerSchema2ManifestList, docker.MediaType)
+	require.NotEqual(t, desc.Digest, docker.Digest)
+	var index ocispec.Index
+	readJSON(docker, &index)
+	require.Equal(t, images.MediaTypeDockerSchema2ManifestList, index.MediaType)
+	require.Len(t, index.Manifests, 2)
+	for _, desc := range index.Manifests {
+		require.Equal(t, images.MediaTypeDockerSchema2Manifest, desc.MediaType)
+		require.NotNil(t, desc.Platform)
+		var manifest ocispec.Manifest
+		readJSON(desc, &manifest)
+		require.Equal(t, images.MediaTypeDockerSchema2Manifest, manifest.MediaType)
+		require.Equal(t, images.MediaTypeDockerSchema2Config, manifest.Config.MediaType)
+		require.Equal(t, images.MediaTypeDockerSchema2Layer, manifest.Layers[0].MediaType)
+	}
+
+	// The translation is lossless, so the original digest is restored.
+	oci, err := convertManifestFormat(ctx, store, docker, ManifestFormatOCI)
+	require.NoError(t, err)
+	require.Equal(t, *desc, oci)
+	unchanged, err := convertManifestFormat(ctx, store, *desc, ManifestFormatOCI)
+	require.NoError(t, err)
+	require.Equal(t, *desc, unchanged)
+
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/foo:docker"), WithManifestFormat(ManifestFormatDocker)))
+	reg.mutex.Lock()
+	pushed := reg.tags["library/foo:docker"]
+	mediaType := reg.mediaTypes[pushed]
+	reg.mutex.Unlock()
+	require.Equal(t, docker.Digest, pushed)
+	require.Equal(t, images.MediaTypeDockerSchema2ManifestList, mediaType)
+
+	require.Error(t, pvd.Push(ctx, *desc, reg.ref("library/foo:docker"), WithManifestFormat("unknown")))
+}
+
+func TestManifestFormatOCIOnly(t *testing.T) {
+	reg := newTestRegistry(t)
+	layer := reg.addBlob(ocispec.MediaTypeImageLayer, []byte("foo-layer"))
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: reg.addJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+			RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layer.Digest}},
+		}),
+		Layers:      []ocispec.Descriptor{layer},
+		Annotations: map[string]string{"org.opencontainers.image.title": "foo"},
+	}
+	reg.tag("library/foo", "latest", reg.addJSON(ocispec.MediaTypeImageManifest, manifest))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	desc, err := pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+
+	_, err = convertManifestFormat(ctx, pvd.ContentStore(), *desc, ManifestFormatDocker)
+	require.True(t, errdefs.IsNotImplemented(err))
+	err = pvd.Push(ctx, *desc, reg.ref("library/foo:docker"), WithManifestFormat(ManifestFormatDocker))
+	require.True(t, errdefs.IsNotImplemented(err))
+	require.Equal(t, 0, reg.count("PUT", "/manifests/docker"))
+}
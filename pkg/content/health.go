@@ -0,0 +1,83 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// HealthCheck checks the metadata database is open and the content
+// directory is writable (readable in read-only mode), and if ref isn't
+// empty, checks the registry of ref is reachable with the credential by
+// resolving it. The returned error tells whether the store or registry
+// is unhealthy.
+func (pvd *LocalProvider) HealthCheck(ctx context.Context, ref string) error {
+	if err := pvd.checkStore(); err != nil {
+		return errors.Wrap(err, "store is unhealthy")
+	}
+
+	if ref != "" {
+		ref, err := pvd.rewriteRef(ref)
+		if err != nil {
+			return errors.Wrap(err, "registry is unhealthy")
+		}
+		resolver, err := pvd.Resolver(ref)
+		if err != nil {
+			return errors.Wrap(err, "registry is unhealthy")
+		}
+		if _, _, err := resolver.Resolve(ctx, ref); err != nil {
+			return errors.Wrap(err, "registry is unhealthy")
+		}
+	}
+
+	return nil
+}
+
+func (pvd *LocalProvider) checkStore() error {
+	if pvd.isClosed() {
+		return ErrClosed
+	}
+	if err := pvd.db.View(func(tx *bolt.Tx) error {
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "access database")
+	}
+
+	// The content store injected by NewProviderWithStore has no directory.
+	if pvd.contentDir == "" {
+		return nil
+	}
+
+	if pvd.readOnly {
+		if _, err := os.ReadDir(pvd.contentDir); err != nil {
+			return errors.Wrap(err, "read content directory")
+		}
+		return nil
+	}
+	file, err := os.CreateTemp(pvd.contentDir, ".health-")
+	if err != nil {
+		return errors.Wrap(err, "write content directory")
+	}
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		return errors.Wrap(err, "write content directory")
+	}
+
+	return nil
+}
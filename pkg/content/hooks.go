@@ -0,0 +1,82 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Hooks are the optional callbacks invoked by LocalProvider around Pull
+// and Push, e.g. checking the policy before pulling or recording the
+// provenance after pushing. The operation is aborted if a pre-hook returns
+// an error, while the errors of post-hooks are only logged unless
+// FailOnPostHookError is set.
+type Hooks struct {
+	PrePull  func(ctx context.Context, ref string) error
+	PostPull func(ctx context.Context, ref string, desc ocispec.Descriptor) error
+	PrePush  func(ctx context.Context, ref string, desc ocispec.Descriptor) error
+	PostPush func(ctx context.Context, ref string, desc ocispec.Descriptor) error
+	// FailOnPostHookError returns the errors of post-hooks from the
+	// operation, the pulled or pushed image is kept anyway.
+	FailOnPostHookError bool
+}
+
+// SetHooks sets the hooks invoked by Pull and Push.
+func (pvd *LocalProvider) SetHooks(hooks Hooks) {
+	pvd.hooks = hooks
+}
+
+func (hooks *Hooks) prePull(ctx context.Context, ref string) error {
+	if hooks.PrePull == nil {
+		return nil
+	}
+	return errors.Wrap(hooks.PrePull(ctx, ref), "pre-pull hook")
+}
+
+func (hooks *Hooks) postPull(ctx context.Context, ref string, desc ocispec.Descriptor) error {
+	if hooks.PostPull == nil {
+		return nil
+	}
+	return hooks.postHookError(ctx, "post-pull hook", hooks.PostPull(ctx, ref, desc))
+}
+
+func (hooks *Hooks) prePush(ctx context.Context, ref string, desc ocispec.Descriptor) error {
+	if hooks.PrePush == nil {
+		return nil
+	}
+	return errors.Wrap(hooks.PrePush(ctx, ref, desc), "pre-push hook")
+}
+
+func (hooks *Hooks) postPush(ctx context.Context, ref string, desc ocispec.Descriptor) error {
+	if hooks.PostPush == nil {
+		return nil
+	}
+	return hooks.postHookError(ctx, "post-push hook", hooks.PostPush(ctx, ref, desc))
+}
+
+func (hooks *Hooks) postHookError(ctx context.Context, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if hooks.FailOnPostHookError {
+		return errors.Wrap(err, name)
+	}
+	log.G(ctx).WithError(err).Warnf("%s failed", name)
+	return nil
+}
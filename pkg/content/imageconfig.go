@@ -0,0 +1,92 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Config returns the image config of ref matched by the platforms of
+// provider without pulling the layers, only the index, manifest and config
+// are fetched and cached in content store.
+func (pvd *LocalProvider) Config(ctx context.Context, ref string) (ocispec.Image, error) {
+	if pvd.isClosed() {
+		return ocispec.Image{}, ErrClosed
+	}
+	ctx = pvd.withLogger(ctx, log.Fields{"ref": ref})
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return ocispec.Image{}, err
+	}
+
+	resolver, err := pvd.newResolver(ref)
+	if err != nil {
+		return ocispec.Image{}, err
+	}
+	name, target, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return ocispec.Image{}, errors.Wrapf(err, "resolve reference %s", ref)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return ocispec.Image{}, errors.Wrapf(err, "get fetcher for %s", name)
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	provider := &fetchingProvider{store: *pvd.store, fetcher: fetcher}
+	manifest, err := images.Manifest(ctx, provider, target, pvd.platformMC)
+	if err != nil {
+		return ocispec.Image{}, errors.Wrapf(err, "get manifest of %s", ref)
+	}
+	data, err := content.ReadBlob(ctx, provider, manifest.Config)
+	if err != nil {
+		return ocispec.Image{}, errors.Wrapf(err, "read config %s", manifest.Config.Digest)
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(data, &config); err != nil {
+		return ocispec.Image{}, errors.Wrapf(err, "unmarshal config %s", manifest.Config.Digest)
+	}
+
+	return config, nil
+}
+
+// fetchingProvider reads the blobs from content store, the missing blobs
+// are fetched by fetcher into the store first.
+type fetchingProvider struct {
+	store   content.Store
+	fetcher remotes.Fetcher
+}
+
+func (p *fetchingProvider) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	ra, err := p.store.ReaderAt(ctx, desc)
+	if err == nil || !errdefs.IsNotFound(err) {
+		return ra, err
+	}
+	if err := remotes.Fetch(ctx, p.store, p.fetcher, desc); err != nil && !errdefs.IsAlreadyExists(err) {
+		return nil, errors.Wrapf(err, "fetch blob %s", desc.Digest)
+	}
+	return p.store.ReaderAt(ctx, desc)
+}
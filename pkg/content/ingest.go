@@ -0,0 +1,139 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// WithIngestDir writes the blobs being pulled under dir instead of the
+// content directory, e.g. on a faster scratch disk, the blobs are moved
+// into the content directory once committed. The blobs are copied if dir
+// is on another device.
+func WithIngestDir(dir string) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.ingestDir = dir
+		return nil
+	}
+}
+
+// ingestStore keeps the ingests in a separated local store, and moves
+// the committed blobs into content store.
+type ingestStore struct {
+	content.Store
+	root    string
+	ingests content.Store
+	// ingestRoot is the root of ingest store.
+	ingestRoot string
+}
+
+func newIngestStore(store content.Store, root, ingestRoot string, perm os.FileMode) (*ingestStore, error) {
+	if err := os.MkdirAll(ingestRoot, perm); err != nil {
+		return nil, errors.Wrap(err, "create ingest directory")
+	}
+	ingests, err := local.NewStore(ingestRoot)
+	if err != nil {
+		return nil, errors.Wrap(err, "create ingest store")
+	}
+	return &ingestStore{
+		Store:      store,
+		root:       root,
+		ingests:    ingests,
+		ingestRoot: ingestRoot,
+	}, nil
+}
+
+func (s *ingestStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return nil, err
+		}
+	}
+	if wOpts.Desc.Digest != "" {
+		if _, err := s.Store.Info(ctx, wOpts.Desc.Digest); err == nil {
+			return nil, errors.Wrapf(errdefs.ErrAlreadyExists, "content %s", wOpts.Desc.Digest)
+		}
+	}
+	w, err := s.ingests.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ingestWriter{Writer: w, store: s}, nil
+}
+
+func (s *ingestStore) Status(ctx context.Context, ref string) (content.Status, error) {
+	return s.ingests.Status(ctx, ref)
+}
+
+func (s *ingestStore) ListStatuses(ctx context.Context, filters ...string) ([]content.Status, error) {
+	return s.ingests.ListStatuses(ctx, filters...)
+}
+
+func (s *ingestStore) Abort(ctx context.Context, ref string) error {
+	return s.ingests.Abort(ctx, ref)
+}
+
+// move moves the committed blob from ingest store into content store.
+func (s *ingestStore) move(ctx context.Context, dgst digest.Digest) error {
+	src, dst := blobPath(s.ingestRoot, dgst), blobPath(s.root, dgst)
+	if _, err := os.Stat(dst); err == nil {
+		if err := s.ingests.Delete(ctx, dgst); err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+		return errors.Wrapf(errdefs.ErrAlreadyExists, "content %s", dgst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), defaultDirPerm); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+type ingestWriter struct {
+	content.Writer
+	store *ingestStore
+}
+
+func (w *ingestWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	dgst := expected
+	if dgst == "" {
+		dgst = w.Writer.Digest()
+	}
+	// The blob may be left in ingest store by a failed move.
+	if err := w.Writer.Commit(ctx, size, expected, opts...); err != nil && !errdefs.IsAlreadyExists(err) {
+		return err
+	}
+	if err := w.store.move(ctx, dgst); err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return err
+		}
+		return errors.Wrapf(err, "move blob %s into content directory", dgst)
+	}
+	return nil
+}
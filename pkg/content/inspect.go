@@ -0,0 +1,87 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// maxManifestSize limits the size of manifest read into memory.
+const maxManifestSize = 4 << 20
+
+// Inspect resolves the reference and fetches only the manifest or index
+// from registry, nothing is written into content store. It returns the
+// resolved descriptor and the manifests referenced by index, or only the
+// resolved manifest if the reference isn't an index.
+func (pvd *LocalProvider) Inspect(ctx context.Context, ref string) (*ocispec.Descriptor, []ocispec.Descriptor, error) {
+	if pvd.isClosed() {
+		return nil, nil, ErrClosed
+	}
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolver, err := pvd.Resolver(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "resolve reference %s", ref)
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeArtifactManifest:
+		return &desc, []ocispec.Descriptor{desc}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported media type %s of %s", desc.MediaType, ref)
+	}
+
+	if desc.Size > maxManifestSize {
+		return nil, nil, fmt.Errorf("index %s exceeds the max size %d", desc.Digest, maxManifestSize)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "get fetcher for %s", name)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "fetch index %s", desc.Digest)
+	}
+	defer rc.Close()
+
+	bytes, err := io.ReadAll(io.LimitReader(rc, desc.Size))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "read index %s", desc.Digest)
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(bytes); actual != desc.Digest {
+		return nil, nil, fmt.Errorf("index %s has unexpected digest %s", desc.Digest, actual)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(bytes, &index); err != nil {
+		return nil, nil, errors.Wrapf(err, "unmarshal index %s", desc.Digest)
+	}
+
+	return &desc, index.Manifests, nil
+}
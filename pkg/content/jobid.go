@@ -0,0 +1,46 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type jobIDKey struct{}
+
+// WithJobID returns the context carrying the correlation id of job, like
+// the id of conversion, which is added to the log fields and the audit
+// records of Pull and Push.
+func WithJobID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, jobIDKey{}, id)
+}
+
+// JobIDFromContext returns the job id carried by ctx, or empty if none.
+func JobIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(jobIDKey{}).(string)
+	return id
+}
+
+// ensureJobID returns the context carrying a job id, a random request id
+// is generated if ctx carries none.
+func ensureJobID(ctx context.Context) (context.Context, string) {
+	if id := JobIDFromContext(ctx); id != "" {
+		return ctx, id
+	}
+	id := uuid.NewString()
+	return WithJobID(ctx, id), id
+}
@@ -0,0 +1,56 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// WithKnownDigests skips fetching the layers of digests present in
+// content store, such as the shared layers of a previously pulled image,
+// the pulled image references the existing blobs instead. The layers not
+// present in content store are still fetched.
+func WithKnownDigests(digests ...digest.Digest) PullOpt {
+	return func(opts *PullOpts) error {
+		if opts.knownDigests == nil {
+			opts.knownDigests = map[digest.Digest]struct{}{}
+		}
+		for _, dgst := range digests {
+			opts.knownDigests[dgst] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// knownLayerHandler skips the handlers following it for the known layers
+// present in content store.
+func knownLayerHandler(store content.Store, known map[digest.Digest]struct{}) images.HandlerFunc {
+	return func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, ok := known[desc.Digest]; !ok || !images.IsLayerType(desc.MediaType) {
+			return nil, nil
+		}
+		if _, err := store.Info(ctx, desc.Digest); err != nil {
+			return nil, nil
+		}
+		log.G(ctx).WithFields(descFields(desc)).Debug("skip fetching known layer")
+		return nil, images.ErrSkipDesc
+	}
+}
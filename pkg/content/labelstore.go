@@ -0,0 +1,112 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"encoding/json"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// labelBucket is the bucket of metadata database storing the labels of
+// blobs, which is separated from the buckets of containerd metadata.
+var labelBucket = []byte("acceleration-service-labels")
+
+// boltLabelStore persists the labels of blobs in bolt database, so the
+// labels survive the restart of provider.
+type boltLabelStore struct {
+	db *bolt.DB
+}
+
+// NewBoltLabelStore creates a label store persisting the labels of blobs
+// in the bolt database.
+func NewBoltLabelStore(db *bolt.DB) local.LabelStore {
+	return &boltLabelStore{db: db}
+}
+
+func (bls *boltLabelStore) Get(d digest.Digest) (map[string]string, error) {
+	var labels map[string]string
+	if err := bls.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(labelBucket)
+		if bkt == nil {
+			return nil
+		}
+		return unmarshalLabels(bkt.Get([]byte(d)), &labels)
+	}); err != nil {
+		return nil, errors.Wrapf(err, "get labels of %s", d)
+	}
+	return labels, nil
+}
+
+func (bls *boltLabelStore) Set(d digest.Digest, labels map[string]string) error {
+	if err := bls.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(labelBucket)
+		if err != nil {
+			return err
+		}
+		return putLabels(bkt, d, labels)
+	}); err != nil {
+		return errors.Wrapf(err, "set labels of %s", d)
+	}
+	return nil
+}
+
+func (bls *boltLabelStore) Update(d digest.Digest, update map[string]string) (map[string]string, error) {
+	var labels map[string]string
+	if err := bls.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(labelBucket)
+		if err != nil {
+			return err
+		}
+		if err := unmarshalLabels(bkt.Get([]byte(d)), &labels); err != nil {
+			return err
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for k, v := range update {
+			if v == "" {
+				delete(labels, k)
+			} else {
+				labels[k] = v
+			}
+		}
+		return putLabels(bkt, d, labels)
+	}); err != nil {
+		return nil, errors.Wrapf(err, "update labels of %s", d)
+	}
+	return labels, nil
+}
+
+func unmarshalLabels(data []byte, labels *map[string]string) error {
+	if data == nil {
+		return nil
+	}
+	return json.Unmarshal(data, labels)
+}
+
+func putLabels(bkt *bolt.Bucket, d digest.Digest, labels map[string]string) error {
+	if len(labels) == 0 {
+		return bkt.Delete([]byte(d))
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	return bkt.Put([]byte(d), data)
+}
@@ -0,0 +1,277 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	layoutIndexFile = "index.json"
+	layoutBlobsDir  = "blobs"
+)
+
+// ImportFromOCILayout imports the image from the OCI image layout directory
+// into content store, and records it as the source image of reference.
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+func (pvd *LocalProvider) ImportFromOCILayout(ctx context.Context, dir string, ref string) (*ocispec.Descriptor, error) {
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	if pvd.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	// The manifest is matched by the given reference in layout, and the
+	// image is recorded by the rewritten one like the other operations.
+	name, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	bytes, err := os.ReadFile(filepath.Join(dir, layoutIndexFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "read index of OCI layout")
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(bytes, &index); err != nil {
+		return nil, errors.Wrap(err, "unmarshal index of OCI layout")
+	}
+
+	desc, err := matchLayoutManifest(index, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	store := *pvd.store
+	importHandler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if err := desc.Digest.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid digest %s", desc.Digest)
+		}
+		file, err := os.Open(layoutBlobPath(dir, desc))
+		if err != nil {
+			return nil, errors.Wrapf(err, "open blob %s", desc.Digest)
+		}
+		defer file.Close()
+		// The digest and size of blob is validated on commit.
+		if err := content.WriteBlob(ctx, store, "import-"+desc.Digest.String(), file, desc); err != nil {
+			if errdefs.IsAlreadyExists(err) {
+				return nil, nil
+			}
+			return nil, errors.Wrapf(err, "import blob %s", desc.Digest)
+		}
+		return nil, nil
+	})
+	childrenHandler := images.FilterPlatforms(images.SetChildrenLabels(store, artifactChildrenHandler(store)), pvd.platformMC)
+
+	if err := images.Dispatch(ctx, images.Handlers(importHandler, childrenHandler), nil, *desc); err != nil {
+		return nil, errors.Wrap(err, "import OCI layout")
+	}
+
+	if err := pvd.setImage(ctx, name, desc); err != nil {
+		return nil, errors.Wrap(err, "set source image")
+	}
+
+	return desc, nil
+}
+
+// ExportToOCILayout exports the pulled image of reference from content store
+// into the OCI image layout directory, the existing images in the directory
+// are kept unless they have the same reference name. The manifests of the
+// platforms which aren't pulled are skipped.
+func (pvd *LocalProvider) ExportToOCILayout(ctx context.Context, ref string, dir string) error {
+	if pvd.isClosed() {
+		return ErrClosed
+	}
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return err
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	desc, err := pvd.getImage(ctx, ref)
+	if err != nil {
+		return errors.Wrapf(err, "get image %s", ref)
+	}
+
+	store := *pvd.store
+	exportHandler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if err := exportLayoutBlob(ctx, store, dir, desc); err != nil {
+			if errdefs.IsNotFound(err) && (images.IsManifestType(desc.MediaType) || images.IsIndexType(desc.MediaType)) {
+				return nil, images.ErrSkipDesc
+			}
+			return nil, errors.Wrapf(err, "export blob %s", desc.Digest)
+		}
+		return nil, nil
+	})
+	childrenHandler := artifactChildrenHandler(store)
+
+	if err := images.Walk(ctx, images.Handlers(exportHandler, childrenHandler), *desc); err != nil {
+		return errors.Wrap(err, "export OCI layout")
+	}
+
+	layoutFile := filepath.Join(dir, ocispec.ImageLayoutFile)
+	if _, err := os.Stat(layoutFile); os.IsNotExist(err) {
+		layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(layoutFile, layout, 0644); err != nil {
+			return errors.Wrap(err, "write OCI layout file")
+		}
+	}
+
+	return updateLayoutIndex(dir, ref, *desc)
+}
+
+// updateLayoutIndex adds the manifest named by reference into the index
+// of OCI layout, and replaces the manifest with the same name.
+func updateLayoutIndex(dir, ref string, desc ocispec.Descriptor) error {
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+	}
+	indexFile := filepath.Join(dir, layoutIndexFile)
+	bytes, err := os.ReadFile(indexFile)
+	if err == nil {
+		if err := json.Unmarshal(bytes, &index); err != nil {
+			return errors.Wrap(err, "unmarshal index of OCI layout")
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "read index of OCI layout")
+	}
+
+	manifests := []ocispec.Descriptor{}
+	for _, manifest := range index.Manifests {
+		if manifest.Annotations[ocispec.AnnotationRefName] != ref {
+			manifests = append(manifests, manifest)
+		}
+	}
+	annotations := map[string]string{}
+	for k, v := range desc.Annotations {
+		annotations[k] = v
+	}
+	annotations[ocispec.AnnotationRefName] = ref
+	desc.Annotations = annotations
+	index.Manifests = append(manifests, desc)
+
+	bytes, err = json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(indexFile, bytes)
+}
+
+func exportLayoutBlob(ctx context.Context, store content.Store, dir string, desc ocispec.Descriptor) error {
+	path := layoutBlobPath(dir, desc)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	ra, err := store.ReaderAt(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := io.Copy(file, content.NewReader(ra)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// matchLayoutManifest finds the manifest named by the reference in the index
+// of OCI layout, the only manifest is used if there are no named manifests.
+func matchLayoutManifest(index ocispec.Index, ref string) (*ocispec.Descriptor, error) {
+	names := []string{ref}
+	if named, err := docker.ParseDockerRef(ref); err == nil {
+		names = append(names, named.String())
+		if tagged, ok := named.(docker.Tagged); ok {
+			names = append(names, tagged.Tag())
+		}
+	}
+
+	for idx := range index.Manifests {
+		name := index.Manifests[idx].Annotations[ocispec.AnnotationRefName]
+		for _, n := range names {
+			if name != "" && name == n {
+				return &index.Manifests[idx], nil
+			}
+		}
+	}
+
+	if len(index.Manifests) == 1 {
+		return &index.Manifests[0], nil
+	}
+
+	return nil, fmt.Errorf("not found manifest %s in OCI layout", ref)
+}
+
+func layoutBlobPath(dir string, desc ocispec.Descriptor) string {
+	return filepath.Join(dir, layoutBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+}
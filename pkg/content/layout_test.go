@@ -0,0 +1,182 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestLayout writes all blobs of registry and the index
+// referencing the specified manifests as an OCI image layout.
+func writeTestLayout(t *testing.T, dir string, reg *testRegistry, manifests ...ocispec.Descriptor) {
+	for dgst, data := range reg.blobs {
+		blobDir := filepath.Join(dir, layoutBlobsDir, dgst.Algorithm().String())
+		require.NoError(t, os.MkdirAll(blobDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(blobDir, dgst.Encoded()), data, 0644))
+	}
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, layoutIndexFile), index, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ocispec.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+}
+
+func TestImportFromOCILayout(t *testing.T) {
+	reg := newTestRegistry(t)
+	foo := reg.addManifest(nil, []byte("foo-layer-1"), []byte("foo-layer-2"))
+	foo.Annotations = map[string]string{ocispec.AnnotationRefName: "foo"}
+	bar := reg.addManifest(nil, []byte("bar-layer-1"))
+	bar.Annotations = map[string]string{ocispec.AnnotationRefName: "bar"}
+	dir := t.TempDir()
+	writeTestLayout(t, dir, reg, foo, bar)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	desc, err := pvd.ImportFromOCILayout(ctx, dir, "localhost/library/image:foo")
+	require.NoError(t, err)
+	require.Equal(t, foo.Digest, desc.Digest)
+	require.Equal(t, 4, countBlobs(t, pvd))
+
+	image, err := pvd.Image(ctx, "localhost/library/image:foo")
+	require.NoError(t, err)
+	_, err = content.ReadBlob(ctx, pvd.ContentStore(), *image)
+	require.NoError(t, err)
+
+	_, err = pvd.ImportFromOCILayout(ctx, dir, "localhost/library/image:baz")
+	require.Error(t, err)
+}
+
+func TestImportFromOCILayoutWithRewriter(t *testing.T) {
+	reg := newTestRegistry(t)
+	foo := reg.addManifest(nil, []byte("foo-layer-1"))
+	foo.Annotations = map[string]string{ocispec.AnnotationRefName: "foo"}
+	dir := t.TempDir()
+	writeTestLayout(t, dir, reg, foo)
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	rewriter := func(ref string) (string, error) {
+		return strings.Replace(ref, "docker.io/", "localhost/", 1), nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.All, WithReferenceRewriter(rewriter))
+	require.NoError(t, err)
+	ctx := testContext()
+	_, err = pvd.ImportFromOCILayout(ctx, dir, "docker.io/library/image:foo")
+	require.NoError(t, err)
+
+	// The image is found by the same reference and the rewritten one.
+	for _, ref := range []string{"docker.io/library/image:foo", "localhost/library/image:foo"} {
+		image, err := pvd.Image(ctx, ref)
+		require.NoError(t, err, ref)
+		require.Equal(t, foo.Digest, image.Digest)
+	}
+	infos, err := pvd.ListImages(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "localhost/library/image:foo", infos[0].Ref)
+}
+
+func TestImportFromCorruptOCILayout(t *testing.T) {
+	reg := newTestRegistry(t)
+	desc := reg.addManifest(nil, []byte("foo-layer-1"))
+	dir := t.TempDir()
+	writeTestLayout(t, dir, reg, desc)
+
+	var manifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(reg.blobs[desc.Digest], &manifest))
+	layer := manifest.Layers[0]
+	require.NoError(t, os.WriteFile(layoutBlobPath(dir, layer), []byte("corrupted-1"), 0644))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	_, err := pvd.ImportFromOCILayout(ctx, dir, "localhost/library/image:foo")
+	require.ErrorContains(t, err, layer.Digest.String())
+	_, err = pvd.Image(ctx, "localhost/library/image:foo")
+	require.Error(t, err)
+}
+
+func TestExportToOCILayout(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer-1"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	foo, bar := reg.ref("library/foo:latest"), reg.ref("library/bar:latest")
+	require.NoError(t, pvd.Pull(ctx, foo))
+	require.NoError(t, pvd.Pull(ctx, bar))
+
+	dir := t.TempDir()
+	require.NoError(t, pvd.ExportToOCILayout(ctx, foo, dir))
+	require.NoError(t, pvd.ExportToOCILayout(ctx, bar, dir))
+	require.NoError(t, pvd.ExportToOCILayout(ctx, foo, dir))
+	require.FileExists(t, filepath.Join(dir, ocispec.ImageLayoutFile))
+
+	bytes, err := os.ReadFile(filepath.Join(dir, layoutIndexFile))
+	require.NoError(t, err)
+	var index ocispec.Index
+	require.NoError(t, json.Unmarshal(bytes, &index))
+	require.Len(t, index.Manifests, 2)
+
+	imported := newTestProvider(t)
+	for _, ref := range []string{foo, bar} {
+		desc, err := imported.ImportFromOCILayout(ctx, dir, ref)
+		require.NoError(t, err)
+		expected, err := pvd.Image(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, expected.Digest, desc.Digest)
+	}
+	require.Equal(t, countBlobs(t, pvd), countBlobs(t, imported))
+}
+
+func TestExportToOCILayoutPulledPlatform(t *testing.T) {
+	reg := newTestRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	amd64Manifest := reg.addManifest(&amd64, []byte("foo-amd64-layer"))
+	arm64Manifest := reg.addManifest(&arm64, []byte("foo-arm64-layer"))
+	reg.addIndex("library/foo", "latest", amd64Manifest, arm64Manifest)
+
+	// The image is pulled for a platform other than the provider's.
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref, WithPullPlatform(platforms.OnlyStrict(arm64))))
+
+	dir := t.TempDir()
+	require.NoError(t, pvd.ExportToOCILayout(ctx, ref, dir))
+	require.FileExists(t, layoutBlobPath(dir, arm64Manifest))
+	require.FileExists(t, layoutBlobPath(dir, ocispec.Descriptor{Digest: digest.FromBytes([]byte("foo-arm64-layer"))}))
+	require.NoFileExists(t, layoutBlobPath(dir, amd64Manifest))
+
+	require.NoError(t, pvd.Close())
+	require.ErrorIs(t, pvd.ExportToOCILayout(ctx, ref, dir), ErrClosed)
+}
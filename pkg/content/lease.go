@@ -0,0 +1,98 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// defaultLeaseExpiration expires the lease left by a crashed process, so
+// the blobs protected by it can be reclaimed eventually.
+const defaultLeaseExpiration = 24 * time.Hour
+
+// WithLease creates a lease and returns the context carrying it, the
+// blobs of images pulled and the blobs written with the context are not
+// reclaimed by garbage collection until the release function is called,
+// even if the images are deleted, e.g. around the conversion and push of
+// an image.
+func (pvd *LocalProvider) WithLease(ctx context.Context) (context.Context, func() error, error) {
+	if pvd.isClosed() {
+		return nil, nil, ErrClosed
+	}
+	if pvd.readOnly {
+		return nil, nil, ErrReadOnly
+	}
+
+	lease, err := pvd.leaseManager.Create(
+		namespaces.WithNamespace(ctx, pvd.namespace),
+		leases.WithRandomID(),
+		leases.WithExpiration(defaultLeaseExpiration),
+	)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create lease")
+	}
+
+	release := func() error {
+		// The lease is released even if the context is canceled.
+		ctx := namespaces.WithNamespace(context.Background(), pvd.namespace)
+		if err := pvd.leaseManager.Delete(ctx, lease); err != nil && !errdefs.IsNotFound(err) {
+			return errors.Wrapf(err, "delete lease %s", lease.ID)
+		}
+		return nil
+	}
+
+	return leases.WithLease(ctx, lease.ID), release, nil
+}
+
+// leaseImage adds the blobs of image matched by platform to the lease of
+// context, the blobs written with the lease are added by content store,
+// but the existing blobs have to be added explicitly.
+func (pvd *LocalProvider) leaseImage(ctx context.Context, target ocispec.Descriptor, platformMC platforms.MatchComparer) error {
+	id, ok := leases.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	lease := leases.Lease{ID: id}
+	ctx = namespaces.WithNamespace(ctx, pvd.namespace)
+	store := *pvd.store
+	childrenHandler := images.FilterPlatforms(artifactChildrenHandler(store), platformMC)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, err := store.Info(ctx, desc.Digest); err != nil {
+			if errdefs.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if err := pvd.leaseManager.AddResource(ctx, lease, leases.Resource{
+			ID:   desc.Digest.String(),
+			Type: "content",
+		}); err != nil {
+			return nil, errors.Wrapf(err, "add blob %s to lease %s", desc.Digest, id)
+		}
+		return childrenHandler(ctx, desc)
+	})
+
+	return images.Walk(ctx, handler, target)
+}
@@ -16,124 +16,1179 @@ package content
 
 import (
 	"context"
+	"crypto"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/content/local"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/gc"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/metadata"
 	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
 	"github.com/containerd/containerd/remotes"
+	encconfig "github.com/containers/ocicrypt/config"
+	"github.com/goharbor/acceleration-service/pkg/metrics"
 	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 )
 
+// defaultMaxConcurrentDownloads limits the number of layers fetched
+// in parallel from registry to avoid being rate limited.
+const defaultMaxConcurrentDownloads = 3
+
+// defaultMaxConcurrentUploads limits the number of layers uploaded in
+// parallel to registry, the manifests are pushed after their blobs.
+const defaultMaxConcurrentUploads = 3
+
+// ErrReadOnly is returned by the methods mutating the content store or
+// registry if the provider is created with WithReadOnly.
+var ErrReadOnly = errors.New("local provider is read-only")
+
 type LocalProvider struct {
-	mutex        sync.Mutex
-	images       map[string]*ocispec.Descriptor
-	usePlainHTTP bool
-	store        *content.Store
-	hosts        remote.HostFunc
-	platformMC   platforms.MatchComparer
+	mutex sync.Mutex
+	// gcMutex prevents garbage collection from reclaiming the blobs
+	// of images which are being pulled.
+	gcMutex                sync.RWMutex
+	images                 map[string]*ocispec.Descriptor
+	bdb                    *bolt.DB
+	db                     *metadata.DB
+	imageStore             images.Store
+	usePlainHTTP           bool
+	maxConcurrentDownloads int
+	maxConcurrentUploads   int
+	retryConfig            RetryConfig
+	resolverOpts           []remote.ResolverOpt
+	anonymousFallback      bool
+	transferMetric         *metrics.TransferMetric
+	bandwidthLimiter       *rate.Limiter
+	cacheMetric            *metrics.CacheMetric
+	inFlightMetric         *metrics.InFlightMetric
+	statusMetric           *metrics.StatusMetric
+	verifyOnPull           bool
+	resumeDownloads        bool
+	perRequestTimeout      time.Duration
+	maxSize                int64
+	// inUse counts the acquired references of images, which are never evicted.
+	inUse      map[string]int
+	gcInterval int
+	pushCount  int
+	store      *content.Store
+	contentDir string
+	// tempDir holds the metadata database of MemoryProvider, it's
+	// removed on Close.
+	tempDir string
+	// backend is the underlying content store of metadata database.
+	backend    content.Store
+	hosts      remote.HostFunc
+	platformMC platforms.MatchComparer
+	logger     *logrus.Entry
+	readOnly   bool
+	closed     bool
+	// shuttingDown rejects new operations while draining operations.
+	shuttingDown bool
+	operations   sync.WaitGroup
+	// decryptConfig decrypts the encrypted layers on Pull.
+	decryptConfig *encconfig.DecryptConfig
+	// ciphertexts are the encrypted layers decrypted on Pull, which are
+	// deleted once the decrypted images are recorded.
+	ciphertexts map[digest.Digest]struct{}
+	// namespace isolates the blobs, images and leases in metadata database.
+	namespace    string
+	leaseManager leases.Manager
+	// reproducible makes the repackaged blobs byte-identical.
+	reproducible bool
+	// verificationKeys verify the cosign signatures of images on Pull.
+	verificationKeys []crypto.PublicKey
+	hooks            Hooks
+	// pending are the images imported from manifest list but not pulled.
+	pending map[string]ocispec.Descriptor
+	tracer  trace.Tracer
+	// digests indexes the images by manifest digest if enabled.
+	digests digestIndex
+	// pullGroup shares the concurrent pulls of the same reference.
+	pullGroup singleflight.Group
+	// fetchGroup shares the concurrent fetches of the same blob.
+	fetchGroup     singleflight.Group
+	auditLog       *auditLog
+	fetchScheduler *fetchScheduler
+	maxImageSize   int64
+	// referenceRewriter rewrites the references of operations if set.
+	referenceRewriter ReferenceRewriter
 }
 
+// NewLocalProvider creates a provider with the content store and metadata
+// database in workDir. The images of the host platform are pulled if
+// platformMC is nil, see also platforms.DefaultStrict.
 func NewLocalProvider(
 	workDir string,
 	hosts remote.HostFunc,
 	platformMC platforms.MatchComparer,
-) (Provider, *metadata.DB, error) {
+	opts ...LocalProviderOpt,
+) (*LocalProvider, *metadata.DB, error) {
+	options := LocalProviderOpts{
+		dirPerm:   defaultDirPerm,
+		dbPerm:    defaultDBPerm,
+		logger:    logrus.NewEntry(logrus.StandardLogger()),
+		namespace: DefaultNamespace,
+	}
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return nil, nil, errors.Wrap(err, "apply local provider option")
+		}
+	}
+
 	contentDir := filepath.Join(workDir, "content")
-	if err := os.MkdirAll(contentDir, 0755); err != nil {
-		return nil, nil, errors.Wrap(err, "create local provider work directory")
+	if !options.readOnly {
+		if err := os.MkdirAll(contentDir, options.dirPerm); err != nil {
+			return nil, nil, errors.Wrap(err, "create local provider work directory")
+		}
+		// The permission isn't changed by MkdirAll if the directory exists.
+		if err := os.Chmod(contentDir, options.dirPerm); err != nil {
+			return nil, nil, errors.Wrap(err, "change permission of local provider work directory")
+		}
 	}
-	store, err := local.NewLabeledStore(contentDir, newMemoryLabelStore())
+	dbPath := filepath.Join(workDir, "meta.db")
+	bdb, err := bolt.Open(dbPath, options.dbPerm, &bolt.Options{ReadOnly: options.readOnly})
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "create local provider content store")
+		return nil, nil, errors.Wrap(err, "create local provider database")
+	}
+	if !options.readOnly {
+		if err := os.Chmod(dbPath, options.dbPerm); err != nil {
+			bdb.Close()
+			return nil, nil, errors.Wrap(err, "change permission of local provider database")
+		}
+	}
+	labelStore := newMemoryLabelStore()
+	if options.labelStore != nil {
+		labelStore = options.labelStore(bdb)
 	}
-	bdb, err := bolt.Open(filepath.Join(workDir, "meta.db"), 0655, nil)
+	store, err := local.NewLabeledStore(contentDir, labelStore)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "create local provider database")
+		bdb.Close()
+		return nil, nil, errors.Wrap(err, "create local provider content store")
+	}
+	if options.ingestDir != "" && !options.readOnly {
+		store, err = newIngestStore(store, contentDir, options.ingestDir, options.dirPerm)
+		if err != nil {
+			bdb.Close()
+			return nil, nil, err
+		}
+	}
+	if options.dedupRoot != "" && !options.readOnly {
+		store, err = newDedupStore(store, contentDir, options.dedupRoot)
+		if err != nil {
+			bdb.Close()
+			return nil, nil, err
+		}
+	}
+	if options.memoryCacheSize > 0 {
+		store = NewTieredStore(store, options.memoryCacheSize, DefaultMaxCachedBlobSize)
 	}
 	db := metadata.NewDB(bdb, store, nil)
-	store = db.ContentStore()
+	pvd := newLocalProvider(store, db, hosts, platformMC, options)
+	pvd.bdb = bdb
+	pvd.contentDir = contentDir
+	if err := pvd.loadImages(context.Background()); err != nil {
+		bdb.Close()
+		return nil, nil, errors.Wrap(err, "load images from local provider database")
+	}
+	return pvd, db, nil
+}
+
+// NewProviderWithStore creates a provider on the content store and the
+// metadata database created on it, which are managed by caller, e.g. a
+// remote or content addressable store, or a mock store for tests. The
+// provider doesn't release the database on Close, and the images failed
+// to be loaded from database are logged and ignored.
+func NewProviderWithStore(store content.Store, db *metadata.DB, hosts remote.HostFunc, platformMC platforms.MatchComparer) Provider {
+	pvd := newLocalProvider(store, db, hosts, platformMC, LocalProviderOpts{
+		logger:    logrus.NewEntry(logrus.StandardLogger()),
+		namespace: DefaultNamespace,
+	})
+	if err := pvd.loadImages(context.Background()); err != nil {
+		pvd.logger.Warnf("load images from database: %s", err)
+	}
+	return pvd
+}
+
+func newLocalProvider(backend content.Store, db *metadata.DB, hosts remote.HostFunc, platformMC platforms.MatchComparer, options LocalProviderOpts) *LocalProvider {
+	if platformMC == nil {
+		platformMC = platforms.DefaultStrict()
+	}
+	var store content.Store = &namespacedStore{Store: db.ContentStore(), namespace: options.namespace}
+	var digests digestIndex
+	if options.digestIndex {
+		digests = digestIndex{}
+	}
 	return &LocalProvider{
-		store:      &store,
-		images:     make(map[string]*ocispec.Descriptor),
-		hosts:      hosts,
-		platformMC: platformMC,
-	}, db, nil
+		store:                  &store,
+		backend:                backend,
+		images:                 make(map[string]*ocispec.Descriptor),
+		inUse:                  make(map[string]int),
+		pending:                make(map[string]ocispec.Descriptor),
+		tracer:                 trace.NewNoopTracerProvider().Tracer(tracerName),
+		db:                     db,
+		imageStore:             &namespacedImageStore{Store: metadata.NewImageStore(db), namespace: options.namespace},
+		namespace:              options.namespace,
+		leaseManager:           metadata.NewLeaseManager(db),
+		maxConcurrentDownloads: defaultMaxConcurrentDownloads,
+		maxConcurrentUploads:   defaultMaxConcurrentUploads,
+		resumeDownloads:        true,
+		hosts:                  hosts,
+		platformMC:             platformMC,
+		logger:                 options.logger,
+		readOnly:               options.readOnly,
+		decryptConfig:          options.decryptConfig,
+		reproducible:           options.reproducible,
+		verificationKeys:       options.verificationKeys,
+		digests:                digests,
+		referenceRewriter:      options.referenceRewriter,
+	}
+}
+
+// loadImages restores the images map from the image store of metadata
+// database, so the images pulled by previous process can be reused.
+func (pvd *LocalProvider) loadImages(ctx context.Context) error {
+	imgs, err := pvd.imageStore.List(ctx)
+	if err != nil {
+		return err
+	}
+	for idx := range imgs {
+		pvd.images[imgs[idx].Name] = &imgs[idx].Target
+		pvd.digests.add(imgs[idx].Name, &imgs[idx].Target)
+	}
+
+	return nil
 }
 
 func (pvd *LocalProvider) UsePlainHTTP() {
 	pvd.usePlainHTTP = true
 }
 
+// SetMaxConcurrentDownloads sets the max concurrent downloaded layer
+// limit for Pull, the containerd default (unlimited) is used if n <= 0.
+func (pvd *LocalProvider) SetMaxConcurrentDownloads(n int) {
+	pvd.maxConcurrentDownloads = n
+}
+
+// SetMaxConcurrentUploads sets the max concurrent uploaded layer limit
+// for Push, the layers are uploaded without limit if n <= 0.
+func (pvd *LocalProvider) SetMaxConcurrentUploads(n int) {
+	pvd.maxConcurrentUploads = n
+}
+
 func (pvd *LocalProvider) Resolver(ref string) (remotes.Resolver, error) {
+	return pvd.newResolver(ref)
+}
+
+func (pvd *LocalProvider) newResolver(ref string, opts ...remote.ResolverOpt) (remotes.Resolver, error) {
 	credFunc, insecure, err := pvd.hosts(ref)
 	if err != nil {
 		return nil, err
 	}
-	return remote.NewResolver(insecure, pvd.usePlainHTTP, credFunc), nil
+	opts = pvd.remoteOpts(opts...)
+	resolver := remote.NewResolver(insecure, pvd.usePlainHTTP, credFunc, opts...)
+	// The TLS verification of external hosts isn't skipped for registry.
+	resolver = newArtifactResolver(resolver)
+	resolver = newForeignResolver(resolver, remote.NewClient(false, opts...))
+	return newTracingResolver(resolver, pvd.tracer), nil
+}
+
+// remoteOpts appends the resolver options of provider to opts.
+func (pvd *LocalProvider) remoteOpts(opts ...remote.ResolverOpt) []remote.ResolverOpt {
+	opts = append(opts, pvd.resolverOpts...)
+	if pvd.perRequestTimeout > 0 {
+		opts = append(opts, remote.WithRequestTimeout(pvd.perRequestTimeout))
+	}
+	if pvd.statusMetric != nil {
+		opts = append(opts, remote.WithStatusObserver(pvd.statusMetric.Observe))
+	}
+	return opts
+}
+
+// SetAnonymousFallback enables Pull to access the registry anonymously
+// if the authorization with credential fails, Push is never affected.
+func (pvd *LocalProvider) SetAnonymousFallback(enabled bool) {
+	pvd.anonymousFallback = enabled
+}
+
+// SetPerRequestTimeout fails each manifest or blob request to registry
+// if there is no response or no progress of response body within the
+// timeout, so the stalled request can be retried, it's disabled if
+// timeout is zero.
+func (pvd *LocalProvider) SetPerRequestTimeout(timeout time.Duration) {
+	pvd.perRequestTimeout = timeout
+}
+
+// SetResolverOpts sets the options used to create the resolver for
+// communicating with registry, such as the registry mirrors.
+func (pvd *LocalProvider) SetResolverOpts(opts ...remote.ResolverOpt) {
+	pvd.resolverOpts = opts
+}
+
+// ResolvedDigest returns the digest resolved from the reference on the
+// last Pull, it keeps unchanged even if the tag is moved in registry.
+func (pvd *LocalProvider) ResolvedDigest(ref string) (digest.Digest, error) {
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return "", err
+	}
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	if pvd.closed {
+		return "", ErrClosed
+	}
+	if desc, ok := pvd.images[ref]; ok {
+		return desc.Digest, nil
+	}
+	return "", errdefs.ErrNotFound
+}
+
+// normalizeRef returns the normalized reference used as the key of
+// images, e.g. both `ubuntu` and `library/ubuntu` are normalized to
+// `docker.io/library/ubuntu:latest`. The reference is returned as is if
+// it can't be parsed.
+func normalizeRef(ref string) string {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return ref
+	}
+	return named.String()
+}
+
+// pinnedDigest returns the digest of reference like `name@sha256:...`,
+// or empty if the reference isn't pinned by digest.
+func pinnedDigest(ref string) digest.Digest {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return ""
+	}
+	if digested, ok := named.(docker.Digested); ok {
+		return digested.Digest()
+	}
+	return ""
+}
+
+// SetTransferMetric enables the provider to measure the transferred
+// bytes and the duration of Pull and Push.
+func (pvd *LocalProvider) SetTransferMetric(metric *metrics.TransferMetric) {
+	pvd.transferMetric = metric
+}
+
+// meterResolver counts the transferred bytes of blobs, including the
+// partially transferred ones of failed operations, by the transfer metric
+// and the transfer counter of ctx.
+func (pvd *LocalProvider) meterResolver(ctx context.Context, resolver remotes.Resolver, direction, host string) remotes.Resolver {
+	total := transferCounter(ctx)
+	if pvd.transferMetric == nil && total == nil {
+		return resolver
+	}
+	var counter prometheus.Counter
+	if pvd.transferMetric != nil {
+		counter = pvd.transferMetric.Bytes.WithLabelValues(direction, host)
+	}
+	return newTransferResolver(resolver, func(desc ocispec.Descriptor, n, transferred int64) {
+		if counter != nil {
+			counter.Add(float64(n))
+		}
+		if total != nil {
+			atomic.AddInt64(total, n)
+		}
+	})
+}
+
+// SetCacheMetric enables the provider to count the images and layers of
+// Pull served from content store or fetched from registries.
+func (pvd *LocalProvider) SetCacheMetric(metric *metrics.CacheMetric) {
+	pvd.cacheMetric = metric
+}
+
+// SetInFlightMetric enables the provider to measure the pulls and pushes
+// in flight, failed and panicked operations are released as well.
+func (pvd *LocalProvider) SetInFlightMetric(metric *metrics.InFlightMetric) {
+	pvd.inFlightMetric = metric
+}
+
+// SetStatusMetric enables the provider to count the responses of
+// registries by status code.
+func (pvd *LocalProvider) SetStatusMetric(metric *metrics.StatusMetric) {
+	pvd.statusMetric = metric
+}
+
+// cacheMetricHandler counts the layers which exist in content store as
+// hits before fetching, the others as misses.
+func cacheMetricHandler(store content.Store, metric *metrics.CacheMetric) images.HandlerFunc {
+	return func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if !images.IsLayerType(desc.MediaType) {
+			return nil, nil
+		}
+		_, err := store.Info(ctx, desc.Digest)
+		metric.Observe("layer", err == nil)
+		return nil, nil
+	}
 }
 
-func (pvd *LocalProvider) Pull(ctx context.Context, ref string) error {
-	resolver, err := pvd.Resolver(ref)
+// refHost returns the registry host of reference for metric labels.
+func refHost(ref string) string {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return "unknown"
+	}
+	return docker.Domain(named)
+}
+
+// withLogger returns the context carrying the logger of request with the
+// fields, the logger of provider is used if there is none in context.
+func (pvd *LocalProvider) withLogger(ctx context.Context, fields log.Fields) context.Context {
+	logger := log.G(ctx)
+	// The default logger of containerd is returned if there is none in context.
+	if logger.Logger == log.L.Logger && len(logger.Data) == 0 {
+		logger = pvd.logger.WithContext(ctx)
+	}
+	return log.WithLogger(ctx, logger.WithFields(fields))
+}
+
+// descFields returns the log fields describing the descriptor.
+func descFields(desc ocispec.Descriptor) log.Fields {
+	return log.Fields{
+		"digest":    desc.Digest,
+		"mediaType": desc.MediaType,
+		"size":      desc.Size,
+	}
+}
+
+// SetGCInterval enables the provider to garbage collect automatically
+// after every n successful pushes, it's disabled if n <= 0.
+func (pvd *LocalProvider) SetGCInterval(n int) {
+	pvd.gcInterval = n
+}
+
+func (pvd *LocalProvider) Pull(ctx context.Context, ref string, opts ...PullOpt) (err error) {
+	ctx, jobID := ensureJobID(ctx)
+	// The platform selected by reference overrides the pull option.
+	ref, fragmentMC, err := parsePlatformFragment(ref)
+	if err != nil {
+		return err
+	}
+	ctx, span := pvd.tracer.Start(ctx, "content.Pull", trace.WithAttributes(attribute.String("ref", ref)))
+	defer func() {
+		endSpan(span, err)
+	}()
+	if pvd.inFlightMetric != nil {
+		defer pvd.inFlightMetric.Track("pull")()
+	}
+	if pvd.auditLog != nil {
+		var counter *int64
+		ctx, counter = withTransferCounter(ctx)
+		defer func(start time.Time, ref string) {
+			var dgst digest.Digest
+			if err == nil {
+				dgst, _ = pvd.ResolvedDigest(ref)
+			}
+			pvd.audit(ctx, "pull", ref, dgst, start, counter, err)
+		}(time.Now(), ref)
+	}
+
+	if pvd.readOnly {
+		return ErrReadOnly
+	}
+	done, err := pvd.beginOperation()
 	if err != nil {
 		return err
 	}
+	defer done()
+
+	var options PullOpts
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return errors.Wrap(err, "apply pull option")
+		}
+	}
+	if fragmentMC != nil {
+		options.platformMC = fragmentMC
+	}
+
+	// The original reference is kept for display.
+	ctx = pvd.withLogger(ctx, log.Fields{"ref": ref, "job_id": jobID})
+	if ref, err = pvd.rewriteRef(ref); err != nil {
+		return err
+	}
+
+	if err := pvd.hooks.prePull(ctx, ref); err != nil {
+		return err
+	}
+	// The image may be deleted or evicted by others once pulled.
+	target, err := pvd.sharedPull(ctx, ref, options)
+	if err != nil {
+		return classifyError(err)
+	}
+	if err := pvd.deleteCiphertexts(ctx); err != nil {
+		return errors.Wrap(err, "delete encrypted layers")
+	}
+	span.SetAttributes(descAttributes(target)...)
+	if err := pvd.hooks.postPull(ctx, ref, target); err != nil {
+		return err
+	}
+
+	if pvd.maxSize > 0 {
+		if err := pvd.evict(ctx, ref); err != nil {
+			return errors.Wrap(err, "evict images after pull")
+		}
+	}
+
+	return nil
+}
+
+// sharedPull shares a pull of ref among the concurrent callers, which get
+// the same result. The pulls with options affecting the result or only
+// observed by the caller, i.e. specific platforms, progress, force, known
+// and expected digests, aren't shared.
+//
+// The shared pull runs on a context detached from the cancellation of the
+// caller which starts it, so a caller giving up only ends its own wait,
+// and the transferred bytes are counted for every caller.
+func (pvd *LocalProvider) sharedPull(ctx context.Context, ref string, options PullOpts) (ocispec.Descriptor, error) {
+	if options.platformMC != nil || options.progress != nil || options.force || len(options.knownDigests) > 0 || options.expected != "" {
+		return pvd.pull(ctx, ref, options)
+	}
+	ch := pvd.pullGroup.DoChan(ref, func() (interface{}, error) {
+		// The caller starting the pull may return before it's finished.
+		done, err := pvd.beginOperation()
+		if err != nil {
+			return sharedPullResult{}, err
+		}
+		defer done()
+		pullCtx, counter := withTransferCounter(detachedContext{ctx})
+		target, err := pvd.pull(pullCtx, ref, options)
+		return sharedPullResult{target: target, transferred: atomic.LoadInt64(counter)}, err
+	})
+	select {
+	case <-ctx.Done():
+		return ocispec.Descriptor{}, ctx.Err()
+	case res := <-ch:
+		if res.Shared {
+			log.G(ctx).Debug("shared pull with concurrent callers")
+		}
+		result := res.Val.(sharedPullResult)
+		if total := transferCounter(ctx); total != nil {
+			atomic.AddInt64(total, result.transferred)
+		}
+		if res.Err != nil {
+			return ocispec.Descriptor{}, res.Err
+		}
+		return result.target, nil
+	}
+}
+
+type sharedPullResult struct {
+	target      ocispec.Descriptor
+	transferred int64
+}
+
+// detachedContext keeps the values of context but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+func (pvd *LocalProvider) pull(ctx context.Context, ref string, options PullOpts) (ocispec.Descriptor, error) {
+	host := refHost(ref)
+	if pvd.transferMetric != nil {
+		defer pvd.transferMetric.ObserveDuration(time.Now(), "pull", host)
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	resolverOpts := []remote.ResolverOpt{}
+	if pvd.anonymousFallback {
+		resolverOpts = append(resolverOpts, remote.WithAnonymousFallback())
+	}
+	resolver, err := pvd.newResolver(ref, resolverOpts...)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	// The signature is verified before fetching the layers.
+	var verified digest.Digest
+	if len(pvd.verificationKeys) > 0 {
+		_, desc, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return ocispec.Descriptor{}, errors.Wrapf(err, "resolve reference %s", ref)
+		}
+		if err := pvd.verifySignature(ctx, resolver, ref, desc.Digest); err != nil {
+			return ocispec.Descriptor{}, errors.Wrapf(err, "verify signature of %s", ref)
+		}
+		verified = desc.Digest
+	}
+	signatureResolver := resolver
+	if !pvd.resumeDownloads {
+		resolver = &noResumeResolver{resolver}
+	}
+	resolver = newScheduleResolver(resolver, pvd.fetchScheduler)
+	resolver = newProgressResolver(resolver, options.progress)
+	resolver = newBandwidthResolver(resolver, pvd.bandwidthLimiter)
+	resolver = pvd.meterResolver(ctx, resolver, "pull", host)
+
+	rc, err := pvd.pullContext(resolver)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if options.platformMC != nil {
+		rc.PlatformMatcher = options.platformMC
+	}
+	if pvd.cacheMetric != nil {
+		rc.BaseHandlers = append(rc.BaseHandlers, cacheMetricHandler(*pvd.store, pvd.cacheMetric))
+	}
+	if len(options.knownDigests) > 0 {
+		rc.BaseHandlers = append(rc.BaseHandlers, knownLayerHandler(*pvd.store, options.knownDigests))
+	}
+	if pvd.maxImageSize > 0 {
+		rc.HandlerWrapper = maxImageSizeWrapper(pvd.maxImageSize)
+	}
+
+	pinned := pinnedDigest(ref)
+	if pinned == "" {
+		pinned = options.expected
+	}
+	var img images.Image
+	if target := pvd.reusableImage(ctx, resolver, ref, rc.PlatformMatcher, options.force); target != nil {
+		log.G(ctx).WithFields(descFields(*target)).Debug("reusing image in content store")
+		img.Target = *target
+		if pvd.cacheMetric != nil {
+			pvd.cacheMetric.Observe("image", true)
+		}
+	} else {
+		if pvd.cacheMetric != nil {
+			pvd.cacheMetric.Observe("image", false)
+		}
+		log.G(ctx).Debug("pulling image")
+		if err := retry(ctx, pvd.retryConfig, func() error {
+			img, err = fetch(ctx, *pvd.store, &pvd.fetchGroup, rc, ref, 0)
+			return err
+		}); err != nil {
+			if pinned != "" && errdefs.IsFailedPrecondition(err) {
+				return ocispec.Descriptor{}, errors.Wrapf(err, "fetched manifest doesn't match the pinned digest %s", pinned)
+			}
+			return ocispec.Descriptor{}, errors.Wrap(err, "pull source image")
+		}
+	}
+	if err := checkPlatform(ctx, *pvd.store, img.Target, rc.PlatformMatcher); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if pinned != "" && img.Target.Digest != pinned {
+		return ocispec.Descriptor{}, fmt.Errorf("resolved digest %s doesn't match the pinned digest %s", img.Target.Digest, pinned)
+	}
+	// The reference may be updated since the verification.
+	if verified != "" && img.Target.Digest != verified {
+		if err := pvd.verifySignature(ctx, signatureResolver, ref, img.Target.Digest); err != nil {
+			return ocispec.Descriptor{}, errors.Wrapf(err, "verify signature of %s", ref)
+		}
+	}
+	if pvd.verifyOnPull {
+		if err := pvd.verifyImage(ctx, img.Target, rc.PlatformMatcher); err != nil {
+			return ocispec.Descriptor{}, errors.Wrap(err, "verify source image")
+		}
+	}
+	decrypted, err := pvd.decryptImage(ctx, img.Target, rc.PlatformMatcher)
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "decrypt source image")
+	}
+	if decrypted != nil {
+		img.Target = *decrypted
+	}
+	if err := pvd.leaseImage(ctx, img.Target, rc.PlatformMatcher); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "lease source image")
+	}
+	if err := pvd.setImage(ctx, ref, &img.Target); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "set source image")
+	}
+	log.G(ctx).WithFields(descFields(img.Target)).Info("pulled image")
+
+	return img.Target, nil
+}
 
-	// TODO: sets max concurrent downloaded layer limit by containerd.WithMaxConcurrentDownloads.
+func (pvd *LocalProvider) pullContext(resolver remotes.Resolver) (*containerd.RemoteContext, error) {
 	rc := &containerd.RemoteContext{
 		Resolver:        resolver,
 		PlatformMatcher: pvd.platformMC,
 	}
 
-	img, err := fetch(ctx, *pvd.store, rc, ref, 0)
+	// The layer downloads are limited by fetch schedule instead.
+	if pvd.maxConcurrentDownloads > 0 && pvd.fetchScheduler == nil {
+		if err := containerd.WithMaxConcurrentDownloads(pvd.maxConcurrentDownloads)(nil, rc); err != nil {
+			return nil, errors.Wrap(err, "set max concurrent downloads")
+		}
+	}
+
+	return rc, nil
+}
+
+func (pvd *LocalProvider) Push(ctx context.Context, desc ocispec.Descriptor, ref string, opts ...PushOpt) (err error) {
+	ctx, jobID := ensureJobID(ctx)
+	attrs := append(descAttributes(desc), attribute.String("ref", ref))
+	ctx, span := pvd.tracer.Start(ctx, "content.Push", trace.WithAttributes(attrs...))
+	defer func() {
+		endSpan(span, err)
+	}()
+	if pvd.inFlightMetric != nil {
+		defer pvd.inFlightMetric.Track("push")()
+	}
+	if pvd.auditLog != nil {
+		var counter *int64
+		ctx, counter = withTransferCounter(ctx)
+		defer func(start time.Time, ref string) {
+			pvd.audit(ctx, "push", ref, desc.Digest, start, counter, err)
+		}(time.Now(), ref)
+	}
+
+	if pvd.readOnly {
+		return ErrReadOnly
+	}
+	done, err := pvd.beginOperation()
 	if err != nil {
-		return errors.Wrap(err, "pull source image")
+		return err
+	}
+	defer done()
+
+	var options PushOpts
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return errors.Wrap(err, "apply push option")
+		}
+	}
+
+	ctx = pvd.withLogger(ctx, log.Fields{"ref": ref, "job_id": jobID})
+	if ref, err = pvd.rewriteRef(ref); err != nil {
+		return err
+	}
+
+	if err := pvd.hooks.prePush(ctx, ref, desc); err != nil {
+		return err
+	}
+	if err := pvd.push(ctx, desc, ref, options); err != nil {
+		return classifyError(err)
+	}
+	if err := pvd.hooks.postPush(ctx, ref, desc); err != nil {
+		return err
+	}
+
+	if pvd.shouldGC() {
+		if _, err := pvd.GarbageCollect(ctx); err != nil {
+			return errors.Wrap(err, "garbage collect after push")
+		}
 	}
-	pvd.setImage(ref, &img.Target)
 
 	return nil
 }
 
-func (pvd *LocalProvider) Push(ctx context.Context, desc ocispec.Descriptor, ref string) error {
-	resolver, err := pvd.Resolver(ref)
+func (pvd *LocalProvider) push(ctx context.Context, desc ocispec.Descriptor, ref string, options PushOpts) error {
+	host := refHost(ref)
+	if pvd.transferMetric != nil {
+		defer pvd.transferMetric.ObserveDuration(time.Now(), "push", host)
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	if options.manifestFormat != "" {
+		converted, err := convertManifestFormat(ctx, *pvd.store, desc, options.manifestFormat)
+		if err != nil {
+			return errors.Wrapf(err, "convert manifest to %s format", options.manifestFormat)
+		}
+		desc = converted
+	}
+
+	accepted := &acceptedRecorder{}
+	resolver, err := pvd.newResolver(ref, remote.WithManifestAccepted(accepted.record))
 	if err != nil {
 		return err
 	}
+	if options.skipExisting && !options.overwrite && pushed(ctx, resolver, desc, ref) {
+		log.G(ctx).WithFields(descFields(desc)).Info("skipped pushing existing image")
+		if options.result != nil {
+			*options.result = PushSkipped
+		}
+		return nil
+	}
+	base := resolver
+	resolver = newProgressResolver(newMountResolver(resolver, options.mountFrom), options.progress)
+	resolver = newBandwidthResolver(resolver, pvd.bandwidthLimiter)
+	resolver = pvd.meterResolver(ctx, resolver, "push", host)
 
 	rc := &containerd.RemoteContext{
 		Resolver:        resolver,
 		PlatformMatcher: pvd.platformMC,
+		BaseHandlers: []images.Handler{
+			images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+				log.G(ctx).WithFields(descFields(desc)).Debug("pushing blob")
+				return nil, nil
+			}),
+		},
+	}
+	if pvd.maxConcurrentUploads > 0 {
+		if err := containerd.WithMaxConcurrentUploadedLayers(pvd.maxConcurrentUploads)(nil, rc); err != nil {
+			return errors.Wrap(err, "set max concurrent uploads")
+		}
+	}
+
+	log.G(ctx).WithFields(descFields(desc)).Debug("pushing image")
+	if err := retry(ctx, pvd.retryConfig, func() error {
+		return push(ctx, *pvd.store, rc, desc, ref)
+	}); err != nil {
+		return err
+	}
+	// Some registries make the manifest visible asynchronously.
+	if accepted.isAccepted() {
+		if err := waitManifest(ctx, base, ref, desc.Digest); err != nil {
+			return err
+		}
 	}
+	log.G(ctx).WithFields(descFields(desc)).Info("pushed image")
+	if options.result != nil {
+		*options.result = PushUploaded
+	}
+
+	return nil
+}
 
-	return push(ctx, *pvd.store, rc, desc, ref)
+// shouldGC counts the successful pushes and reports whether
+// garbage collection should be triggered by the GC interval.
+func (pvd *LocalProvider) shouldGC() bool {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	if pvd.gcInterval <= 0 {
+		return false
+	}
+	pvd.pushCount++
+	return pvd.pushCount%pvd.gcInterval == 0
 }
 
 func (pvd *LocalProvider) Image(ctx context.Context, ref string) (*ocispec.Descriptor, error) {
-	return pvd.getImage(ref)
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	return pvd.image(ctx, ref)
+}
+
+// Tag records dstRef as the same image of srcRef without fetching, the
+// blobs are kept by garbage collection until both references are deleted.
+func (pvd *LocalProvider) Tag(ctx context.Context, srcRef, dstRef string) error {
+	if pvd.isClosed() {
+		return ErrClosed
+	}
+	if pvd.readOnly {
+		return ErrReadOnly
+	}
+
+	srcRef, err := pvd.rewriteRef(srcRef)
+	if err != nil {
+		return err
+	}
+	if dstRef, err = pvd.rewriteRef(dstRef); err != nil {
+		return err
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	pvd.mutex.Lock()
+	desc, ok := pvd.images[srcRef]
+	pvd.mutex.Unlock()
+	if !ok {
+		return errdefs.ErrNotFound
+	}
+	target := *desc
+
+	if err := pvd.setImage(ctx, dstRef, &target); err != nil {
+		return errors.Wrapf(err, "tag image %s", dstRef)
+	}
+
+	return nil
+}
+
+func (pvd *LocalProvider) DeleteImage(ctx context.Context, ref string) error {
+	if pvd.isClosed() {
+		return ErrClosed
+	}
+	if pvd.readOnly {
+		return ErrReadOnly
+	}
+
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return err
+	}
+
+	pvd.gcMutex.Lock()
+	defer pvd.gcMutex.Unlock()
+
+	if err := pvd.deleteImage(ctx, ref); err != nil {
+		return err
+	}
+
+	if _, err := pvd.garbageCollect(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GCStats records the result of a garbage collection on content store.
+type GCStats struct {
+	gc.Stats
+	// Number of blobs reclaimed from content store.
+	RemovedBlobs int
+	// Total size of blobs reclaimed from content store in bytes.
+	FreedBytes int64
+}
+
+// GarbageCollect reclaims the blobs which aren't referenced by any images.
+func (pvd *LocalProvider) GarbageCollect(ctx context.Context) (*GCStats, error) {
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	if pvd.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	pvd.gcMutex.Lock()
+	defer pvd.gcMutex.Unlock()
+
+	return pvd.garbageCollect(ctx)
+}
+
+// DeleteAllImages deletes all the images, and reclaims their blobs except
+// the ones protected by leases.
+func (pvd *LocalProvider) DeleteAllImages(ctx context.Context) (*GCStats, error) {
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	if pvd.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	pvd.gcMutex.Lock()
+	defer pvd.gcMutex.Unlock()
+
+	imgs, err := pvd.imageStore.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "list images")
+	}
+	for _, img := range imgs {
+		err := pvd.deleteImage(ctx, img.Name)
+		// The image record isn't loaded by provider.
+		if errdefs.IsNotFound(err) {
+			err = pvd.imageStore.Delete(ctx, img.Name)
+		}
+		if err != nil && !errdefs.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "delete image %s", img.Name)
+		}
+	}
+
+	return pvd.garbageCollect(ctx)
+}
+
+func (pvd *LocalProvider) garbageCollect(ctx context.Context) (*GCStats, error) {
+	blobs, size, err := pvd.usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := pvd.db.GarbageCollect(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "garbage collect")
+	}
+
+	remainingBlobs, remainingSize, err := pvd.usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCStats{
+		Stats:        stats,
+		RemovedBlobs: blobs - remainingBlobs,
+		FreedBytes:   size - remainingSize,
+	}, nil
+}
+
+// usage returns the number and total size of blobs in content store.
+func (pvd *LocalProvider) usage(ctx context.Context) (int, int64, error) {
+	var (
+		blobs int
+		size  int64
+	)
+	if err := (*pvd.store).Walk(ctx, func(info content.Info) error {
+		blobs++
+		size += info.Size
+		return nil
+	}); err != nil {
+		return 0, 0, errors.Wrap(err, "walk content store")
+	}
+	return blobs, size, nil
 }
 
 func (pvd *LocalProvider) ContentStore() content.Store {
 	return *pvd.store
 }
 
-func (pvd *LocalProvider) setImage(ref string, image *ocispec.Descriptor) {
+// pulledAtLabel records the time when the image is pulled or imported.
+const pulledAtLabel = "goharbor.io/acceleration-service.pulled-at"
+
+// ImageInfo describes an image stored in provider.
+type ImageInfo struct {
+	Ref    string
+	Target ocispec.Descriptor
+	// Size is the total size of blobs of image matched by platform.
+	Size int64
+	// PulledAt is the zero time if the image is pulled by the old
+	// version which doesn't record the pull time.
+	PulledAt time.Time
+}
+
+// ListImages returns the images stored in the namespace of context sorted
+// by reference, the last access time of images is not updated.
+func (pvd *LocalProvider) ListImages(ctx context.Context) ([]ImageInfo, error) {
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	imgs, err := pvd.imageStore.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "list images")
+	}
+	sort.Slice(imgs, func(i, j int) bool {
+		return imgs[i].Name < imgs[j].Name
+	})
+
+	infos := make([]ImageInfo, 0, len(imgs))
+	for _, img := range imgs {
+		size, err := pvd.imageSize(ctx, img.Target)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get size of image %s", img.Name)
+		}
+		pulledAt, _ := time.Parse(time.RFC3339Nano, img.Labels[pulledAtLabel])
+		infos = append(infos, ImageInfo{
+			Ref:      img.Name,
+			Target:   img.Target,
+			Size:     size,
+			PulledAt: pulledAt,
+		})
+	}
+
+	return infos, nil
+}
+
+// imageSize returns the total size of the blobs of image stored in
+// content store, the image may be pulled with a different platform.
+func (pvd *LocalProvider) imageSize(ctx context.Context, target ocispec.Descriptor) (int64, error) {
+	var size int64
+	if err := pvd.walkBlobs(ctx, func(desc ocispec.Descriptor) {
+		size += desc.Size
+	}, target); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// setImage records the image in both the images map and the image store
+// of metadata database, the latter one makes the blobs of image as the
+// GC roots, so they can't be reclaimed until the image is deleted.
+func (pvd *LocalProvider) setImage(ctx context.Context, ref string, image *ocispec.Descriptor) error {
+	ref = normalizeRef(ref)
 	pvd.mutex.Lock()
 	defer pvd.mutex.Unlock()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	img := images.Image{
+		Name:   ref,
+		Target: *image,
+		Labels: map[string]string{
+			pulledAtLabel:   now,
+			lastAccessLabel: now,
+		},
+	}
+	if _, err := pvd.imageStore.Create(ctx, img); err != nil {
+		if !errdefs.IsAlreadyExists(err) {
+			return err
+		}
+		if _, err := pvd.imageStore.Update(ctx, img, "target", "labels."+pulledAtLabel, "labels."+lastAccessLabel); err != nil {
+			return err
+		}
+	}
+	if old, ok := pvd.images[ref]; ok {
+		pvd.digests.remove(ref, old.Digest)
+	}
 	pvd.images[ref] = image
+	pvd.digests.add(ref, image)
+
+	return nil
 }
 
-func (pvd *LocalProvider) getImage(ref string) (*ocispec.Descriptor, error) {
+func (pvd *LocalProvider) deleteImage(ctx context.Context, ref string) error {
+	ref = normalizeRef(ref)
 	pvd.mutex.Lock()
 	defer pvd.mutex.Unlock()
+
+	desc, ok := pvd.images[ref]
+	if !ok {
+		return errdefs.ErrNotFound
+	}
+	if err := pvd.imageStore.Delete(ctx, ref); err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	delete(pvd.images, ref)
+	pvd.digests.remove(ref, desc.Digest)
+
+	return nil
+}
+
+func (pvd *LocalProvider) getImage(ctx context.Context, ref string) (*ocispec.Descriptor, error) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	if pvd.closed {
+		return nil, ErrClosed
+	}
+	// The digest isn't a reference to be normalized.
+	if dgst, err := digest.Parse(ref); err == nil {
+		if desc, ok := pvd.digests.get(dgst); ok {
+			return desc, nil
+		}
+		return nil, errdefs.ErrNotFound
+	}
+	ref = normalizeRef(ref)
 	if desc, ok := pvd.images[ref]; ok {
+		if pvd.readOnly {
+			return desc, nil
+		}
+		if err := pvd.touchImage(ctx, ref); err != nil {
+			logrus.Warnf("update last access time of image %s: %s", ref, err)
+		}
 		return desc, nil
 	}
 	return nil, errdefs.ErrNotFound
@@ -0,0 +1,2870 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/metrics"
+	"github.com/goharbor/acceleration-service/pkg/platformutil"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestProvider(t *testing.T) *LocalProvider {
+	return newTestProviderWithDir(t, t.TempDir())
+}
+
+func newTestProviderWithDir(t *testing.T, workDir string) *LocalProvider {
+	return newTestProviderWithCred(t, workDir, func(string) (string, string, error) {
+		return "", "", nil
+	})
+}
+
+func newTestProviderWithCred(t *testing.T, workDir string, credFunc remote.CredentialFunc) *LocalProvider {
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return credFunc, false, nil
+	}
+	pvd, _, err := NewLocalProvider(workDir, hosts, platforms.All)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	return pvd
+}
+
+func testContext() context.Context {
+	return namespaces.WithNamespace(context.Background(), "acceleration-service")
+}
+
+func countBlobs(t *testing.T, pvd *LocalProvider) int {
+	blobs, _, err := pvd.usage(testContext())
+	require.NoError(t, err)
+	return blobs
+}
+
+func TestMaxConcurrentDownloads(t *testing.T) {
+	pvd := newTestProvider(t)
+
+	rc, err := pvd.pullContext(nil)
+	require.NoError(t, err)
+	require.Equal(t, defaultMaxConcurrentDownloads, rc.MaxConcurrentDownloads)
+
+	pvd.SetMaxConcurrentDownloads(8)
+	rc, err = pvd.pullContext(nil)
+	require.NoError(t, err)
+	require.Equal(t, 8, rc.MaxConcurrentDownloads)
+
+	pvd.SetMaxConcurrentDownloads(0)
+	rc, err = pvd.pullContext(nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, rc.MaxConcurrentDownloads)
+}
+
+func TestDeleteImageNotFound(t *testing.T) {
+	pvd := newTestProvider(t)
+
+	err := pvd.DeleteImage(testContext(), "localhost/library/busybox:latest")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func TestGarbageCollect(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer-1"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest")))
+	require.Equal(t, 7, countBlobs(t, pvd))
+
+	require.NoError(t, pvd.deleteImage(ctx, reg.ref("library/foo:latest")))
+	stats, err := pvd.GarbageCollect(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 4, stats.RemovedBlobs)
+	require.Equal(t, 3, countBlobs(t, pvd))
+
+	_, err = pvd.Image(ctx, reg.ref("library/bar:latest"))
+	require.NoError(t, err)
+}
+
+func TestDeleteAllImages(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer-1"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest")))
+
+	stats, err := pvd.DeleteAllImages(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 7, stats.RemovedBlobs)
+	require.Zero(t, countBlobs(t, pvd))
+
+	// The images of provider are deleted with the records.
+	for _, ref := range []string{"library/foo:latest", "library/bar:latest"} {
+		_, err = pvd.Image(ctx, reg.ref(ref))
+		require.ErrorIs(t, err, errdefs.ErrNotFound)
+	}
+	infos, err := pvd.ListImages(ctx)
+	require.NoError(t, err)
+	require.Empty(t, infos)
+}
+
+func TestProgress(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+
+	var mutex sync.Mutex
+	progress := map[digest.Digest]int64{}
+	fn := func(desc ocispec.Descriptor, transferred, total int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		require.Equal(t, desc.Size, total)
+		progress[desc.Digest] = transferred
+	}
+
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref, WithPullProgress(fn)))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, desc.Size, progress[desc.Digest])
+	require.Len(t, progress, 4)
+
+	progress = map[digest.Digest]int64{}
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed"), WithPushProgress(fn)))
+	require.Equal(t, desc.Size, progress[desc.Digest])
+}
+
+func TestPersistImages(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	workDir := t.TempDir()
+	pvd := newTestProviderWithDir(t, workDir)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	expected, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.NoError(t, pvd.bdb.Close())
+
+	pvd = newTestProviderWithDir(t, workDir)
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, expected.Digest, desc.Digest)
+	require.Equal(t, 1, reg.count("GET", "/manifests/"))
+}
+
+func TestPullFromMirrors(t *testing.T) {
+	unavailable := newTestRegistry(t)
+	unavailable.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	})
+	mirror := newTestRegistry(t)
+	mirror.addImage("library/foo", "latest", []byte("foo-layer-1"))
+	reg := newTestRegistry(t)
+	reg.addImage("library/bar", "latest", []byte("bar-layer-1"))
+
+	pvd := newTestProvider(t)
+	pvd.SetResolverOpts(remote.WithMirrors(reg.host(), "http://"+unavailable.host(), mirror.host()))
+	ctx := testContext()
+
+	// Fall back to the registry if the image isn't found in any mirror.
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest")))
+	require.NotZero(t, unavailable.count(http.MethodHead, "/manifests/"))
+	require.NotZero(t, mirror.count(http.MethodHead, "/manifests/"))
+
+	// Pull from the available mirror.
+	fetched := reg.count(http.MethodGet, "/blobs/")
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.Equal(t, fetched, reg.count(http.MethodGet, "/blobs/"))
+
+	// Push to the registry only.
+	desc, err := pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/foo:latest")))
+	require.Equal(t, 1, reg.count(http.MethodPut, "/manifests/"))
+	require.Zero(t, unavailable.count(http.MethodPut, "/"))
+	require.Zero(t, mirror.count(http.MethodPut, "/"))
+}
+
+func TestRefreshCredential(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.enableAuth("foo", "bar")
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+
+	// Expire the token after resolving the image.
+	var requests int32
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.Contains(r.URL.Path, "/blobs/") && atomic.AddInt32(&requests, 1) == 1 {
+			reg.revokeTokens()
+		}
+		return false
+	})
+
+	var calls int32
+	pvd := newTestProviderWithCred(t, t.TempDir(), func(string) (string, string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "foo", "bar", nil
+	})
+	require.NoError(t, pvd.Pull(testContext(), reg.ref("library/foo:latest")))
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	require.Equal(t, 2, reg.issuedTokens())
+}
+
+func TestAnonymousFallback(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.enableAuth("foo", "bar")
+	reg.allowAnonymous()
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	pvd := newTestProviderWithCred(t, t.TempDir(), func(string) (string, string, error) {
+		return "foo", "expired", nil
+	})
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.Error(t, pvd.Pull(ctx, ref))
+
+	pvd.SetAnonymousFallback(true)
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.Zero(t, reg.issuedTokens())
+
+	// Never push anonymously.
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Error(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed")))
+	require.Zero(t, reg.count(http.MethodPut, "/manifests/"))
+}
+
+func TestTransferMetric(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+
+	registry := prometheus.NewRegistry()
+	metric, err := metrics.NewTransferMetric(registry)
+	require.NoError(t, err)
+	pvd := newTestProvider(t)
+	pvd.SetTransferMetric(metric)
+	ctx := testContext()
+
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	_, size, err := pvd.usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, float64(size), testutil.ToFloat64(metric.Bytes.WithLabelValues("pull", reg.host())))
+
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed")))
+	require.NotZero(t, testutil.ToFloat64(metric.Bytes.WithLabelValues("push", reg.host())))
+
+	// The duration of failed pull is recorded too.
+	require.Error(t, pvd.Pull(ctx, reg.ref("library/foo:notfound")))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	counts := map[string]uint64{}
+	for _, family := range families {
+		if !strings.HasSuffix(family.GetName(), "transfer_duration_seconds") {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "direction" {
+					counts[label.GetValue()] = m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	require.Equal(t, map[string]uint64{"pull": 2, "push": 1}, counts)
+}
+
+func TestCacheMetric(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"), []byte("shared-layer"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer"), []byte("shared-layer"))
+
+	registry := prometheus.NewRegistry()
+	metric, err := metrics.NewCacheMetric(registry)
+	require.NoError(t, err)
+	pvd := newTestProvider(t)
+	pvd.SetCacheMetric(metric)
+	ctx := testContext()
+
+	hits := func(level string) float64 {
+		return testutil.ToFloat64(metric.Hits.WithLabelValues(level))
+	}
+	misses := func(level string) float64 {
+		return testutil.ToFloat64(metric.Misses.WithLabelValues(level))
+	}
+
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.Equal(t, float64(0), hits("image"))
+	require.Equal(t, float64(1), misses("image"))
+	require.Equal(t, float64(0), hits("layer"))
+	require.Equal(t, float64(2), misses("layer"))
+
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.Equal(t, float64(1), hits("image"))
+	require.Equal(t, float64(1), misses("image"))
+
+	// The shared layer is served from content store.
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest")))
+	require.Equal(t, float64(2), misses("image"))
+	require.Equal(t, float64(1), hits("layer"))
+	require.Equal(t, float64(3), misses("layer"))
+}
+
+func TestInFlightMetric(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+
+	registry := prometheus.NewRegistry()
+	metric, err := metrics.NewInFlightMetric(registry)
+	require.NoError(t, err)
+	pvd := newTestProvider(t)
+	pvd.SetInFlightMetric(metric)
+	ctx := testContext()
+
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	inFlight := func(op string) float64 {
+		return testutil.ToFloat64(metric.Operations.WithLabelValues(op))
+	}
+	require.Zero(t, inFlight("pull"))
+
+	// The manifest requests are blocked until released.
+	release := make(chan struct{})
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			<-release
+		}
+		return false
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pvd.Pull(ctx, ref, WithForce())
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pvd.Push(ctx, *desc, reg.ref("library/foo:pushed-"+strconv.Itoa(i)))
+		}(i)
+	}
+	require.Eventually(t, func() bool {
+		return inFlight("pull") == 3 && inFlight("push") == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(release)
+	wg.Wait()
+	require.Zero(t, inFlight("pull"))
+	require.Zero(t, inFlight("push"))
+
+	// The failed operations are released as well.
+	require.Error(t, pvd.Pull(ctx, reg.ref("library/foo:notfound")))
+	require.Zero(t, inFlight("pull"))
+}
+
+func TestVerifyOnPull(t *testing.T) {
+	reg := newTestRegistry(t)
+	layer := []byte("foo-layer-1")
+	reg.addImage("library/foo", "latest", layer)
+
+	workDir := t.TempDir()
+	pvd := newTestProviderWithDir(t, workDir)
+	pvd.SetVerifyOnPull(true)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+
+	// Corrupt the pulled layer, the existing blob isn't fetched again.
+	dgst := digest.FromBytes(layer)
+	path := filepath.Join(workDir, "content", "blobs", dgst.Algorithm().String(), dgst.Encoded())
+	require.NoError(t, os.WriteFile(path, []byte("foo-layer-x"), 0644))
+	err := pvd.Pull(ctx, ref)
+	require.ErrorContains(t, err, dgst.String())
+	_, err = pvd.ContentStore().Info(ctx, dgst)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	require.NoError(t, pvd.Pull(ctx, ref))
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	reg := newTestRegistry(t)
+	layer := bytes.Repeat([]byte("x"), 8*1024)
+	reg.addImage("library/foo", "latest", layer)
+	ref := reg.ref("library/foo:latest")
+
+	pvd := newTestProvider(t)
+	// The layer takes at least 0.5s to transfer.
+	limit := int64(16 * 1024)
+	pvd.SetBandwidthLimit(limit)
+	ctx := testContext()
+	minElapsed := time.Duration(len(layer)) * time.Second / time.Duration(limit)
+
+	start := time.Now()
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.GreaterOrEqual(t, time.Since(start), minElapsed)
+
+	// The blobs are uploaded to another registry.
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	target := newTestRegistry(t)
+	start = time.Now()
+	require.NoError(t, pvd.Push(ctx, *desc, target.ref("library/foo:latest")))
+	require.GreaterOrEqual(t, time.Since(start), minElapsed)
+
+	// The throttled transfer is canceled with context.
+	require.NoError(t, pvd.DeleteImage(ctx, ref))
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	require.Error(t, pvd.Pull(timeoutCtx, ref))
+	require.Less(t, time.Since(start), minElapsed)
+
+	// The limit is disabled by zero.
+	pvd.SetBandwidthLimit(0)
+	start = time.Now()
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.Less(t, time.Since(start), minElapsed)
+}
+
+func TestVerify(t *testing.T) {
+	reg := newTestRegistry(t)
+	layers := [][]byte{[]byte("foo-layer-1"), []byte("foo-layer-2")}
+	reg.addImage("library/foo", "latest", layers...)
+
+	workDir := t.TempDir()
+	pvd := newTestProviderWithDir(t, workDir)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	corrupted, err := pvd.Verify(ctx)
+	require.NoError(t, err)
+	require.Empty(t, corrupted)
+
+	dgst := digest.FromBytes(layers[1])
+	path := filepath.Join(workDir, "content", "blobs", dgst.Algorithm().String(), dgst.Encoded())
+	require.NoError(t, os.WriteFile(path, []byte("foo-layer-x"), 0644))
+	corrupted, err = pvd.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{dgst}, corrupted)
+	// The corrupted blob is kept without repair.
+	_, err = pvd.ContentStore().Info(ctx, dgst)
+	require.NoError(t, err)
+
+	corrupted, err = pvd.Verify(ctx, WithRepair())
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{dgst}, corrupted)
+	_, err = pvd.ContentStore().Info(ctx, dgst)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	// The repaired blob is fetched again.
+	require.NoError(t, pvd.Pull(ctx, ref, WithForce()))
+	corrupted, err = pvd.Verify(ctx)
+	require.NoError(t, err)
+	require.Empty(t, corrupted)
+}
+
+func TestUsage(t *testing.T) {
+	reg := newTestRegistry(t)
+	layers := [][]byte{[]byte("foo-layer-1"), []byte("foo-layer-22")}
+	desc := reg.addImage("library/foo", "latest", layers...)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+
+	manifest, err := content.ReadBlob(ctx, pvd.ContentStore(), desc)
+	require.NoError(t, err)
+	var mf ocispec.Manifest
+	require.NoError(t, json.Unmarshal(manifest, &mf))
+
+	usage, err := pvd.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, desc.Size+mf.Config.Size+int64(len(layers[0])+len(layers[1])), usage)
+
+	byMediaType, err := pvd.UsageByMediaType(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{
+		ocispec.MediaTypeImageManifest: desc.Size,
+		ocispec.MediaTypeImageConfig:   mf.Config.Size,
+		ocispec.MediaTypeImageLayer:    int64(len(layers[0]) + len(layers[1])),
+	}, byMediaType)
+}
+
+func TestEvict(t *testing.T) {
+	reg := newTestRegistry(t)
+	for _, name := range []string{"foo", "bar", "baz", "qux"} {
+		reg.addImage("library/"+name, "latest", bytes.Repeat([]byte(name), 400))
+	}
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	size, err := pvd.Usage(ctx)
+	require.NoError(t, err)
+	// Allow two images in content store.
+	pvd.SetMaxSize(size*2 + size/2)
+
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest")))
+	_, err = pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/baz:latest")))
+
+	// The least recently used image is evicted.
+	_, err = pvd.Image(ctx, reg.ref("library/bar:latest"))
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.Equal(t, 6, countBlobs(t, pvd))
+
+	// The acquired image is never evicted.
+	release := pvd.Acquire(reg.ref("library/foo:latest"))
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/qux:latest")))
+	release()
+	for name, exists := range map[string]bool{"foo": true, "baz": false, "qux": true} {
+		_, err = pvd.Image(ctx, reg.ref("library/"+name+":latest"))
+		if exists {
+			require.NoError(t, err, name)
+		} else {
+			require.ErrorIs(t, err, errdefs.ErrNotFound, name)
+		}
+	}
+}
+
+func TestListImages(t *testing.T) {
+	reg := newTestRegistry(t)
+	foo := reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+	bar := reg.addImage("library/bar", "latest", []byte("bar-layer-1"), []byte("bar-layer-2"))
+
+	workDir := t.TempDir()
+	pvd := newTestProviderWithDir(t, workDir)
+	ctx := testContext()
+	start := time.Now()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest")))
+	require.NoError(t, pvd.bdb.Close())
+
+	pvd = newTestProviderWithDir(t, workDir)
+	infos, err := pvd.ListImages(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, reg.ref("library/bar:latest"), infos[0].Ref)
+	require.Equal(t, bar.Digest, infos[0].Target.Digest)
+	require.Equal(t, reg.ref("library/foo:latest"), infos[1].Ref)
+	require.Equal(t, foo.Digest, infos[1].Target.Digest)
+	for _, info := range infos {
+		require.Greater(t, info.Size, info.Target.Size)
+		require.False(t, info.PulledAt.Before(start.Truncate(time.Second)))
+	}
+}
+
+func TestPerms(t *testing.T) {
+	workDir := t.TempDir()
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+
+	pvd, _, err := NewLocalProvider(workDir, hosts, platforms.All)
+	require.NoError(t, err)
+	require.NoError(t, pvd.bdb.Close())
+	for path, perm := range map[string]os.FileMode{"content": 0755, "meta.db": 0644} {
+		info, err := os.Stat(filepath.Join(workDir, path))
+		require.NoError(t, err)
+		require.Equal(t, perm, info.Mode().Perm(), path)
+	}
+
+	// The permissions are applied to the existing files too.
+	pvd, _, err = NewLocalProvider(workDir, hosts, platforms.All, WithDirPerm(0700), WithDBPerm(0600))
+	require.NoError(t, err)
+	require.NoError(t, pvd.bdb.Close())
+	for path, perm := range map[string]os.FileMode{"content": 0700, "meta.db": 0600} {
+		info, err := os.Stat(filepath.Join(workDir, path))
+		require.NoError(t, err)
+		require.Equal(t, perm, info.Mode().Perm(), path)
+	}
+}
+
+func TestPullPlatform(t *testing.T) {
+	reg := newTestRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	reg.addIndex("library/foo", "latest",
+		reg.addManifest(&amd64, []byte("foo-amd64-layer")),
+		reg.addManifest(&arm64, []byte("foo-arm64-layer")),
+	)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref, WithPullPlatform(platforms.OnlyStrict(amd64))))
+	// index, manifest, config and layer of amd64.
+	require.Equal(t, 4, countBlobs(t, pvd))
+
+	infos, err := pvd.ListImages(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+
+	require.NoError(t, pvd.Pull(ctx, ref, WithPullPlatform(platforms.All)))
+	require.Equal(t, 7, countBlobs(t, pvd))
+}
+
+func TestPlatformFragment(t *testing.T) {
+	reg := newTestRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	amd64Manifest := reg.addManifest(&amd64, []byte("foo-amd64-layer"))
+	arm64Manifest := reg.addManifest(&arm64, []byte("foo-arm64-layer"))
+	reg.addIndex("library/foo", "latest", amd64Manifest, arm64Manifest)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	// The fragment overrides the platform option.
+	require.NoError(t, pvd.Pull(ctx, ref+"@platform=linux/arm64", WithPullPlatform(platforms.OnlyStrict(amd64))))
+	require.Equal(t, 1, reg.count(http.MethodGet, "/manifests/"+arm64Manifest.Digest.String()))
+	require.Zero(t, reg.count(http.MethodGet, "/manifests/"+amd64Manifest.Digest.String()))
+	require.Equal(t, 4, countBlobs(t, pvd))
+	// The image is recorded without fragment.
+	_, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+
+	require.NoError(t, pvd.Pull(ctx, ref, WithPullPlatform(platforms.OnlyStrict(amd64)), WithForce()))
+	require.Equal(t, 1, reg.count(http.MethodGet, "/manifests/"+amd64Manifest.Digest.String()))
+
+	err = pvd.Pull(ctx, ref+"@platform=linux/arm64/v8/extra")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid platform")
+	require.Error(t, pvd.Pull(ctx, ref+"@platform="))
+}
+
+func TestInspect(t *testing.T) {
+	reg := newTestRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	index := reg.addIndex("library/foo", "latest",
+		reg.addManifest(&amd64, []byte("foo-amd64-layer")),
+		reg.addManifest(&arm64, []byte("foo-arm64-layer")),
+	)
+	manifest := reg.addImage("library/bar", "latest", []byte("bar-layer-1"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	desc, manifests, err := pvd.Inspect(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, index.Digest, desc.Digest)
+	require.Len(t, manifests, 2)
+	require.Equal(t, amd64, *manifests[0].Platform)
+	require.Equal(t, arm64, *manifests[1].Platform)
+
+	desc, manifests, err = pvd.Inspect(ctx, reg.ref("library/bar:latest"))
+	require.NoError(t, err)
+	require.Equal(t, manifest.Digest, desc.Digest)
+	require.Len(t, manifests, 1)
+
+	// Only the index is fetched.
+	require.Equal(t, 1, reg.count(http.MethodGet, "/manifests/"))
+	require.Zero(t, reg.count(http.MethodGet, "/blobs/"))
+	require.Zero(t, countBlobs(t, pvd))
+	_, err = pvd.Image(ctx, ref)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func TestPinnedDigest(t *testing.T) {
+	reg := newTestRegistry(t)
+	foo := reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+	bar := reg.addImage("library/bar", "latest", []byte("bar-layer-1"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	dgst, err := pvd.ResolvedDigest(ref)
+	require.NoError(t, err)
+	require.Equal(t, foo.Digest, dgst)
+
+	// The resolved digest is kept after the tag is moved.
+	reg.tag("library/foo", "latest", bar)
+	dgst, err = pvd.ResolvedDigest(ref)
+	require.NoError(t, err)
+	require.Equal(t, foo.Digest, dgst)
+
+	_, err = pvd.ResolvedDigest(reg.ref("library/bar:latest"))
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	// Serve the mismatched manifest for the pinned digest.
+	pinned := reg.ref("library/foo@" + foo.Digest.String())
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(r.URL.Path, "/manifests/"+foo.Digest.String()) {
+			reg.serveContent(w, r, bar.Digest)
+			return true
+		}
+		return false
+	})
+	require.NoError(t, pvd.DeleteImage(ctx, ref))
+	err = pvd.Pull(ctx, pinned)
+	require.ErrorContains(t, err, "pinned digest "+foo.Digest.String())
+
+	reg.setHook(nil)
+	require.NoError(t, pvd.Pull(ctx, pinned))
+	dgst, err = pvd.ResolvedDigest(pinned)
+	require.NoError(t, err)
+	require.Equal(t, foo.Digest, dgst)
+}
+
+func TestPullAll(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("shared-layer"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer-1"), []byte("shared-layer"))
+	reg.addImage("library/baz", "latest", []byte("baz-layer-1"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	refs := []string{
+		reg.ref("library/foo:latest"),
+		reg.ref("library/bar:latest"),
+		reg.ref("library/baz:latest"),
+		reg.ref("library/foo:latest"),
+		reg.ref("library/notfound:latest"),
+	}
+	results, err := pvd.PullAll(ctx, refs, 2)
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	for _, ref := range refs[:3] {
+		require.NoError(t, results[ref], ref)
+		_, err := pvd.Image(ctx, ref)
+		require.NoError(t, err)
+	}
+	require.Error(t, results[reg.ref("library/notfound:latest")])
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	results, err = pvd.PullAll(canceled, refs, 2)
+	require.ErrorIs(t, err, context.Canceled)
+	for _, ref := range refs {
+		require.ErrorIs(t, results[ref], context.Canceled)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	reg := newTestRegistry(t)
+	target := reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	logger, hook := logtest.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.All, WithLogger(logrus.NewEntry(logger)))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(testContext(), ref))
+	messages := map[string]logrus.Fields{}
+	for _, entry := range hook.AllEntries() {
+		require.Equal(t, ref, entry.Data["ref"])
+		messages[entry.Message] = entry.Data
+	}
+	for _, msg := range []string{"resolved reference", "fetching manifest", "fetching layer", "committed blob", "pulled image"} {
+		require.Contains(t, messages, msg)
+	}
+	require.Equal(t, target.Digest, messages["pulled image"]["digest"])
+	require.Equal(t, target.MediaType, messages["pulled image"]["mediaType"])
+	require.Equal(t, target.Size, messages["pulled image"]["size"])
+
+	// The request-scoped logger in context takes precedence.
+	hook.Reset()
+	ctx := log.WithLogger(testContext(), logrus.NewEntry(logger).WithField("request", "test"))
+	pushed := reg.ref("library/foo:pushed")
+	require.NoError(t, pvd.Push(ctx, target, pushed))
+	messages = map[string]logrus.Fields{}
+	for _, entry := range hook.AllEntries() {
+		require.Equal(t, "test", entry.Data["request"])
+		require.Equal(t, pushed, entry.Data["ref"])
+		messages[entry.Message] = entry.Data
+	}
+	require.Contains(t, messages, "pushing blob")
+	require.Contains(t, messages, "pushed image")
+}
+
+func TestReadOnly(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	workDir := t.TempDir()
+	pvd := newTestProviderWithDir(t, workDir)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.NoError(t, pvd.bdb.Close())
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(workDir, hosts, platforms.All, WithReadOnly())
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	defer pvd.bdb.Close()
+
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	_, err = pvd.ContentStore().Info(ctx, desc.Digest)
+	require.NoError(t, err)
+	infos, err := pvd.ListImages(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	_, _, err = pvd.Inspect(ctx, ref)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, pvd.Pull(ctx, ref), ErrReadOnly)
+	require.ErrorIs(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed")), ErrReadOnly)
+	require.ErrorIs(t, pvd.DeleteImage(ctx, ref), ErrReadOnly)
+	_, err = pvd.GarbageCollect(ctx)
+	require.ErrorIs(t, err, ErrReadOnly)
+	_, err = pvd.ImportFromOCILayout(ctx, t.TempDir(), ref)
+	require.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestPersistentLabels(t *testing.T) {
+	workDir := t.TempDir()
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(workDir, hosts, platforms.All, WithPersistentLabels())
+	require.NoError(t, err)
+
+	ctx := testContext()
+	data := []byte("foo-layer-1")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	require.NoError(t, content.WriteBlob(ctx, pvd.backend, "test", bytes.NewReader(data), desc))
+	_, err = pvd.backend.Update(ctx, content.Info{
+		Digest: desc.Digest,
+		Labels: map[string]string{"foo": "bar"},
+	}, "labels.foo")
+	require.NoError(t, err)
+	require.NoError(t, pvd.bdb.Close())
+
+	pvd, _, err = NewLocalProvider(workDir, hosts, platforms.All, WithPersistentLabels())
+	require.NoError(t, err)
+	defer pvd.bdb.Close()
+	info, err := pvd.backend.Info(ctx, desc.Digest)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"foo": "bar"}, info.Labels)
+}
+
+func TestReusePulledImage(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	fetched := reg.count(http.MethodGet, "/blobs/")
+	require.NotZero(t, fetched)
+
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.Equal(t, fetched, reg.count(http.MethodGet, "/blobs/"))
+	require.Equal(t, 1, reg.count(http.MethodGet, "/manifests/"))
+	require.NoError(t, pvd.Pull(ctx, ref, WithForce()))
+
+	// The moved tag is pulled again.
+	moved := reg.addImage("library/foo", "latest", []byte("foo-layer-3"))
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.Greater(t, reg.count(http.MethodGet, "/blobs/"), fetched)
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, moved.Digest, desc.Digest)
+}
+
+func TestTag(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	srcRef := reg.ref("library/foo:latest")
+	dstRef := reg.ref("library/foo:tagged")
+	require.ErrorIs(t, pvd.Tag(ctx, srcRef, dstRef), errdefs.ErrNotFound)
+
+	require.NoError(t, pvd.Pull(ctx, srcRef))
+	blobs := countBlobs(t, pvd)
+	requests := reg.count(http.MethodHead, "/") + reg.count(http.MethodGet, "/")
+	require.NoError(t, pvd.Tag(ctx, srcRef, dstRef))
+	require.Equal(t, requests, reg.count(http.MethodHead, "/")+reg.count(http.MethodGet, "/"))
+
+	expected, err := pvd.Image(ctx, srcRef)
+	require.NoError(t, err)
+	desc, err := pvd.Image(ctx, dstRef)
+	require.NoError(t, err)
+	require.Equal(t, expected.Digest, desc.Digest)
+
+	// The blobs are kept by the tagged reference.
+	require.NoError(t, pvd.DeleteImage(ctx, srcRef))
+	require.Equal(t, blobs, countBlobs(t, pvd))
+	_, err = pvd.Image(ctx, srcRef)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	_, err = pvd.Image(ctx, dstRef)
+	require.NoError(t, err)
+
+	require.NoError(t, pvd.DeleteImage(ctx, dstRef))
+	require.Zero(t, countBlobs(t, pvd))
+}
+
+func TestPullZstdLayer(t *testing.T) {
+	reg := newTestRegistry(t)
+	plain := []byte("foo-layer-1")
+	var buf bytes.Buffer
+	writer, err := compression.CompressStream(&buf, compression.Zstd)
+	require.NoError(t, err)
+	_, err = writer.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	layer := reg.addBlob(ocispec.MediaTypeImageLayerZstd, buf.Bytes())
+	config := reg.addJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+		RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromBytes(plain)}},
+	})
+	reg.tag("library/foo", "latest", reg.addJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	}))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	manifest, err := images.Manifest(ctx, pvd.ContentStore(), *desc, platforms.All)
+	require.NoError(t, err)
+	require.Equal(t, []ocispec.Descriptor{layer}, manifest.Layers)
+	data, err := content.ReadBlob(ctx, pvd.ContentStore(), layer)
+	require.NoError(t, err)
+	require.Equal(t, layer.Digest, digest.FromBytes(data))
+
+	// The layer is decompressed by zstd rather than gzip.
+	diffID, err := images.GetDiffID(ctx, pvd.ContentStore(), layer)
+	require.NoError(t, err)
+	require.Equal(t, digest.FromBytes(plain), diffID)
+	usage, err := pvd.UsageByMediaType(ctx)
+	require.NoError(t, err)
+	require.Equal(t, layer.Size, usage[ocispec.MediaTypeImageLayerZstd])
+}
+
+func TestClose(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	workDir := t.TempDir()
+	pvd := newTestProviderWithDir(t, workDir)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.NoError(t, pvd.Close())
+
+	require.ErrorIs(t, pvd.Close(), ErrClosed)
+	require.ErrorIs(t, pvd.Pull(ctx, ref), ErrClosed)
+	require.ErrorIs(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed")), ErrClosed)
+	_, err = pvd.Image(ctx, ref)
+	require.ErrorIs(t, err, ErrClosed)
+	require.ErrorIs(t, pvd.DeleteImage(ctx, ref), ErrClosed)
+	_, err = pvd.GarbageCollect(ctx)
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = pvd.ListImages(ctx)
+	require.ErrorIs(t, err, ErrClosed)
+
+	// The work directory can be reopened without waiting for the lock.
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		pvd, _, err := NewLocalProvider(workDir, hosts, platforms.All)
+		if err == nil {
+			_, err = pvd.Image(ctx, ref)
+			pvd.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout to reopen the work directory")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+
+	// Block the manifest upload until the provider starts draining.
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	var once sync.Once
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			once.Do(func() { close(started) })
+			<-unblock
+		}
+		return false
+	})
+	pushed := make(chan error, 1)
+	go func() {
+		pushed <- pvd.Push(ctx, *desc, reg.ref("library/foo:pushed"))
+	}()
+	<-started
+
+	// The in-flight push isn't finished before the deadline.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, pvd.Shutdown(timeoutCtx), context.DeadlineExceeded)
+	require.ErrorIs(t, pvd.Pull(ctx, ref), ErrShuttingDown)
+	require.ErrorIs(t, pvd.Push(ctx, *desc, reg.ref("library/foo:rejected")), ErrShuttingDown)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- pvd.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returns before the push finishes: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(unblock)
+	require.NoError(t, <-pushed)
+	require.NoError(t, <-shutdown)
+	require.True(t, pvd.isClosed())
+	reg.mutex.Lock()
+	_, ok := reg.tags["library/foo:pushed"]
+	reg.mutex.Unlock()
+	require.True(t, ok)
+	require.ErrorIs(t, pvd.Shutdown(ctx), ErrClosed)
+}
+
+func TestHealthCheck(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	workDir := t.TempDir()
+	pvd := newTestProviderWithDir(t, workDir)
+	ctx := testContext()
+	require.NoError(t, pvd.HealthCheck(ctx, ""))
+	require.NoError(t, pvd.HealthCheck(ctx, reg.ref("library/foo:latest")))
+	require.NotZero(t, reg.count(http.MethodHead, "/manifests/"))
+
+	err := pvd.HealthCheck(ctx, reg.ref("library/foo:notfound"))
+	require.ErrorContains(t, err, "registry is unhealthy")
+
+	require.NoError(t, pvd.Close())
+	err = pvd.HealthCheck(ctx, "")
+	require.ErrorIs(t, err, ErrClosed)
+	require.ErrorContains(t, err, "store is unhealthy")
+}
+
+func TestKnownDigests(t *testing.T) {
+	reg := newTestRegistry(t)
+	shared := [][]byte{[]byte("shared-layer-1"), []byte("shared-layer-2")}
+	reg.addImage("library/foo", "latest", shared...)
+	reg.addImage("library/bar", "latest", append(shared, []byte("bar-layer-1"))...)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+
+	known := []digest.Digest{}
+	for _, layer := range shared {
+		dgst := digest.FromBytes(layer)
+		known = append(known, dgst)
+		require.Equal(t, 1, reg.count(http.MethodGet, "/blobs/"+dgst.String()))
+	}
+	ref := reg.ref("library/bar:latest")
+	require.NoError(t, pvd.Pull(ctx, ref, WithKnownDigests(known...)))
+	for _, dgst := range known {
+		require.Equal(t, 1, reg.count(http.MethodGet, "/blobs/"+dgst.String()))
+	}
+	require.Equal(t, 1, reg.count(http.MethodGet, "/blobs/"+digest.FromBytes([]byte("bar-layer-1")).String()))
+
+	// The image references the existing blobs.
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	manifest, err := images.Manifest(ctx, pvd.ContentStore(), *desc, platforms.All)
+	require.NoError(t, err)
+	for _, layer := range manifest.Layers {
+		_, err := pvd.ContentStore().Info(ctx, layer.Digest)
+		require.NoError(t, err)
+	}
+	require.NoError(t, pvd.deleteImage(ctx, reg.ref("library/foo:latest")))
+	_, err = pvd.GarbageCollect(ctx)
+	require.NoError(t, err)
+	for _, dgst := range known {
+		_, err := pvd.ContentStore().Info(ctx, dgst)
+		require.NoError(t, err)
+	}
+}
+
+func TestMountFrom(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+
+	// The blobs exist in library/base but not in library/target.
+	var mounted int32
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Path, "/v2/library/target/blobs/") {
+			return false
+		}
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return true
+		}
+		query := r.URL.Query()
+		if r.Method == http.MethodPost && query.Get("from") == "library/base" && query.Get("mount") != "" {
+			atomic.AddInt32(&mounted, 1)
+			w.Header().Set("Docker-Content-Digest", query.Get("mount"))
+			w.WriteHeader(http.StatusCreated)
+			return true
+		}
+		return false
+	})
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	desc, err := pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/target:latest"), WithMountFrom("library/base")))
+	// The config and two layers are mounted.
+	require.Equal(t, int32(3), atomic.LoadInt32(&mounted))
+	require.Equal(t, 0, reg.count(http.MethodPatch, "/v2/library/target/blobs/uploads/"))
+	require.Equal(t, 0, reg.count(http.MethodPut, "/v2/library/target/blobs/uploads/"))
+	require.Equal(t, 1, reg.count(http.MethodPut, "/v2/library/target/manifests/"))
+
+	// The blobs are uploaded without mount candidates.
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/target:other")))
+	require.Equal(t, int32(3), atomic.LoadInt32(&mounted))
+	require.Equal(t, 3, reg.count(http.MethodPut, "/v2/library/target/blobs/uploads/"))
+}
+
+func TestNamespace(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+	ref := reg.ref("library/foo:latest")
+	layer := digest.FromBytes([]byte("foo-layer-1"))
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	workDir := t.TempDir()
+	pvd, db, err := NewLocalProvider(workDir, hosts, platforms.All, WithNamespace("isolated"))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+
+	// The namespace of context is overridden by the provider.
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, ref))
+	_, err = pvd.ContentStore().Info(context.Background(), layer)
+	require.NoError(t, err)
+	_, err = db.ContentStore().Info(namespaces.WithNamespace(context.Background(), "isolated"), layer)
+	require.NoError(t, err)
+	_, err = db.ContentStore().Info(namespaces.WithNamespace(context.Background(), DefaultNamespace), layer)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.NoError(t, pvd.Close())
+
+	pvd, _, err = NewLocalProvider(workDir, hosts, platforms.All)
+	require.NoError(t, err)
+	defer pvd.Close()
+	_, err = pvd.Image(ctx, ref)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	_, err = pvd.ContentStore().Info(ctx, layer)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	// The garbage collection in default namespace keeps the blobs of the other.
+	_, err = pvd.GarbageCollect(ctx)
+	require.NoError(t, err)
+	_, err = pvd.backend.Info(ctx, layer)
+	require.NoError(t, err)
+
+	_, _, err = NewLocalProvider(t.TempDir(), hosts, platforms.All, WithNamespace("invalid/namespace"))
+	require.Error(t, err)
+}
+
+func TestLease(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer-1"))
+	foo := reg.ref("library/foo:latest")
+	bar := reg.ref("library/bar:latest")
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	// The blobs pulled before the lease are added to it on reuse.
+	require.NoError(t, pvd.Pull(ctx, bar))
+
+	leaseCtx, release, err := pvd.WithLease(ctx)
+	require.NoError(t, err)
+	require.NoError(t, pvd.Pull(leaseCtx, foo))
+	require.NoError(t, pvd.Pull(leaseCtx, bar))
+	blob := []byte("converted-blob")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	}
+	require.NoError(t, content.WriteBlob(leaseCtx, pvd.ContentStore(), "converted-blob", bytes.NewReader(blob), desc))
+	require.Equal(t, 8, countBlobs(t, pvd))
+
+	// The leased blobs survive garbage collection without images.
+	require.NoError(t, pvd.deleteImage(ctx, foo))
+	require.NoError(t, pvd.deleteImage(ctx, bar))
+	stats, err := pvd.GarbageCollect(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.RemovedBlobs)
+	require.Equal(t, 8, countBlobs(t, pvd))
+
+	require.NoError(t, release())
+	// Releasing a released lease is a no-op.
+	require.NoError(t, release())
+	stats, err = pvd.GarbageCollect(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 8, stats.RemovedBlobs)
+	require.Equal(t, 0, countBlobs(t, pvd))
+}
+
+func TestNewProviderWithStore(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	ref := reg.ref("library/foo:latest")
+
+	dir := t.TempDir()
+	store, err := local.NewStore(filepath.Join(dir, "content"))
+	require.NoError(t, err)
+	bdb, err := bolt.Open(filepath.Join(dir, "meta.db"), 0600, nil)
+	require.NoError(t, err)
+	defer bdb.Close()
+	db := metadata.NewDB(bdb, store, nil)
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+
+	pvd := NewProviderWithStore(store, db, hosts, platforms.All)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	// The blobs are written to the injected store.
+	_, err = store.Info(ctx, desc.Digest)
+	require.NoError(t, err)
+
+	// The injected database isn't released on Close.
+	require.NoError(t, pvd.(*LocalProvider).Close())
+	pvd = NewProviderWithStore(store, db, hosts, platforms.All)
+	reloaded, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, desc, reloaded)
+}
+
+func TestManifestBytes(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	reg.addIndex("library/bar", "latest", reg.addManifest(&amd64, []byte("bar-layer")))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	for _, ref := range []string{reg.ref("library/foo:latest"), reg.ref("library/bar:latest")} {
+		require.NoError(t, pvd.Pull(ctx, ref))
+		data, desc, err := pvd.ManifestBytes(ctx, ref)
+		require.NoError(t, err)
+		target, err := pvd.Image(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, *target, desc)
+		require.Equal(t, desc.Digest, digest.FromBytes(data))
+		require.Equal(t, desc.Size, int64(len(data)))
+	}
+
+	_, _, err := pvd.ManifestBytes(ctx, reg.ref("library/foo:notfound"))
+	require.True(t, errdefs.IsNotFound(err))
+}
+
+func TestReferrers(t *testing.T) {
+	reg := newTestRegistry(t)
+	foo := reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	ref := reg.ref("library/foo:latest")
+	sbom := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/spdx+json",
+		Digest:       digest.FromString("sbom"),
+		Size:         4,
+	}
+	signature := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+		Digest:       digest.FromString("signature"),
+		Size:         9,
+	}
+	referrers := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{sbom, signature},
+	}
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+
+	// The registry doesn't support the referrers API and has no referrers.
+	descs, err := pvd.Referrers(ctx, ref, "")
+	require.NoError(t, err)
+	require.NotNil(t, descs)
+	require.Empty(t, descs)
+
+	// The referrers are tagged by the digest of image.
+	reg.tag("library/foo", strings.Replace(foo.Digest.String(), ":", "-", 1), reg.addJSON(ocispec.MediaTypeImageIndex, referrers))
+	descs, err = pvd.Referrers(ctx, ref, "application/spdx+json")
+	require.NoError(t, err)
+	require.Equal(t, []ocispec.Descriptor{sbom}, descs)
+
+	// The registry supports the referrers API without filtering.
+	var queries []string
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v2/library/foo/referrers/"+foo.Digest.String() {
+			return false
+		}
+		queries = append(queries, r.URL.Query().Get("artifactType"))
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+		json.NewEncoder(w).Encode(referrers)
+		return true
+	})
+	descs, err = pvd.Referrers(ctx, ref, "")
+	require.NoError(t, err)
+	require.Equal(t, []ocispec.Descriptor{sbom, signature}, descs)
+	descs, err = pvd.Referrers(ctx, ref, signature.ArtifactType)
+	require.NoError(t, err)
+	require.Equal(t, []ocispec.Descriptor{signature}, descs)
+	descs, err = pvd.Referrers(ctx, ref, "application/unknown")
+	require.NoError(t, err)
+	require.NotNil(t, descs)
+	require.Empty(t, descs)
+	require.Equal(t, []string{"", signature.ArtifactType, "application/unknown"}, queries)
+}
+
+func TestPrune(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.Equal(t, 3, countBlobs(t, pvd))
+
+	writeBlob := func(ctx context.Context, blob []byte) digest.Digest {
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromBytes(blob),
+			Size:      int64(len(blob)),
+		}
+		require.NoError(t, content.WriteBlob(ctx, pvd.ContentStore(), string(blob), bytes.NewReader(blob), desc))
+		return desc.Digest
+	}
+	orphan := writeBlob(ctx, []byte("orphan-blob"))
+	leaseCtx, release, err := pvd.WithLease(ctx)
+	require.NoError(t, err)
+	leased := writeBlob(leaseCtx, []byte("leased-blob"))
+	require.Equal(t, 5, countBlobs(t, pvd))
+
+	removed, freed, err := pvd.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.Equal(t, int64(len("orphan-blob")), freed)
+	_, err = pvd.ContentStore().Info(ctx, orphan)
+	require.True(t, errdefs.IsNotFound(err))
+	_, err = pvd.ContentStore().Info(ctx, leased)
+	require.NoError(t, err)
+	require.Equal(t, 4, countBlobs(t, pvd))
+	_, err = os.Stat(filepath.Join(pvd.contentDir, "blobs", orphan.Algorithm().String(), orphan.Encoded()))
+	require.True(t, os.IsNotExist(err))
+
+	// The leased blob is pruned once the lease is released.
+	require.NoError(t, release())
+	removed, _, err = pvd.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.Equal(t, 3, countBlobs(t, pvd))
+}
+
+func TestForeignLayer(t *testing.T) {
+	layer := []byte("foreign-layer")
+	var mutex sync.Mutex
+	paths := []string{}
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		paths = append(paths, r.URL.Path)
+		mutex.Unlock()
+		if r.URL.Path != "/layer" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(layer)
+	}))
+	defer stub.Close()
+
+	reg := newTestRegistry(t)
+	layerDesc := ocispec.Descriptor{
+		MediaType: images.MediaTypeDockerSchema2LayerForeignGzip,
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+		URLs:      []string{"ftp://example.com/layer", stub.URL + "/missing", stub.URL + "/layer"},
+	}
+	config := reg.addJSON(images.MediaTypeDockerSchema2Config, ocispec.Image{
+		RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layerDesc.Digest}},
+	})
+	manifest := reg.addJSON(images.MediaTypeDockerSchema2Manifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: images.MediaTypeDockerSchema2Manifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	reg.tag("library/foo", "latest", manifest)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	data, err := content.ReadBlob(ctx, pvd.ContentStore(), layerDesc)
+	require.NoError(t, err)
+	require.Equal(t, layer, data)
+	require.Equal(t, []string{"/missing", "/layer"}, paths)
+	require.Equal(t, 0, reg.count(http.MethodGet, "/blobs/"+layerDesc.Digest.String()))
+
+	// The registry is tried if no URL serves the layer.
+	mirrored := []byte("mirrored-layer")
+	mirroredDesc := reg.addBlob(images.MediaTypeDockerSchema2LayerForeignGzip, mirrored)
+	mirroredDesc.URLs = []string{stub.URL + "/missing"}
+	manifest = reg.addJSON(images.MediaTypeDockerSchema2Manifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: images.MediaTypeDockerSchema2Manifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{mirroredDesc},
+	})
+	reg.tag("library/bar", "latest", manifest)
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest")))
+	data, err = content.ReadBlob(ctx, pvd.ContentStore(), mirroredDesc)
+	require.NoError(t, err)
+	require.Equal(t, mirrored, data)
+}
+
+func TestAnnotations(t *testing.T) {
+	reg := newTestRegistry(t)
+	manifestAnnotations := map[string]string{
+		ocispec.AnnotationCreated: "2023-01-02T03:04:05Z",
+		"com.example.manifest":    "manifest",
+	}
+	indexAnnotations := map[string]string{
+		ocispec.AnnotationSource: "https://example.com/foo",
+		"com.example.index":      "index",
+	}
+	layer := reg.addBlob(ocispec.MediaTypeImageLayer, newTarLayer(t, tarEntry{name: "foo", typeflag: tar.TypeReg, data: "foo"}))
+	manifest := reg.addJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: reg.addJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+			RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layer.Digest}},
+		}),
+		Layers:      []ocispec.Descriptor{layer},
+		Annotations: manifestAnnotations,
+	})
+	reg.tag("library/foo", "manifest", manifest)
+	manifest.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	index := reg.addJSON(ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageIndex,
+		Manifests:   []ocispec.Descriptor{manifest},
+		Annotations: indexAnnotations,
+	})
+	reg.tag("library/foo", "index", index)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	for tag, expected := range map[string]map[string]string{
+		"manifest": manifestAnnotations,
+		"index":    indexAnnotations,
+	} {
+		ref := reg.ref("library/foo:" + tag)
+		require.NoError(t, pvd.Pull(ctx, ref))
+		annotations, err := pvd.Annotations(ref)
+		require.NoError(t, err)
+		require.Equal(t, expected, annotations)
+
+		// The annotations survive the push round trip.
+		desc, err := pvd.Image(ctx, ref)
+		require.NoError(t, err)
+		pushed := reg.ref("library/bar:" + tag)
+		require.NoError(t, pvd.Push(ctx, *desc, pushed))
+		reg.mutex.Lock()
+		require.Equal(t, desc.Digest, reg.tags["library/bar:"+tag])
+		reg.mutex.Unlock()
+
+		other := newTestProvider(t)
+		require.NoError(t, other.Pull(ctx, pushed))
+		annotations, err = other.Annotations(pushed)
+		require.NoError(t, err)
+		require.Equal(t, expected, annotations)
+	}
+
+	// The squashed image keeps the annotations of source manifest.
+	squashed := reg.ref("library/foo:squashed")
+	_, err := pvd.Squash(ctx, reg.ref("library/foo:index"), squashed)
+	require.NoError(t, err)
+	annotations, err := pvd.Annotations(squashed)
+	require.NoError(t, err)
+	require.Equal(t, manifestAnnotations, annotations)
+
+	_, err = pvd.Annotations(reg.ref("library/foo:unknown"))
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func TestPullArtifact(t *testing.T) {
+	reg := newTestRegistry(t)
+	subject := reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	sbom := reg.addBlob("application/spdx+json", []byte(`{"spdxVersion":"SPDX-2.3"}`))
+	signature := reg.addBlob("application/vnd.example.signature", []byte("signature"))
+	artifact := reg.addJSON(ocispec.MediaTypeArtifactManifest, ocispec.Artifact{
+		MediaType:    ocispec.MediaTypeArtifactManifest,
+		ArtifactType: "application/vnd.example.sbom",
+		Blobs:        []ocispec.Descriptor{sbom, signature},
+		Subject:      &subject,
+	})
+	reg.tag("library/foo", "sbom", artifact)
+	// The artifact in image manifest with unknown config.
+	config := reg.addBlob("application/vnd.example.config+json", []byte(`{"example":true}`))
+	manifest := reg.addJSON(ocispec.MediaTypeImageManifest, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ocispec.MediaTypeImageManifest,
+		"artifactType":  "application/vnd.example.sbom",
+		"config":        config,
+		"layers":        []ocispec.Descriptor{sbom},
+	})
+	reg.tag("library/foo", "sbom-manifest", manifest)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	for tag, expected := range map[string][]ocispec.Descriptor{
+		"sbom":          {artifact, sbom, signature},
+		"sbom-manifest": {manifest, config, sbom},
+	} {
+		ref := reg.ref("library/foo:" + tag)
+		require.NoError(t, pvd.Pull(ctx, ref))
+		desc, err := pvd.Image(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, expected[0].Digest, desc.Digest)
+
+		inspected, manifests, err := pvd.Inspect(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, expected[0].Digest, inspected.Digest)
+		require.Len(t, manifests, 1)
+
+		// The blobs are kept by garbage collection.
+		_, err = pvd.GarbageCollect(ctx)
+		require.NoError(t, err)
+		for _, blob := range expected {
+			_, err := pvd.ContentStore().Info(ctx, blob.Digest)
+			require.NoError(t, err)
+		}
+	}
+	// The subject of artifact isn't pulled.
+	_, err := pvd.ContentStore().Info(ctx, subject.Digest)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	// The artifact manifest is fetched from manifests endpoint.
+	require.Equal(t, 0, reg.count(http.MethodGet, "/blobs/"+artifact.Digest.String()))
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	reg := newTestRegistry(t)
+	layers := [][]byte{[]byte("foo-layer-1"), []byte("foo-layer-2"), []byte("foo-layer-3"), []byte("foo-layer-4")}
+	reg.addImage("library/foo", "latest", layers...)
+	ref := reg.ref("library/foo:latest")
+
+	pvd := newTestProvider(t)
+	pvd.SetMaxConcurrentUploads(2)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+
+	// Record the overlapped blob uploads, and the uploads which are
+	// still in flight when the manifest is pushed.
+	var (
+		mutex      sync.Mutex
+		inFlight   int
+		maxFlight  int
+		uploaded   int
+		uploadedAt int
+	)
+	target := newTestRegistry(t)
+	target.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			mutex.Lock()
+			uploadedAt = uploaded
+			if inFlight > 0 {
+				uploadedAt = -1
+			}
+			mutex.Unlock()
+			return false
+		}
+		if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/blobs/uploads/") {
+			return false
+		}
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mutex.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		uploaded++
+		mutex.Unlock()
+		return false
+	})
+	require.NoError(t, pvd.Push(ctx, *desc, target.ref("library/foo:latest")))
+	// The layers and config are uploaded before the manifest.
+	require.Equal(t, 2, maxFlight)
+	require.Equal(t, len(layers)+1, uploaded)
+	require.Equal(t, len(layers)+1, uploadedAt)
+
+	// The first failed upload fails the push.
+	failing := digest.FromBytes(layers[2])
+	target = newTestRegistry(t)
+	target.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && r.URL.Query().Get("digest") == failing.String() {
+			w.WriteHeader(http.StatusBadRequest)
+			return true
+		}
+		return false
+	})
+	require.Error(t, pvd.Push(ctx, *desc, target.ref("library/foo:latest")))
+	require.Zero(t, target.count(http.MethodPut, "/manifests/"))
+}
+
+func TestHooks(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	ref := reg.ref("library/foo:latest")
+	target := reg.ref("library/bar:latest")
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	var calls []string
+	record := func(name string) { calls = append(calls, name) }
+	pvd.SetHooks(Hooks{
+		PrePull: func(ctx context.Context, ref string) error {
+			record("pre-pull " + ref)
+			return nil
+		},
+		PostPull: func(ctx context.Context, ref string, desc ocispec.Descriptor) error {
+			record("post-pull " + ref)
+			return nil
+		},
+		PrePush: func(ctx context.Context, ref string, desc ocispec.Descriptor) error {
+			record("pre-push " + ref)
+			return nil
+		},
+		PostPush: func(ctx context.Context, ref string, desc ocispec.Descriptor) error {
+			record("post-push " + ref)
+			return errors.New("record provenance")
+		},
+	})
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	// The error of post-hook isn't fatal by default.
+	require.NoError(t, pvd.Push(ctx, *desc, target))
+	require.Equal(t, []string{"pre-pull " + ref, "post-pull " + ref, "pre-push " + target, "post-push " + target}, calls)
+
+	// The error of pre-hook aborts the operation.
+	calls = nil
+	denied := errors.New("denied by policy")
+	pvd.SetHooks(Hooks{
+		PrePull: func(ctx context.Context, ref string) error { return denied },
+		PrePush: func(ctx context.Context, ref string, desc ocispec.Descriptor) error { return denied },
+	})
+	require.NoError(t, pvd.DeleteImage(ctx, ref))
+	pulls := reg.count(http.MethodGet, "/manifests/")
+	require.ErrorIs(t, pvd.Pull(ctx, ref), denied)
+	require.Equal(t, pulls, reg.count(http.MethodGet, "/manifests/"))
+	_, err = pvd.Image(ctx, ref)
+	require.True(t, errdefs.IsNotFound(err))
+	pushes := reg.count(http.MethodPut, "/manifests/")
+	require.ErrorIs(t, pvd.Push(ctx, *desc, target), denied)
+	require.Equal(t, pushes, reg.count(http.MethodPut, "/manifests/"))
+
+	// The error of post-hook is returned if configured.
+	pvd.SetHooks(Hooks{
+		PostPull:            func(ctx context.Context, ref string, desc ocispec.Descriptor) error { return denied },
+		FailOnPostHookError: true,
+	})
+	require.ErrorIs(t, pvd.Pull(ctx, ref), denied)
+}
+
+func TestNormalizeRef(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/ubuntu", "latest", []byte("ubuntu-layer"))
+
+	pvd := newTestProvider(t)
+	pvd.SetResolverOpts(remote.WithHostOverride("registry-1.docker.io", reg.host()))
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, "ubuntu"))
+	desc, err := pvd.Image(ctx, "ubuntu")
+	require.NoError(t, err)
+
+	// The equivalent references hit the same image without pulling.
+	pulls := reg.count(http.MethodGet, "/manifests/")
+	for _, ref := range []string{"docker.io/library/ubuntu:latest", "library/ubuntu"} {
+		cached, err := pvd.Image(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, desc, cached)
+		require.NoError(t, pvd.Pull(ctx, ref))
+	}
+	require.Equal(t, pulls, reg.count(http.MethodGet, "/manifests/"))
+
+	images, err := pvd.imageStore.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	require.Equal(t, "docker.io/library/ubuntu:latest", images[0].Name)
+
+	require.NoError(t, pvd.DeleteImage(ctx, "library/ubuntu:latest"))
+	_, err = pvd.Image(ctx, "ubuntu")
+	require.True(t, errdefs.IsNotFound(err))
+}
+
+func TestManifestList(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer"))
+	refs := []string{reg.ref("library/foo:latest"), reg.ref("library/bar:latest")}
+
+	src := newTestProvider(t)
+	ctx := testContext()
+	for _, ref := range refs {
+		require.NoError(t, src.Pull(ctx, ref))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, src.ExportManifestList(&buf))
+	exported := buf.String()
+
+	dst := newTestProvider(t)
+	require.NoError(t, dst.ImportManifestList(&buf))
+	require.Len(t, dst.pending, len(refs))
+	require.Zero(t, countBlobs(t, dst))
+
+	// The imported image is pulled on the first Image call.
+	for _, ref := range refs {
+		expected, err := src.Image(ctx, ref)
+		require.NoError(t, err)
+		desc, err := dst.Image(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, expected, desc)
+	}
+	require.Empty(t, dst.pending)
+	require.Equal(t, countBlobs(t, src), countBlobs(t, dst))
+
+	_, err := dst.Image(ctx, reg.ref("library/baz:latest"))
+	require.True(t, errdefs.IsNotFound(err))
+
+	// The imported image isn't recorded if its reference is moved.
+	moved := newTestProvider(t)
+	require.NoError(t, moved.ImportManifestList(strings.NewReader(exported)))
+	reg.addImage("library/foo", "latest", []byte("moved-layer"))
+	_, err = moved.Image(ctx, refs[0])
+	require.ErrorContains(t, err, "doesn't match the pinned digest")
+	_, err = moved.getImage(ctx, refs[0])
+	require.True(t, errdefs.IsNotFound(err))
+}
+
+func TestIPv6Registry(t *testing.T) {
+	reg := newIPv6TestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	ref := reg.ref("library/foo:latest")
+	require.True(t, strings.HasPrefix(ref, "[::1]:"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, reg.host(), refHost(ref))
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/bar:latest")))
+	require.Equal(t, 1, reg.count(http.MethodPut, "/v2/library/bar/manifests/"))
+}
+
+func TestPullPlatformVariant(t *testing.T) {
+	reg := newTestRegistry(t)
+	v7Layer, v8Layer := []byte("arm64-v7-layer"), []byte("arm64-v8-layer")
+	reg.addIndex("library/foo", "latest",
+		reg.addManifest(&ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v7"}, v7Layer),
+		reg.addManifest(&ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, v8Layer),
+	)
+	ref := reg.ref("library/foo:latest")
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	v8 := platformutil.NewStrictMatchComparer(ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})
+	require.NoError(t, pvd.Pull(ctx, ref, WithPullPlatform(v8)))
+	require.Equal(t, 1, reg.count(http.MethodGet, "/blobs/"+digest.FromBytes(v8Layer).String()))
+	require.Zero(t, reg.count(http.MethodGet, "/blobs/"+digest.FromBytes(v7Layer).String()))
+
+	// None of the manifests matches the platform.
+	riscv := platformutil.NewStrictMatchComparer(ocispec.Platform{OS: "linux", Architecture: "riscv64"})
+	err := pvd.Pull(ctx, ref, WithPullPlatform(riscv), WithForce())
+	require.ErrorIs(t, err, ErrPlatformNotFound)
+	require.Contains(t, err.Error(), "linux/arm64/v7, linux/arm64/v8")
+
+	// The OS version is matched if it's specified.
+	reg.addIndex("library/bar", "latest",
+		reg.addManifest(&ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1"}, []byte("ltsc2019-layer")),
+	)
+	ltsc2022 := platformutil.NewStrictMatchComparer(ocispec.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.1"})
+	require.ErrorIs(t, pvd.Pull(ctx, reg.ref("library/bar:latest"), WithPullPlatform(ltsc2022)), ErrPlatformNotFound)
+	windows := platformutil.NewStrictMatchComparer(ocispec.Platform{OS: "windows", Architecture: "amd64"})
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest"), WithPullPlatform(windows)))
+}
+
+func TestCopy(t *testing.T) {
+	src := newTestRegistry(t)
+	src.enableAuth("src-user", "src-pass")
+	src.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+	dst := newTestRegistry(t)
+	dst.enableAuth("dst-user", "dst-pass")
+
+	pvd := newTestProviderWithCred(t, t.TempDir(), func(host string) (string, string, error) {
+		if host == src.host() {
+			return "src-user", "src-pass", nil
+		}
+		return "dst-user", "dst-pass", nil
+	})
+	ctx := testContext()
+	srcRef, dstRef := src.ref("library/foo:latest"), dst.ref("mirror/foo:v1")
+	require.NoError(t, pvd.Copy(ctx, srcRef, dstRef))
+
+	desc, err := pvd.Image(ctx, srcRef)
+	require.NoError(t, err)
+	dst.mutex.Lock()
+	require.Equal(t, desc.Digest, dst.tags["mirror/foo:v1"])
+	dst.mutex.Unlock()
+	// The lease of copy is released.
+	leases, err := pvd.leaseManager.List(namespaces.WithNamespace(ctx, pvd.namespace))
+	require.NoError(t, err)
+	require.Empty(t, leases)
+
+	require.Error(t, pvd.Copy(ctx, src.ref("library/bar:latest"), dstRef))
+}
+
+func TestSkipExisting(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	ref := reg.ref("library/foo:latest")
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+
+	// The destination already has the same manifest.
+	var result PushResult
+	heads := reg.count(http.MethodHead, "/manifests/latest")
+	require.NoError(t, pvd.Push(ctx, *desc, ref, WithSkipExisting(&result)))
+	require.Equal(t, PushSkipped, result)
+	require.Zero(t, reg.count(http.MethodPost, "/blobs/uploads/"))
+	require.Zero(t, reg.count(http.MethodPut, "/"))
+	require.Equal(t, heads+1, reg.count(http.MethodHead, "/manifests/latest"))
+
+	// The image is pushed if the destination differs or overwritten.
+	target := reg.ref("library/bar:latest")
+	require.NoError(t, pvd.Push(ctx, *desc, target, WithSkipExisting(&result)))
+	require.Equal(t, PushUploaded, result)
+	require.Equal(t, 1, reg.count(http.MethodPut, "/v2/library/bar/manifests/"))
+	result = PushSkipped
+	require.NoError(t, pvd.Push(ctx, *desc, target, WithSkipExisting(&result), WithOverwrite()))
+	require.Equal(t, PushUploaded, result)
+}
+
+func TestConfig(t *testing.T) {
+	reg := newTestRegistry(t)
+	addImage := func(platform ocispec.Platform, entrypoint string) ocispec.Descriptor {
+		layer := reg.addBlob(ocispec.MediaTypeImageLayer, []byte(entrypoint+"-layer"))
+		config := reg.addJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+			OS:           platform.OS,
+			Architecture: platform.Architecture,
+			Config: ocispec.ImageConfig{
+				Entrypoint: []string{entrypoint},
+				Env:        []string{"ARCH=" + platform.Architecture},
+			},
+			RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layer.Digest}},
+		})
+		desc := reg.addJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []ocispec.Descriptor{layer},
+		})
+		desc.Platform = &platform
+		return desc
+	}
+	reg.addIndex("library/foo", "latest",
+		addImage(ocispec.Platform{OS: "linux", Architecture: "amd64"}, "/amd64-entrypoint"),
+		addImage(ocispec.Platform{OS: "linux", Architecture: "arm64"}, "/arm64-entrypoint"),
+	)
+	ref := reg.ref("library/foo:latest")
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.OnlyStrict(ocispec.Platform{OS: "linux", Architecture: "arm64"}))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+	config, err := pvd.Config(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/arm64-entrypoint"}, config.Config.Entrypoint)
+	require.Equal(t, []string{"ARCH=arm64"}, config.Config.Env)
+
+	// Only the index, manifest and config are fetched and cached.
+	require.Equal(t, 3, countBlobs(t, pvd))
+	require.Zero(t, reg.count(http.MethodGet, "/blobs/"+digest.FromBytes([]byte("/arm64-entrypoint-layer")).String()))
+	_, err = pvd.Config(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, 1, reg.count(http.MethodGet, "/blobs/"))
+
+	_, err = pvd.Config(ctx, reg.ref("library/bar:latest"))
+	require.True(t, errdefs.IsNotFound(err))
+}
+
+func TestDedupRoot(t *testing.T) {
+	reg := newTestRegistry(t)
+	layer := []byte("dedup-layer")
+	reg.addImage("library/foo", "latest", layer)
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	dedupRoot := t.TempDir()
+	var workDirs []string
+	for i := 0; i < 2; i++ {
+		workDir := t.TempDir()
+		pvd, _, err := NewLocalProvider(workDir, hosts, platforms.All, WithDedupRoot(dedupRoot))
+		require.NoError(t, err)
+		pvd.UsePlainHTTP()
+		require.NoError(t, pvd.Pull(testContext(), reg.ref("library/foo:latest")))
+		require.NoError(t, pvd.bdb.Close())
+		workDirs = append(workDirs, workDir)
+	}
+
+	dgst := digest.FromBytes(layer)
+	// The layer is fetched by the first provider only.
+	require.Equal(t, 1, reg.count(http.MethodGet, "/blobs/"+dgst.String()))
+	var infos []os.FileInfo
+	for _, root := range []string{dedupRoot, filepath.Join(workDirs[0], "content"), filepath.Join(workDirs[1], "content")} {
+		info, err := os.Stat(blobPath(root, dgst))
+		require.NoError(t, err)
+		infos = append(infos, info)
+	}
+	require.True(t, os.SameFile(infos[0], infos[1]))
+	require.True(t, os.SameFile(infos[0], infos[2]))
+}
+
+func TestLinkOrCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.WriteFile(src, []byte("blob"), 0444))
+
+	dst := filepath.Join(dir, "copied", "dst")
+	require.NoError(t, copyFile(src, filepath.Join(dir, "dst")))
+	require.NoError(t, linkOrCopy(filepath.Join(dir, "dst"), dst))
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, []byte("blob"), data)
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0444), info.Mode().Perm())
+
+	require.True(t, os.IsExist(linkOrCopy(src, dst)))
+}
+
+func TestManifestAccept(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	schema1 := []byte(`{"schemaVersion": 1, "name": "library/foo", "tag": "latest"}`)
+	var alwaysSchema1 int32
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		// The manifests fetched by digest accept the resolved media type.
+		if !strings.HasSuffix(r.URL.Path, "/manifests/latest") {
+			return false
+		}
+		// Serve schema1 unless the manifest types are accepted precisely.
+		accept := r.Header.Get("Accept")
+		if atomic.LoadInt32(&alwaysSchema1) == 0 && !strings.Contains(accept, "*/*") &&
+			strings.Contains(accept, ocispec.MediaTypeImageIndex) &&
+			strings.Contains(accept, ocispec.MediaTypeImageManifest) &&
+			strings.Contains(accept, images.MediaTypeDockerSchema2ManifestList) &&
+			strings.Contains(accept, images.MediaTypeDockerSchema2Manifest) {
+			return false
+		}
+		w.Header().Set("Content-Type", images.MediaTypeDockerSchema1Manifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(schema1).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(schema1)))
+		if r.Method == http.MethodGet {
+			w.Write(schema1)
+		}
+		return true
+	})
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+
+	atomic.StoreInt32(&alwaysSchema1, 1)
+	err := pvd.Pull(ctx, reg.ref("library/foo:latest"), WithForce())
+	require.ErrorIs(t, err, ErrUnsupportedManifest)
+}
+
+func TestDigestIndex(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "a", []byte("foo-layer"))
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	workDir := t.TempDir()
+	pvd, _, err := NewLocalProvider(workDir, hosts, platforms.All, WithDigestIndex())
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+
+	refA, refB := reg.ref("library/foo:a"), reg.ref("library/foo:b")
+	require.NoError(t, pvd.Pull(ctx, refA))
+	desc, err := pvd.Image(ctx, refA)
+	require.NoError(t, err)
+	indexed, err := pvd.Image(ctx, desc.Digest.String())
+	require.NoError(t, err)
+	require.Equal(t, *desc, *indexed)
+
+	// The digest is indexed until all the references are deleted.
+	require.NoError(t, pvd.Tag(ctx, refA, refB))
+	require.NoError(t, pvd.DeleteImage(ctx, refA))
+	_, err = pvd.Image(ctx, desc.Digest.String())
+	require.NoError(t, err)
+
+	// The index is restored from database.
+	require.NoError(t, pvd.bdb.Close())
+	pvd, _, err = NewLocalProvider(workDir, hosts, platforms.All, WithDigestIndex())
+	require.NoError(t, err)
+	_, err = pvd.Image(ctx, desc.Digest.String())
+	require.NoError(t, err)
+
+	require.NoError(t, pvd.DeleteImage(ctx, refB))
+	_, err = pvd.Image(ctx, desc.Digest.String())
+	require.True(t, errdefs.IsNotFound(err))
+
+	// The digest isn't indexed by default.
+	plain := newTestProvider(t)
+	require.NoError(t, plain.Pull(ctx, refA))
+	_, err = plain.Image(ctx, desc.Digest.String())
+	require.True(t, errdefs.IsNotFound(err))
+}
+
+func TestPushAccepted(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+
+	var mutex sync.Mutex
+	accepted, polls, invisible := false, 0, 2
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasSuffix(r.URL.Path, "/manifests/pushed") {
+			return false
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method == http.MethodPut {
+			// The manifest is stored but answered by 202 Accepted.
+			rec := httptest.NewRecorder()
+			reg.serveManifest(rec, r, "library/foo", "pushed")
+			for key, values := range rec.Header() {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(http.StatusAccepted)
+			accepted, polls = true, 0
+			return true
+		}
+		if accepted && polls < invisible {
+			polls++
+			w.WriteHeader(http.StatusNotFound)
+			return true
+		}
+		return false
+	})
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	desc, err := pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+
+	// Push returns once the manifest is visible.
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed")))
+	mutex.Lock()
+	require.Equal(t, invisible, polls)
+	mutex.Unlock()
+	resolver, err := pvd.Resolver(reg.ref("library/foo:pushed"))
+	require.NoError(t, err)
+	_, resolved, err := resolver.Resolve(ctx, reg.ref("library/foo:pushed"))
+	require.NoError(t, err)
+	require.Equal(t, desc.Digest, resolved.Digest)
+
+	// The polling is bounded.
+	pollConfig := acceptedPollConfig
+	acceptedPollConfig = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	defer func() {
+		acceptedPollConfig = pollConfig
+	}()
+	mutex.Lock()
+	invisible = 100
+	mutex.Unlock()
+	require.Error(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed")))
+	mutex.Lock()
+	require.Equal(t, 3, polls)
+	mutex.Unlock()
+}
+
+func TestSharedPull(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer"))
+	// The resolving is delayed so that the concurrent pulls overlap.
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(r.URL.Path, "/manifests/latest") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return false
+	})
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		repo := "library/foo"
+		if i%2 == 1 {
+			repo = "library/bar"
+		}
+		go func() {
+			defer wg.Done()
+			errs <- pvd.Pull(ctx, reg.ref(repo+":latest"))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// Each reference is resolved and fetched once.
+	for _, repo := range []string{"library/foo", "library/bar"} {
+		require.Equal(t, 1, reg.count(http.MethodHead, repo+"/manifests/latest"), repo)
+		_, err := pvd.Image(ctx, reg.ref(repo+":latest"))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, reg.count(http.MethodGet, "/blobs/"+digest.FromBytes([]byte("foo-layer")).String()))
+}
+
+func TestSharedPullCanceled(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(r.URL.Path, "/manifests/latest") {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return false
+	})
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	firstCtx, cancel := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() {
+		first <- pvd.Pull(firstCtx, reg.ref("library/foo:latest"))
+	}()
+	second := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		second <- pvd.Pull(ctx, reg.ref("library/foo:latest"))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	// The first caller gives up while the shared pull goes on for the second.
+	require.ErrorIs(t, <-first, context.Canceled)
+	require.NoError(t, <-second)
+	require.Equal(t, 1, reg.count(http.MethodHead, "library/foo/manifests/latest"))
+	_, err := pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+}
+
+func TestConcurrentFetchIsolation(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+	layer := digest.FromBytes([]byte("foo-layer"))
+	// The blob fetching is delayed so that the fetches of providers overlap.
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(r.URL.Path, "/blobs/"+layer.String()) {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return false
+	})
+
+	ctx := testContext()
+	pvds := []*LocalProvider{newTestProvider(t), newTestProvider(t)}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(pvds))
+	for _, pvd := range pvds {
+		wg.Add(1)
+		go func(pvd *LocalProvider) {
+			defer wg.Done()
+			errs <- pvd.Pull(ctx, reg.ref("library/foo:latest"))
+		}(pvd)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// Each provider fetches the blob into its own store.
+	for _, pvd := range pvds {
+		_, err := pvd.ContentStore().Info(ctx, layer)
+		require.NoError(t, err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	reg := newTestRegistry(t)
+	layer := []byte("foo-layer")
+	reg.addImage("library/foo", "latest", layer)
+
+	var buf bytes.Buffer
+	pvd := newTestProvider(t)
+	pvd.SetAuditLog(&buf)
+	ctx := testContext()
+
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed")))
+	require.Error(t, pvd.Pull(ctx, reg.ref("library/foo:notfound")))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	records := make([]AuditRecord, len(lines))
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &records[i]))
+		require.False(t, records[i].Time.IsZero())
+		require.GreaterOrEqual(t, records[i].Duration, float64(0))
+	}
+
+	pulled := records[0]
+	require.Equal(t, "pull", pulled.Direction)
+	require.Equal(t, ref, pulled.Ref)
+	require.Equal(t, desc.Digest, pulled.Digest)
+	_, size, err := pvd.usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, size, pulled.Bytes)
+	require.GreaterOrEqual(t, pulled.Bytes, int64(len(layer)))
+	require.Equal(t, AuditSuccess, pulled.Outcome)
+	require.Empty(t, pulled.Error)
+
+	pushed := records[1]
+	require.Equal(t, "push", pushed.Direction)
+	require.Equal(t, reg.ref("library/foo:pushed"), pushed.Ref)
+	require.Equal(t, desc.Digest, pushed.Digest)
+	require.Equal(t, AuditSuccess, pushed.Outcome)
+
+	failed := records[2]
+	require.Equal(t, "pull", failed.Direction)
+	require.Empty(t, failed.Digest)
+	require.Equal(t, AuditFailure, failed.Outcome)
+	require.NotEmpty(t, failed.Error)
+}
+
+func TestDedupStats(t *testing.T) {
+	reg := newTestRegistry(t)
+	shared := []byte("shared-layer")
+	fooDesc := reg.addImage("library/foo", "latest", []byte("foo-layer"), shared, shared)
+	reg.addImage("library/bar", "latest", []byte("bar-layer"), shared)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	fooRef, barRef := reg.ref("library/foo:latest"), reg.ref("library/bar:latest")
+	require.NoError(t, pvd.Pull(ctx, fooRef))
+
+	// The layer referenced twice is stored once.
+	foo, err := pvd.DedupStats(ctx, fooRef)
+	require.NoError(t, err)
+	require.Equal(t, foo.StoredBytes+int64(len(shared)), foo.LogicalBytes)
+	require.Equal(t, foo.StoredBytes, foo.UniqueBytes)
+	require.Greater(t, foo.Ratio(), float64(0))
+
+	require.NoError(t, pvd.Pull(ctx, barRef))
+	foo, err = pvd.DedupStats(ctx, fooRef)
+	require.NoError(t, err)
+	bar, err := pvd.DedupStats(ctx, barRef)
+	require.NoError(t, err)
+	require.Equal(t, foo.StoredBytes-int64(len(shared)), foo.UniqueBytes)
+	require.Equal(t, bar.StoredBytes-int64(len(shared)), bar.UniqueBytes)
+	require.Less(t, foo.UniqueBytes+bar.UniqueBytes, foo.LogicalBytes+bar.LogicalBytes)
+	require.Greater(t, foo.Ratio(), float64(len(shared))/float64(foo.LogicalBytes))
+	require.Less(t, foo.Ratio(), float64(1))
+
+	_, size, err := pvd.usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, size, foo.UniqueBytes+bar.UniqueBytes+int64(len(shared)))
+
+	// All the blobs are shared with the image of another reference.
+	reg.tag("library/foo", "v1", fooDesc)
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:v1")))
+	foo, err = pvd.DedupStats(ctx, fooRef)
+	require.NoError(t, err)
+	require.Zero(t, foo.UniqueBytes)
+	require.Equal(t, float64(1), foo.Ratio())
+
+	_, err = pvd.DedupStats(ctx, reg.ref("library/foo:notfound"))
+	require.True(t, errdefs.IsNotFound(err))
+}
+
+func TestWalk(t *testing.T) {
+	reg := newTestRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	amd64Manifest := reg.addManifest(&amd64, []byte("amd64-layer"), []byte("shared-layer"))
+	arm64Manifest := reg.addManifest(&arm64, []byte("arm64-layer"), []byte("shared-layer"))
+	index := reg.addIndex("library/foo", "latest", amd64Manifest, arm64Manifest)
+	ref := reg.ref("library/foo:latest")
+
+	digests := func(descs []ocispec.Descriptor) []digest.Digest {
+		dgsts := []digest.Digest{}
+		for _, desc := range descs {
+			dgsts = append(dgsts, desc.Digest)
+		}
+		return dgsts
+	}
+	manifestDigests := func(desc ocispec.Descriptor) []digest.Digest {
+		var manifest ocispec.Manifest
+		reg.mutex.Lock()
+		require.NoError(t, json.Unmarshal(reg.blobs[desc.Digest], &manifest))
+		reg.mutex.Unlock()
+		dgsts := []digest.Digest{desc.Digest, manifest.Config.Digest}
+		return append(dgsts, digests(manifest.Layers)...)
+	}
+	shared := digest.FromBytes([]byte("shared-layer"))
+
+	// The image not pulled is walked without fetching layers.
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	descs, err := pvd.Walk(ctx, ref)
+	require.NoError(t, err)
+	expected := append([]digest.Digest{index.Digest}, manifestDigests(amd64Manifest)...)
+	for _, dgst := range manifestDigests(arm64Manifest) {
+		if dgst != shared {
+			expected = append(expected, dgst)
+		}
+	}
+	require.ElementsMatch(t, expected, digests(descs))
+	require.Len(t, descs, 8)
+	require.Zero(t, reg.count(http.MethodGet, "/blobs/"+shared.String()))
+
+	// The pulled image is walked in content store.
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err = NewLocalProvider(t.TempDir(), hosts, platforms.Only(amd64))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	require.NoError(t, pvd.Pull(ctx, ref))
+	reg.mutex.Lock()
+	requests := len(reg.requests)
+	reg.mutex.Unlock()
+	descs, err = pvd.Walk(ctx, ref)
+	require.NoError(t, err)
+	expected = append([]digest.Digest{index.Digest}, manifestDigests(amd64Manifest)...)
+	require.ElementsMatch(t, append(expected, arm64Manifest.Digest), digests(descs))
+	reg.mutex.Lock()
+	require.Equal(t, requests, len(reg.requests))
+	reg.mutex.Unlock()
+}
+
+func TestIngestDir(t *testing.T) {
+	reg := newTestRegistry(t)
+	layer := []byte("foo-layer")
+	reg.addImage("library/foo", "latest", layer)
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	workDir, ingestDir := t.TempDir(), t.TempDir()
+	pvd, _, err := NewLocalProvider(workDir, hosts, platforms.All, WithIngestDir(ingestDir))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+	contentDir := filepath.Join(workDir, "content")
+
+	// The blob being written is in ingest directory only.
+	data := []byte("ingested-blob")
+	w, err := content.OpenWriter(ctx, pvd.ContentStore(), content.WithRef("ingest-test"))
+	require.NoError(t, err)
+	_, err = w.Write(data[:4])
+	require.NoError(t, err)
+	ingests, err := filepath.Glob(filepath.Join(ingestDir, "ingest", "*", "data"))
+	require.NoError(t, err)
+	require.Len(t, ingests, 1)
+	ingests, err = filepath.Glob(filepath.Join(contentDir, "ingest", "*"))
+	require.NoError(t, err)
+	require.Empty(t, ingests)
+
+	// The committed blob is moved into content directory.
+	_, err = w.Write(data[4:])
+	require.NoError(t, err)
+	dgst := digest.FromBytes(data)
+	require.NoError(t, w.Commit(ctx, int64(len(data)), dgst))
+	require.NoError(t, w.Close())
+	_, err = os.Stat(blobPath(contentDir, dgst))
+	require.NoError(t, err)
+	_, err = os.Stat(blobPath(ingestDir, dgst))
+	require.True(t, os.IsNotExist(err))
+	readData, err := content.ReadBlob(ctx, pvd.ContentStore(), ocispec.Descriptor{Digest: dgst, Size: int64(len(data))})
+	require.NoError(t, err)
+	require.Equal(t, data, readData)
+
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	_, err = os.Stat(blobPath(contentDir, digest.FromBytes(layer)))
+	require.NoError(t, err)
+	statuses, err := pvd.ContentStore().ListStatuses(ctx)
+	require.NoError(t, err)
+	require.Empty(t, statuses)
+}
+
+func TestFetchSchedule(t *testing.T) {
+	reg := newTestRegistry(t)
+	large := map[digest.Digest]bool{}
+	var layers [][]byte
+	for i := 0; i < 9; i++ {
+		layer := []byte("foo-layer-" + strconv.Itoa(i))
+		if i%2 == 0 {
+			layer = bytes.Repeat(layer, 100)
+		}
+		large[digest.FromBytes(layer)] = len(layer) >= 1024
+		layers = append(layers, layer)
+	}
+	reg.addImage("library/foo", "latest", layers...)
+
+	// The layer downloads are slowed down to overlap.
+	var mutex sync.Mutex
+	var running, maxRunning [2]int
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		idx := strings.LastIndex(r.URL.Path, "/blobs/")
+		if idx < 0 || r.Method != http.MethodGet {
+			return false
+		}
+		isLarge, ok := large[digest.Digest(r.URL.Path[idx+len("/blobs/"):])]
+		if !ok {
+			return false
+		}
+		class := 0
+		if isLarge {
+			class = 1
+		}
+		mutex.Lock()
+		running[class]++
+		if running[class] > maxRunning[class] {
+			maxRunning[class] = running[class]
+		}
+		mutex.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mutex.Lock()
+		running[class]--
+		mutex.Unlock()
+		return false
+	})
+
+	// The large layers are downloaded in parallel without schedule.
+	pvd := newTestProvider(t)
+	pvd.SetMaxConcurrentDownloads(0)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.Greater(t, maxRunning[1], 1)
+	require.NoError(t, pvd.DeleteImage(ctx, ref))
+
+	// The schedule replaces the limit of max concurrent downloads.
+	maxRunning = [2]int{}
+	pvd.SetMaxConcurrentDownloads(1)
+	pvd.SetFetchSchedule(FetchSchedule{
+		LargeLayerSize:     1024,
+		MaxConcurrentLarge: 1,
+		MaxConcurrentSmall: 4,
+	})
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.Equal(t, 1, maxRunning[1])
+	require.Greater(t, maxRunning[0], 1)
+	require.LessOrEqual(t, maxRunning[0], 4)
+}
+
+func TestMaxImageSize(t *testing.T) {
+	reg := newTestRegistry(t)
+	small := []byte("foo-layer")
+	large := bytes.Repeat([]byte("x"), 2048)
+	reg.addImage("library/foo", "small", small)
+	reg.addImage("library/foo", "large", small, large)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	reg.addIndex("library/foo", "index",
+		reg.addManifest(&amd64, []byte("foo-amd64-layer")),
+		reg.addManifest(&arm64, large),
+	)
+
+	pvd := newTestProvider(t)
+	pvd.SetMaxImageSize(1024)
+	ctx := testContext()
+
+	// None of the blobs is fetched for the large image.
+	err := pvd.Pull(ctx, reg.ref("library/foo:large"))
+	require.ErrorIs(t, err, ErrImageTooLarge)
+	require.Zero(t, reg.count(http.MethodGet, "/blobs/"))
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:small")))
+
+	// The selected platform of index is checked.
+	ref := reg.ref("library/foo:index")
+	require.NoError(t, pvd.Pull(ctx, ref, WithPullPlatform(platforms.OnlyStrict(amd64))))
+	err = pvd.Pull(ctx, ref, WithPullPlatform(platforms.OnlyStrict(arm64)), WithForce())
+	require.ErrorIs(t, err, ErrImageTooLarge)
+	require.Zero(t, reg.count(http.MethodGet, "/blobs/"+digest.FromBytes(large).String()))
+
+	pvd.SetMaxImageSize(0)
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:large")))
+}
+
+func TestReferenceRewriter(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer"))
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	rewriter := func(ref string) (string, error) {
+		if strings.Contains(ref, "forbidden") {
+			return "", errors.New("forbidden reference")
+		}
+		return strings.Replace(ref, "docker.io/", reg.host()+"/", 1), nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.All, WithReferenceRewriter(rewriter))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+
+	// The image of docker hub is pulled from the internal registry.
+	require.NoError(t, pvd.Pull(ctx, "docker.io/library/foo:latest"))
+	require.Equal(t, 1, reg.count(http.MethodGet, "/v2/library/foo/manifests/"))
+	desc, err := pvd.Image(ctx, "foo")
+	require.NoError(t, err)
+	rewritten, err := pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+	require.Equal(t, desc, rewritten)
+	infos, err := pvd.ListImages(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, reg.ref("library/foo:latest"), infos[0].Ref)
+
+	require.NoError(t, pvd.Push(ctx, *desc, "library/foo:pushed"))
+	reg.mutex.Lock()
+	_, ok := reg.tags["library/foo:pushed"]
+	reg.mutex.Unlock()
+	require.True(t, ok)
+
+	err = pvd.Pull(ctx, "library/forbidden:latest")
+	require.ErrorContains(t, err, "forbidden reference")
+}
+
+func TestExists(t *testing.T) {
+	reg := newTestRegistry(t)
+	manifest := reg.addImage("library/foo", "latest", []byte("foo-layer"))
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	exists, desc, err := pvd.Exists(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, manifest.Digest, desc.Digest)
+	require.Equal(t, manifest.Size, desc.Size)
+	require.Equal(t, manifest.MediaType, desc.MediaType)
+	// The manifest isn't fetched.
+	require.Equal(t, 1, reg.count(http.MethodHead, "/manifests/latest"))
+	require.Zero(t, reg.count(http.MethodGet, "/manifests/"))
+
+	exists, _, err = pvd.Exists(ctx, reg.ref("library/foo:notfound"))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	})
+	_, _, err = pvd.Exists(ctx, reg.ref("library/foo:latest"))
+	require.Error(t, err)
+}
+
+func TestDefaultPlatform(t *testing.T) {
+	reg := newTestRegistry(t)
+	host := platforms.DefaultSpec()
+	other := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	if host.Architecture == other.Architecture {
+		other.Architecture = "arm64"
+	}
+	hostManifest := reg.addManifest(&host, []byte("foo-host-layer"))
+	otherManifest := reg.addManifest(&other, []byte("foo-other-layer"))
+	reg.addIndex("library/foo", "latest", hostManifest, otherManifest)
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, nil)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.Equal(t, 1, reg.count(http.MethodGet, hostManifest.Digest.String()))
+	require.Zero(t, reg.count(http.MethodGet, otherManifest.Digest.String()))
+}
+
+func TestExportDockerArchive(t *testing.T) {
+	reg := newTestRegistry(t)
+	plain := []byte("foo-layer-1")
+	var buf bytes.Buffer
+	writer, err := compression.CompressStream(&buf, compression.Gzip)
+	require.NoError(t, err)
+	_, err = writer.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	gzipLayer := reg.addBlob(ocispec.MediaTypeImageLayerGzip, buf.Bytes())
+	layer := reg.addBlob(ocispec.MediaTypeImageLayer, []byte("foo-layer-2"))
+	config := reg.addJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+		RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromBytes(plain), layer.Digest}},
+	})
+	manifest := reg.addJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{gzipLayer, layer},
+	})
+	reg.tag("library/foo", "latest", manifest)
+	reg.tag("library/foo", "v1", manifest)
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:v1")))
+
+	var archive bytes.Buffer
+	require.NoError(t, pvd.ExportDockerArchive(ctx, ref, &archive))
+	files := map[string][]byte{}
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeReg {
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[hdr.Name] = data
+		}
+	}
+
+	var entries []dockerArchiveManifest
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &entries))
+	require.Len(t, entries, 1)
+	require.Equal(t, []string{ref, reg.ref("library/foo:v1")}, entries[0].RepoTags)
+	require.Equal(t, config.Digest.Encoded()+".json", entries[0].Config)
+	require.Equal(t, digest.FromBytes(files[entries[0].Config]), config.Digest)
+	// The layers are decompressed.
+	require.Equal(t, []string{
+		digest.FromBytes(plain).Encoded() + "/layer.tar",
+		layer.Digest.Encoded() + "/layer.tar",
+	}, entries[0].Layers)
+	require.Equal(t, plain, files[entries[0].Layers[0]])
+	require.Equal(t, []byte("foo-layer-2"), files[entries[0].Layers[1]])
+
+	var repositories map[string]map[string]string
+	require.NoError(t, json.Unmarshal(files["repositories"], &repositories))
+	require.Equal(t, map[string]string{
+		"latest": layer.Digest.Encoded(),
+		"v1":     layer.Digest.Encoded(),
+	}, repositories[reg.host()+"/library/foo"])
+
+	require.ErrorIs(t, pvd.ExportDockerArchive(ctx, reg.ref("library/foo:notfound"), io.Discard), errdefs.ErrNotFound)
+}
+
+func TestExportDockerArchivePulledPlatform(t *testing.T) {
+	reg := newTestRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	reg.addIndex("library/foo", "latest",
+		reg.addManifest(&amd64, []byte("foo-amd64-layer")),
+		reg.addManifest(&arm64, []byte("foo-arm64-layer")),
+	)
+
+	// The image is pulled for a platform other than the provider's.
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+	require.NoError(t, pvd.Pull(ctx, ref, WithPullPlatform(platforms.OnlyStrict(arm64))))
+
+	var archive bytes.Buffer
+	require.NoError(t, pvd.ExportDockerArchive(ctx, ref, &archive))
+	var layers [][]byte
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if strings.HasSuffix(hdr.Name, "/layer.tar") {
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			layers = append(layers, data)
+		}
+	}
+	require.Equal(t, [][]byte{[]byte("foo-arm64-layer")}, layers)
+}
+
+func TestJobID(t *testing.T) {
+	reg := newTestRegistry(t)
+	target := reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	logger, hook := logtest.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.All, WithLogger(logrus.NewEntry(logger)))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	var audit bytes.Buffer
+	pvd.SetAuditLog(&audit)
+	lastRecord := func() AuditRecord {
+		lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &record))
+		return record
+	}
+
+	ctx := WithJobID(testContext(), "job-1")
+	require.Equal(t, "job-1", JobIDFromContext(ctx))
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.NotEmpty(t, hook.AllEntries())
+	for _, entry := range hook.AllEntries() {
+		require.Equal(t, "job-1", entry.Data["job_id"])
+	}
+	require.Equal(t, "job-1", lastRecord().JobID)
+
+	// A request id is generated without job id.
+	hook.Reset()
+	require.NoError(t, pvd.Push(testContext(), target, reg.ref("library/foo:pushed")))
+	requestID := lastRecord().JobID
+	require.NotEmpty(t, requestID)
+	require.NotEmpty(t, hook.AllEntries())
+	for _, entry := range hook.AllEntries() {
+		require.Equal(t, requestID, entry.Data["job_id"])
+	}
+}
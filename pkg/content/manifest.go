@@ -0,0 +1,51 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ManifestBytes returns the raw manifest or index of pulled image ref and
+// its descriptor, the bytes are read verbatim from content store, so
+// they can be signed or cached with the digest of descriptor.
+func (pvd *LocalProvider) ManifestBytes(ctx context.Context, ref string) ([]byte, ocispec.Descriptor, error) {
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	target, err := pvd.getImage(ctx, ref)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	data, err := content.ReadBlob(ctx, *pvd.store, *target)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, errors.Wrapf(err, "read manifest %s", target.Digest)
+	}
+	if dgst := digest.FromBytes(data); dgst != target.Digest {
+		return nil, ocispec.Descriptor{}, errors.Errorf("unexpected digest %s of manifest %s", dgst, target.Digest)
+	}
+
+	return data, *target, nil
+}
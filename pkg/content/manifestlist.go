@@ -0,0 +1,131 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// manifestList is the persisted form of the cached images.
+type manifestList struct {
+	Images map[string]ocispec.Descriptor `json:"images"`
+}
+
+// ExportManifestList writes the references and descriptors of the cached
+// images as JSON, which can be imported by another provider to warm up
+// its cache.
+func (pvd *LocalProvider) ExportManifestList(w io.Writer) error {
+	pvd.mutex.Lock()
+	if pvd.closed {
+		pvd.mutex.Unlock()
+		return ErrClosed
+	}
+	list := manifestList{Images: make(map[string]ocispec.Descriptor, len(pvd.images))}
+	for ref, desc := range pvd.images {
+		list.Images[ref] = *desc
+	}
+	pvd.mutex.Unlock()
+
+	if err := json.NewEncoder(w).Encode(&list); err != nil {
+		return errors.Wrap(err, "encode manifest list")
+	}
+
+	return nil
+}
+
+// ImportManifestList registers the images of the manifest list exported
+// by ExportManifestList as known but not fetched, the image is pulled by
+// its reference on the first Image call. The images already cached are
+// not affected.
+func (pvd *LocalProvider) ImportManifestList(r io.Reader) error {
+	if pvd.readOnly {
+		return ErrReadOnly
+	}
+
+	var list manifestList
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return errors.Wrap(err, "decode manifest list")
+	}
+
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	if pvd.closed {
+		return ErrClosed
+	}
+	for ref, desc := range list.Images {
+		ref = normalizeRef(ref)
+		if _, ok := pvd.images[ref]; ok {
+			continue
+		}
+		pvd.pending[ref] = desc
+	}
+
+	return nil
+}
+
+// withExpectedDigest fails the pull if the reference isn't resolved to
+// dgst, and the image isn't recorded then.
+func withExpectedDigest(dgst digest.Digest) PullOpt {
+	return func(opts *PullOpts) error {
+		opts.expected = dgst
+		return nil
+	}
+}
+
+// pullPending pulls the image imported from manifest list, it returns
+// false if the image isn't imported. The pull fails if the reference is
+// moved to another image since the manifest list was exported.
+func (pvd *LocalProvider) pullPending(ctx context.Context, ref string) (bool, error) {
+	ref = normalizeRef(ref)
+	pvd.mutex.Lock()
+	desc, ok := pvd.pending[ref]
+	pvd.mutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	if err := pvd.Pull(ctx, ref, withExpectedDigest(desc.Digest)); err != nil {
+		return true, errors.Wrapf(err, "pull imported image %s", ref)
+	}
+	pvd.mutex.Lock()
+	delete(pvd.pending, ref)
+	pvd.mutex.Unlock()
+
+	return true, nil
+}
+
+// image returns the cached image, the image imported from manifest list
+// is pulled if it isn't cached yet.
+func (pvd *LocalProvider) image(ctx context.Context, ref string) (*ocispec.Descriptor, error) {
+	desc, err := pvd.getImage(ctx, ref)
+	if !errdefs.IsNotFound(err) {
+		return desc, err
+	}
+	imported, err := pvd.pullPending(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !imported {
+		return nil, errdefs.ErrNotFound
+	}
+	return pvd.getImage(ctx, ref)
+}
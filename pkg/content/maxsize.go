@@ -0,0 +1,57 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ErrImageTooLarge is returned by Pull if the layers declared by image
+// manifest exceed the max image size.
+var ErrImageTooLarge = errors.New("image too large")
+
+// SetMaxImageSize rejects the images whose total size of layers exceeds
+// size in bytes before fetching any layer, the manifest of each platform
+// selected from image index is checked separately, unlimited if size <= 0.
+func (pvd *LocalProvider) SetMaxImageSize(size int64) {
+	pvd.maxImageSize = size
+}
+
+// maxImageSizeWrapper checks the layers of manifest once its children are
+// known, so that none of them is dispatched if the manifest is too large.
+func maxImageSizeWrapper(limit int64) func(images.Handler) images.Handler {
+	return func(handler images.Handler) images.Handler {
+		return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+			children, err := handler.Handle(ctx, desc)
+			if err != nil || !images.IsManifestType(desc.MediaType) {
+				return children, err
+			}
+			var size int64
+			for _, child := range children {
+				if images.IsLayerType(child.MediaType) {
+					size += child.Size
+				}
+			}
+			if size > limit {
+				return nil, errors.Wrapf(ErrImageTooLarge, "layers of manifest %s are %d bytes, exceeding the limit of %d bytes", desc.Digest, size, limit)
+			}
+			return children, nil
+		})
+	}
+}
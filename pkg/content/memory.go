@@ -0,0 +1,61 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// NewMemoryProvider creates a local provider keeping the blobs in memory,
+// it's useful for tests and short-lived conversions which don't need to
+// reuse the pulled images after the process exits. The image records are
+// kept by a metadata database in a temporary directory, which is removed
+// with the content on Close.
+func NewMemoryProvider(hosts remote.HostFunc, platformMC platforms.MatchComparer, opts ...LocalProviderOpt) (*LocalProvider, error) {
+	options := LocalProviderOpts{
+		dbPerm:    defaultDBPerm,
+		logger:    logrus.NewEntry(logrus.StandardLogger()),
+		namespace: DefaultNamespace,
+	}
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return nil, errors.Wrap(err, "apply local provider option")
+		}
+	}
+
+	tempDir, err := os.MkdirTemp("", "memory-provider-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create memory provider database directory")
+	}
+	bdb, err := bolt.Open(filepath.Join(tempDir, "meta.db"), options.dbPerm, nil)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, errors.Wrap(err, "create memory provider database")
+	}
+	store := newMemoryStore()
+	db := metadata.NewDB(bdb, store, nil)
+	pvd := newLocalProvider(store, db, hosts, platformMC, options)
+	pvd.bdb = bdb
+	pvd.tempDir = tempDir
+	return pvd, nil
+}
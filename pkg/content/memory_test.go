@@ -0,0 +1,80 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/testsuite"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testsuite.ContentSuite(t, "memory", func(ctx context.Context, root string) (context.Context, content.Store, func() error, error) {
+		return ctx, newMemoryStore(), func() error { return nil }, nil
+	})
+}
+
+func TestMemoryProvider(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"), []byte("foo-layer-2"))
+	reg.addImage("library/bar", "latest", []byte("bar-layer-1"))
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, err := NewMemoryProvider(hosts, platforms.All)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := context.Background()
+
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/foo:latest")))
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/bar:latest")))
+	desc, err := pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.NoError(t, err)
+	_, err = content.ReadBlob(ctx, pvd.ContentStore(), *desc)
+	require.NoError(t, err)
+
+	require.NoError(t, pvd.Push(ctx, *desc, reg.ref("library/foo:pushed")))
+	require.Equal(t, 1, reg.count("PUT", "/v2/library/foo/manifests/pushed"))
+
+	countBlobs := func() int {
+		blobs := 0
+		require.NoError(t, pvd.ContentStore().Walk(ctx, func(info content.Info) error {
+			blobs++
+			return nil
+		}))
+		return blobs
+	}
+	require.Equal(t, 7, countBlobs())
+	require.NoError(t, pvd.DeleteImage(ctx, reg.ref("library/foo:latest")))
+	require.Equal(t, 3, countBlobs())
+	require.ErrorIs(t, pvd.DeleteImage(ctx, reg.ref("library/foo:latest")), errdefs.ErrNotFound)
+
+	_, err = pvd.Image(ctx, reg.ref("library/foo:latest"))
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	_, err = pvd.Image(ctx, reg.ref("library/bar:latest"))
+	require.NoError(t, err)
+
+	// The metadata database is removed on Close.
+	require.DirExists(t, pvd.tempDir)
+	require.NoError(t, pvd.Close())
+	require.NoDirExists(t, pvd.tempDir)
+}
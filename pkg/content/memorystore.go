@@ -0,0 +1,385 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/filters"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+var _ content.Store = &memoryStore{}
+
+type memoryBlob struct {
+	info content.Info
+	data []byte
+}
+
+type memoryIngest struct {
+	status content.Status
+	data   []byte
+	// locked is set if there is an open writer of the ingest.
+	locked bool
+}
+
+// memoryStore is a content store keeping the blobs and ingests in memory,
+// the content is lost once the store is released.
+type memoryStore struct {
+	mutex   sync.Mutex
+	blobs   map[digest.Digest]*memoryBlob
+	ingests map[string]*memoryIngest
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		blobs:   make(map[digest.Digest]*memoryBlob),
+		ingests: make(map[string]*memoryIngest),
+	}
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
+
+func (s *memoryStore) info(blob *memoryBlob) content.Info {
+	info := blob.info
+	info.Labels = copyLabels(blob.info.Labels)
+	return info
+}
+
+func (s *memoryStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	blob, ok := s.blobs[dgst]
+	if !ok {
+		return content.Info{}, errors.Wrapf(errdefs.ErrNotFound, "content %s", dgst)
+	}
+	return s.info(blob), nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, info content.Info, fieldpaths ...string) (content.Info, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	blob, ok := s.blobs[info.Digest]
+	if !ok {
+		return content.Info{}, errors.Wrapf(errdefs.ErrNotFound, "content %s", info.Digest)
+	}
+
+	labels := copyLabels(blob.info.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if len(fieldpaths) == 0 {
+		labels = copyLabels(info.Labels)
+	}
+	for _, path := range fieldpaths {
+		if strings.HasPrefix(path, "labels.") {
+			key := strings.TrimPrefix(path, "labels.")
+			if value, ok := info.Labels[key]; ok {
+				labels[key] = value
+			} else {
+				delete(labels, key)
+			}
+			continue
+		}
+		if path != "labels" {
+			return content.Info{}, errors.Wrapf(errdefs.ErrInvalidArgument, "cannot update %q field on content info %s", path, info.Digest)
+		}
+		labels = copyLabels(info.Labels)
+	}
+
+	blob.info.Labels = labels
+	blob.info.UpdatedAt = time.Now()
+
+	return s.info(blob), nil
+}
+
+func (s *memoryStore) Walk(ctx context.Context, fn content.WalkFunc, fs ...string) error {
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	infos := make([]content.Info, 0, len(s.blobs))
+	for _, blob := range s.blobs {
+		info := s.info(blob)
+		if filter.Match(content.AdaptInfo(info)) {
+			infos = append(infos, info)
+		}
+	}
+	s.mutex.Unlock()
+
+	// The walk function is called without lock, as it may access the store.
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.blobs[dgst]; !ok {
+		return errors.Wrapf(errdefs.ErrNotFound, "content %s", dgst)
+	}
+	delete(s.blobs, dgst)
+
+	return nil
+}
+
+type memoryReaderAt struct {
+	*bytes.Reader
+}
+
+func (r *memoryReaderAt) Close() error {
+	return nil
+}
+
+func (s *memoryStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	blob, ok := s.blobs[desc.Digest]
+	if !ok {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "content %s", desc.Digest)
+	}
+	// The committed data is never modified, so it's safe to share.
+	return &memoryReaderAt{bytes.NewReader(blob.data)}, nil
+}
+
+func (s *memoryStore) Status(ctx context.Context, ref string) (content.Status, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ingest, ok := s.ingests[ref]
+	if !ok {
+		return content.Status{}, errors.Wrapf(errdefs.ErrNotFound, "ingest %s", ref)
+	}
+	return ingest.status, nil
+}
+
+func adaptStatus(status content.Status) filters.Adaptor {
+	return filters.AdapterFunc(func(fieldpath []string) (string, bool) {
+		if len(fieldpath) == 0 {
+			return "", false
+		}
+		switch fieldpath[0] {
+		case "ref":
+			return status.Ref, true
+		}
+		return "", false
+	})
+}
+
+func (s *memoryStore) ListStatuses(ctx context.Context, fs ...string) ([]content.Status, error) {
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var active []content.Status
+	for _, ingest := range s.ingests {
+		if filter.Match(adaptStatus(ingest.status)) {
+			active = append(active, ingest.status)
+		}
+	}
+
+	return active, nil
+}
+
+func (s *memoryStore) Abort(ctx context.Context, ref string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.ingests[ref]; !ok {
+		return errors.Wrapf(errdefs.ErrNotFound, "ingest %s", ref)
+	}
+	delete(s.ingests, ref)
+
+	return nil
+}
+
+// Writer opens the ingest of reference, the ingest is resumed if it's
+// left by a closed writer, and it's unavailable until the writer is closed.
+func (s *memoryStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return nil, err
+		}
+	}
+	if wOpts.Ref == "" {
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, "ref must not be empty")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if wOpts.Desc.Digest != "" {
+		if _, ok := s.blobs[wOpts.Desc.Digest]; ok {
+			return nil, errors.Wrapf(errdefs.ErrAlreadyExists, "content %s", wOpts.Desc.Digest)
+		}
+	}
+
+	ingest, ok := s.ingests[wOpts.Ref]
+	if !ok {
+		now := time.Now()
+		ingest = &memoryIngest{
+			status: content.Status{
+				Ref:       wOpts.Ref,
+				StartedAt: now,
+				UpdatedAt: now,
+			},
+		}
+		s.ingests[wOpts.Ref] = ingest
+	} else if ingest.locked {
+		return nil, errors.Wrapf(errdefs.ErrUnavailable, "ref %s is locked", wOpts.Ref)
+	}
+	if wOpts.Desc.Size > 0 {
+		ingest.status.Total = wOpts.Desc.Size
+	}
+	if wOpts.Desc.Digest != "" {
+		ingest.status.Expected = wOpts.Desc.Digest
+	}
+	ingest.locked = true
+
+	return &memoryWriter{store: s, ingest: ingest}, nil
+}
+
+type memoryWriter struct {
+	store  *memoryStore
+	ingest *memoryIngest
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	w.ingest.data = append(w.ingest.data, p...)
+	w.ingest.status.Offset = int64(len(w.ingest.data))
+	w.ingest.status.UpdatedAt = time.Now()
+
+	return len(p), nil
+}
+
+func (w *memoryWriter) Digest() digest.Digest {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	return digest.FromBytes(w.ingest.data)
+}
+
+func (w *memoryWriter) Status() (content.Status, error) {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	return w.ingest.status, nil
+}
+
+func (w *memoryWriter) Truncate(size int64) error {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	if size < 0 || size > int64(len(w.ingest.data)) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "truncate size %d out of range", size)
+	}
+	w.ingest.data = w.ingest.data[:size]
+	w.ingest.status.Offset = size
+	w.ingest.status.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// Commit moves the ingest into blobs, the ingest is kept for resuming if
+// the size or digest doesn't match, otherwise it's removed.
+func (w *memoryWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	var base content.Info
+	for _, opt := range opts {
+		if err := opt(&base); err != nil {
+			return err
+		}
+	}
+
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	data := w.ingest.data
+	if size > 0 && size != int64(len(data)) {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "unexpected commit size %d, expected %d", len(data), size)
+	}
+	dgst := digest.FromBytes(data)
+	if expected != "" && expected != dgst {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "unexpected commit digest %s, expected %s", dgst, expected)
+	}
+
+	if w.store.ingests[w.ingest.status.Ref] == w.ingest {
+		delete(w.store.ingests, w.ingest.status.Ref)
+	}
+	if _, ok := w.store.blobs[dgst]; ok {
+		return errors.Wrapf(errdefs.ErrAlreadyExists, "content %s", dgst)
+	}
+
+	now := time.Now()
+	w.store.blobs[dgst] = &memoryBlob{
+		info: content.Info{
+			Digest:    dgst,
+			Size:      int64(len(data)),
+			CreatedAt: now,
+			UpdatedAt: now,
+			Labels:    copyLabels(base.Labels),
+		},
+		// The writer may be truncated and written again after commit.
+		data: append([]byte(nil), data...),
+	}
+
+	return nil
+}
+
+// Close releases the ingest for another writer, the written data is
+// kept until the ingest is committed or aborted.
+func (w *memoryWriter) Close() error {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	w.ingest.locked = false
+
+	return nil
+}
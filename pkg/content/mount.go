@@ -0,0 +1,85 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// mountResolver appends the mount candidates to the distribution source
+// annotation of pushed blobs, the docker pusher sends the mount request
+// with the candidate sharing the longest path prefix with the target
+// repository before uploading, and skips the upload if it's mounted.
+type mountResolver struct {
+	remotes.Resolver
+	repos []string
+}
+
+func newMountResolver(resolver remotes.Resolver, repos []string) remotes.Resolver {
+	if len(repos) == 0 {
+		return resolver
+	}
+	return &mountResolver{Resolver: resolver, repos: repos}
+}
+
+func (resolver *mountResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	pusher, err := resolver.Resolver.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse reference %s", ref)
+	}
+	// The annotation key uses the host without port in the same way as
+	// the docker pusher.
+	u, err := url.Parse("dummy://" + spec.Locator)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse locator %s", spec.Locator)
+	}
+	key := labels.LabelDistributionSource + "." + u.Hostname()
+
+	return remotes.PusherFunc(func(ctx context.Context, desc ocispec.Descriptor) (content.Writer, error) {
+		if images.IsManifestType(desc.MediaType) || images.IsIndexType(desc.MediaType) {
+			return pusher.Push(ctx, desc)
+		}
+
+		annotations := make(map[string]string, len(desc.Annotations)+1)
+		for k, v := range desc.Annotations {
+			annotations[k] = v
+		}
+		repos := resolver.repos
+		if sources := annotations[key]; sources != "" {
+			// The repositories of option are preferred for the same
+			// common prefix, as the later candidate wins the tie.
+			repos = append(strings.Split(sources, ","), repos...)
+		}
+		annotations[key] = strings.Join(repos, ",")
+		desc.Annotations = annotations
+
+		return pusher.Push(ctx, desc)
+	}), nil
+}
@@ -0,0 +1,98 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DefaultNamespace is the containerd namespace of the blobs and images
+// in metadata database if it isn't specified by WithNamespace.
+const DefaultNamespace = "acceleration-service"
+
+// namespacedStore runs the operations of content store in the namespace,
+// the writers of metadata database keep the namespace on creation.
+type namespacedStore struct {
+	content.Store
+	namespace string
+}
+
+func (s *namespacedStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	return s.Store.Info(namespaces.WithNamespace(ctx, s.namespace), dgst)
+}
+
+func (s *namespacedStore) Update(ctx context.Context, info content.Info, fieldpaths ...string) (content.Info, error) {
+	return s.Store.Update(namespaces.WithNamespace(ctx, s.namespace), info, fieldpaths...)
+}
+
+func (s *namespacedStore) Walk(ctx context.Context, fn content.WalkFunc, filters ...string) error {
+	return s.Store.Walk(namespaces.WithNamespace(ctx, s.namespace), fn, filters...)
+}
+
+func (s *namespacedStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	return s.Store.Delete(namespaces.WithNamespace(ctx, s.namespace), dgst)
+}
+
+func (s *namespacedStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	return s.Store.ReaderAt(namespaces.WithNamespace(ctx, s.namespace), desc)
+}
+
+func (s *namespacedStore) Status(ctx context.Context, ref string) (content.Status, error) {
+	return s.Store.Status(namespaces.WithNamespace(ctx, s.namespace), ref)
+}
+
+func (s *namespacedStore) ListStatuses(ctx context.Context, filters ...string) ([]content.Status, error) {
+	return s.Store.ListStatuses(namespaces.WithNamespace(ctx, s.namespace), filters...)
+}
+
+func (s *namespacedStore) Abort(ctx context.Context, ref string) error {
+	return s.Store.Abort(namespaces.WithNamespace(ctx, s.namespace), ref)
+}
+
+func (s *namespacedStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	return s.Store.Writer(namespaces.WithNamespace(ctx, s.namespace), opts...)
+}
+
+// namespacedImageStore runs the operations of image store in the namespace.
+type namespacedImageStore struct {
+	images.Store
+	namespace string
+}
+
+func (s *namespacedImageStore) Get(ctx context.Context, name string) (images.Image, error) {
+	return s.Store.Get(namespaces.WithNamespace(ctx, s.namespace), name)
+}
+
+func (s *namespacedImageStore) List(ctx context.Context, filters ...string) ([]images.Image, error) {
+	return s.Store.List(namespaces.WithNamespace(ctx, s.namespace), filters...)
+}
+
+func (s *namespacedImageStore) Create(ctx context.Context, image images.Image) (images.Image, error) {
+	return s.Store.Create(namespaces.WithNamespace(ctx, s.namespace), image)
+}
+
+func (s *namespacedImageStore) Update(ctx context.Context, image images.Image, fieldpaths ...string) (images.Image, error) {
+	return s.Store.Update(namespaces.WithNamespace(ctx, s.namespace), image, fieldpaths...)
+}
+
+func (s *namespacedImageStore) Delete(ctx context.Context, name string, opts ...images.DeleteOpt) error {
+	return s.Store.Delete(namespaces.WithNamespace(ctx, s.namespace), name, opts...)
+}
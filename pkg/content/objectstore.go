@@ -0,0 +1,406 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/filters"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ObjectInfo describes an object in object storage.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// ObjectStorage is the minimal interface of an object storage like S3
+// used by the object store, the errors of missing objects should match
+// errdefs.ErrNotFound.
+type ObjectStorage interface {
+	// Stat returns the information of object.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Get streams the object from the offset.
+	Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+	// Put uploads the object of size, the data is seekable for retries.
+	Put(ctx context.Context, key string, data io.ReadSeeker, size int64) error
+	Delete(ctx context.Context, key string) error
+	// List calls fn for each object with the key prefix.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+var _ content.Store = &objectStore{}
+
+// objectStore is a content store keeping the blobs in object storage by
+// the keys named with digests, so the blobs can be shared by the providers
+// on different nodes. The ingests are buffered in memory until they are
+// committed, and the labels are kept by metadata database rather than the
+// object store.
+type objectStore struct {
+	storage ObjectStorage
+	prefix  string
+	mutex   sync.Mutex
+	ingests map[string]*memoryIngest
+}
+
+// NewObjectStore creates a content store keeping the blobs in storage with
+// the key prefix, it can be injected into provider by NewProviderWithStore
+// with a metadata database created on it.
+func NewObjectStore(storage ObjectStorage, prefix string) content.Store {
+	return &objectStore{
+		storage: storage,
+		prefix:  prefix,
+		ingests: make(map[string]*memoryIngest),
+	}
+}
+
+func (s *objectStore) blobKey(dgst digest.Digest) string {
+	return path.Join(s.prefix, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func (s *objectStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	info, err := s.storage.Stat(ctx, s.blobKey(dgst))
+	if err != nil {
+		return content.Info{}, errors.Wrapf(err, "content %s", dgst)
+	}
+	return content.Info{
+		Digest:    dgst,
+		Size:      info.Size,
+		CreatedAt: info.ModTime,
+		UpdatedAt: info.ModTime,
+	}, nil
+}
+
+func (s *objectStore) Update(ctx context.Context, info content.Info, fieldpaths ...string) (content.Info, error) {
+	return content.Info{}, errors.Wrap(errdefs.ErrNotImplemented, "labels are not supported by object store")
+}
+
+func (s *objectStore) Walk(ctx context.Context, fn content.WalkFunc, fs ...string) error {
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return err
+	}
+
+	prefix := path.Join(s.prefix, "blobs") + "/"
+	return s.storage.List(ctx, prefix, func(object ObjectInfo) error {
+		// The key is like `<prefix>/blobs/sha256/<hex>`.
+		parts := strings.Split(strings.TrimPrefix(object.Key, prefix), "/")
+		if len(parts) != 2 {
+			return nil
+		}
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(parts[0]), parts[1])
+		if dgst.Validate() != nil {
+			return nil
+		}
+		info := content.Info{
+			Digest:    dgst,
+			Size:      object.Size,
+			CreatedAt: object.ModTime,
+			UpdatedAt: object.ModTime,
+		}
+		if !filter.Match(content.AdaptInfo(info)) {
+			return nil
+		}
+		return fn(info)
+	})
+}
+
+func (s *objectStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	key := s.blobKey(dgst)
+	if _, err := s.storage.Stat(ctx, key); err != nil {
+		return errors.Wrapf(err, "content %s", dgst)
+	}
+	if err := s.storage.Delete(ctx, key); err != nil {
+		return errors.Wrapf(err, "delete content %s", dgst)
+	}
+	return nil
+}
+
+func (s *objectStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	key := s.blobKey(desc.Digest)
+	info, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "content %s", desc.Digest)
+	}
+	return &objectReaderAt{
+		ctx:     ctx,
+		storage: s.storage,
+		key:     key,
+		size:    info.Size,
+	}, nil
+}
+
+func (s *objectStore) Status(ctx context.Context, ref string) (content.Status, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ingest, ok := s.ingests[ref]
+	if !ok {
+		return content.Status{}, errors.Wrapf(errdefs.ErrNotFound, "ingest %s", ref)
+	}
+	return ingest.status, nil
+}
+
+func (s *objectStore) ListStatuses(ctx context.Context, fs ...string) ([]content.Status, error) {
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var active []content.Status
+	for _, ingest := range s.ingests {
+		if filter.Match(adaptStatus(ingest.status)) {
+			active = append(active, ingest.status)
+		}
+	}
+
+	return active, nil
+}
+
+func (s *objectStore) Abort(ctx context.Context, ref string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.ingests[ref]; !ok {
+		return errors.Wrapf(errdefs.ErrNotFound, "ingest %s", ref)
+	}
+	delete(s.ingests, ref)
+
+	return nil
+}
+
+// Writer opens the ingest of reference buffered in memory, the ingest is
+// resumed if it's left by a closed writer.
+func (s *objectStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return nil, err
+		}
+	}
+	if wOpts.Ref == "" {
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, "ref must not be empty")
+	}
+
+	if wOpts.Desc.Digest != "" {
+		if _, err := s.storage.Stat(ctx, s.blobKey(wOpts.Desc.Digest)); err == nil {
+			return nil, errors.Wrapf(errdefs.ErrAlreadyExists, "content %s", wOpts.Desc.Digest)
+		} else if !errdefs.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "content %s", wOpts.Desc.Digest)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ingest, ok := s.ingests[wOpts.Ref]
+	if !ok {
+		now := time.Now()
+		ingest = &memoryIngest{
+			status: content.Status{
+				Ref:       wOpts.Ref,
+				StartedAt: now,
+				UpdatedAt: now,
+			},
+		}
+		s.ingests[wOpts.Ref] = ingest
+	} else if ingest.locked {
+		return nil, errors.Wrapf(errdefs.ErrUnavailable, "ref %s is locked", wOpts.Ref)
+	}
+	if wOpts.Desc.Size > 0 {
+		ingest.status.Total = wOpts.Desc.Size
+	}
+	if wOpts.Desc.Digest != "" {
+		ingest.status.Expected = wOpts.Desc.Digest
+	}
+	ingest.locked = true
+
+	return &objectWriter{store: s, ingest: ingest}, nil
+}
+
+type objectWriter struct {
+	store  *objectStore
+	ingest *memoryIngest
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	w.ingest.data = append(w.ingest.data, p...)
+	w.ingest.status.Offset = int64(len(w.ingest.data))
+	w.ingest.status.UpdatedAt = time.Now()
+
+	return len(p), nil
+}
+
+func (w *objectWriter) Digest() digest.Digest {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	return digest.FromBytes(w.ingest.data)
+}
+
+func (w *objectWriter) Status() (content.Status, error) {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	return w.ingest.status, nil
+}
+
+func (w *objectWriter) Truncate(size int64) error {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	if size < 0 || size > int64(len(w.ingest.data)) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "truncate size %d out of range", size)
+	}
+	w.ingest.data = w.ingest.data[:size]
+	w.ingest.status.Offset = size
+	w.ingest.status.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// Commit uploads the ingest to the key named by its digest, the ingest is
+// kept for resuming if the size or digest doesn't match or the upload
+// fails, otherwise it's removed.
+func (w *objectWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	w.store.mutex.Lock()
+	data := w.ingest.data
+	w.store.mutex.Unlock()
+
+	if size > 0 && size != int64(len(data)) {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "unexpected commit size %d, expected %d", len(data), size)
+	}
+	dgst := digest.FromBytes(data)
+	if expected != "" && expected != dgst {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "unexpected commit digest %s, expected %s", dgst, expected)
+	}
+
+	key := w.store.blobKey(dgst)
+	if _, err := w.store.storage.Stat(ctx, key); err == nil {
+		w.removeIngest()
+		return errors.Wrapf(errdefs.ErrAlreadyExists, "content %s", dgst)
+	} else if !errdefs.IsNotFound(err) {
+		return errors.Wrapf(err, "content %s", dgst)
+	}
+	if err := w.store.storage.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return errors.Wrapf(err, "upload content %s", dgst)
+	}
+	w.removeIngest()
+
+	return nil
+}
+
+func (w *objectWriter) removeIngest() {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	if w.store.ingests[w.ingest.status.Ref] == w.ingest {
+		delete(w.store.ingests, w.ingest.status.Ref)
+	}
+}
+
+// Close releases the ingest for another writer, the written data is
+// kept until the ingest is committed or aborted.
+func (w *objectWriter) Close() error {
+	w.store.mutex.Lock()
+	defer w.store.mutex.Unlock()
+
+	w.ingest.locked = false
+
+	return nil
+}
+
+// objectReaderAt streams the object, the stream is reused by sequential
+// reads and reopened from the offset of random reads.
+type objectReaderAt struct {
+	ctx     context.Context
+	storage ObjectStorage
+	key     string
+	size    int64
+
+	mutex  sync.Mutex
+	stream io.ReadCloser
+	offset int64
+}
+
+func (r *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stream == nil || r.offset != off {
+		if r.stream != nil {
+			r.stream.Close()
+		}
+		stream, err := r.storage.Get(r.ctx, r.key, off)
+		if err != nil {
+			r.stream = nil
+			return 0, errors.Wrapf(err, "get object %s", r.key)
+		}
+		r.stream, r.offset = stream, off
+	}
+
+	if remaining := r.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := io.ReadFull(r.stream, p)
+	r.offset += int64(n)
+	if err != nil {
+		r.stream.Close()
+		r.stream = nil
+		return n, err
+	}
+	if r.offset == r.size {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *objectReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *objectReaderAt) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stream == nil {
+		return nil
+	}
+	err := r.stream.Close()
+	r.stream = nil
+	return err
+}
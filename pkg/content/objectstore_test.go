@@ -0,0 +1,210 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+// fakeS3 is an in-memory S3 server of a single bucket with path style.
+type fakeS3 struct {
+	mutex   sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+type listBucketResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified string
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	prefix := "/" + s.bucket
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	if key == "" && r.Method == http.MethodGet {
+		var result listBucketResult
+		keys := []string{}
+		for key := range s.objects {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			result.Contents = append(result.Contents, struct {
+				Key          string
+				Size         int64
+				LastModified string
+			}{key, int64(len(s.objects[key])), time.Now().UTC().Format(time.RFC3339)})
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+		return
+	}
+
+	data, ok := s.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = body
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method == http.MethodGet {
+			_, _ = io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+		}
+		return
+	}
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodGet {
+		if rng := r.Header.Get("Range"); rng != "" {
+			offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || offset > len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			data = data[offset:]
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+}
+
+func newFakeS3Storage(t *testing.T) *S3Storage {
+	fake := &fakeS3{bucket: "blobs", objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("access-key", "secret-key", ""),
+		EndpointResolver: s3.EndpointResolverFromURL(server.URL),
+		UsePathStyle:     true,
+		RetryMaxAttempts: 1,
+	})
+	return NewS3Storage(client, fake.bucket)
+}
+
+func newObjectStoreProvider(t *testing.T, store content.Store) *LocalProvider {
+	bdb, err := bolt.Open(filepath.Join(t.TempDir(), "meta.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { bdb.Close() })
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd := NewProviderWithStore(store, metadata.NewDB(bdb, store, nil), hosts, platforms.All).(*LocalProvider)
+	pvd.UsePlainHTTP()
+	return pvd
+}
+
+func TestObjectStore(t *testing.T) {
+	reg := newTestRegistry(t)
+	layer := []byte("foo-layer")
+	reg.addImage("library/foo", "latest", layer)
+	ref := reg.ref("library/foo:latest")
+
+	// The providers on different nodes share the same bucket.
+	storage := newFakeS3Storage(t)
+	store := NewObjectStore(storage, "acceleration-service")
+	ctx := testContext()
+	src := newObjectStoreProvider(t, store)
+	require.NoError(t, src.Pull(ctx, ref))
+	desc, err := src.Image(ctx, ref)
+	require.NoError(t, err)
+
+	dgst := digest.FromBytes(layer)
+	info, err := store.Info(ctx, dgst)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(layer)), info.Size)
+	var walked []digest.Digest
+	require.NoError(t, store.Walk(ctx, func(info content.Info) error {
+		walked = append(walked, info.Digest)
+		return nil
+	}))
+	require.Contains(t, walked, dgst)
+
+	// The blobs are read from the bucket rather than the registry.
+	dst := newObjectStoreProvider(t, NewObjectStore(storage, "acceleration-service"))
+	fetches := reg.count(http.MethodGet, "/blobs/"+dgst.String())
+	require.NoError(t, dst.Pull(ctx, ref))
+	require.Equal(t, fetches, reg.count(http.MethodGet, "/blobs/"+dgst.String()))
+	data, err := content.ReadBlob(ctx, dst.ContentStore(), ocispec.Descriptor{Digest: dgst, Size: int64(len(layer))})
+	require.NoError(t, err)
+	require.Equal(t, layer, data)
+
+	// The blob is streamed from the offset.
+	ra, err := store.ReaderAt(ctx, ocispec.Descriptor{Digest: dgst})
+	require.NoError(t, err)
+	defer ra.Close()
+	buf := make([]byte, 3)
+	n, err := ra.ReadAt(buf, 4)
+	require.NoError(t, err)
+	require.Equal(t, "lay", string(buf[:n]))
+	n, err = ra.ReadAt(buf, 7)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, "er", string(buf[:n]))
+
+	require.NoError(t, dst.Push(ctx, *desc, reg.ref("library/bar:latest")))
+	require.NoError(t, store.Delete(ctx, dgst))
+	_, err = store.Info(ctx, dgst)
+	require.True(t, errdefs.IsNotFound(err))
+}
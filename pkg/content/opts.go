@@ -0,0 +1,198 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"crypto"
+	"os"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/identifiers"
+	"github.com/containerd/containerd/platforms"
+	encconfig "github.com/containers/ocicrypt/config"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultDirPerm os.FileMode = 0755
+	defaultDBPerm  os.FileMode = 0644
+)
+
+type LocalProviderOpts struct {
+	dirPerm  os.FileMode
+	dbPerm   os.FileMode
+	logger   *logrus.Entry
+	readOnly bool
+	// labelStore creates the label store of blobs on the opened
+	// metadata database.
+	labelStore    func(db *bolt.DB) local.LabelStore
+	decryptConfig *encconfig.DecryptConfig
+	namespace     string
+	reproducible  bool
+	// verificationKeys verify the cosign signatures of images on Pull.
+	verificationKeys []crypto.PublicKey
+	// dedupRoot is the directory sharing blobs across providers.
+	dedupRoot   string
+	digestIndex bool
+	// ingestDir is the directory of blobs being written.
+	ingestDir         string
+	referenceRewriter ReferenceRewriter
+	// memoryCacheSize limits the blobs cached in memory, disabled if <= 0.
+	memoryCacheSize int64
+}
+
+type LocalProviderOpt func(opts *LocalProviderOpts) error
+
+// WithDirPerm sets the permission of content directory, 0755 by default.
+func WithDirPerm(perm os.FileMode) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.dirPerm = perm
+		return nil
+	}
+}
+
+// WithDBPerm sets the permission of metadata database file, 0644 by default.
+func WithDBPerm(perm os.FileMode) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.dbPerm = perm
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to log the steps of Pull and Push, the
+// standard logger of logrus by default. The logger carried by the context
+// of request (see `log.WithLogger` of containerd) takes precedence, so
+// callers can attach request-scoped fields.
+func WithLogger(logger *logrus.Entry) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.logger = logger
+		return nil
+	}
+}
+
+// WithLabelStore sets the store of blob labels, the labels are kept in
+// memory by default and lost once the provider is restarted.
+func WithLabelStore(store local.LabelStore) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.labelStore = func(*bolt.DB) local.LabelStore {
+			return store
+		}
+		return nil
+	}
+}
+
+// WithPersistentLabels persists the labels of blobs in the metadata
+// database of work directory, see NewBoltLabelStore.
+func WithPersistentLabels() LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.labelStore = NewBoltLabelStore
+		return nil
+	}
+}
+
+// WithReadOnly opens the existing work directory in read-only mode, which
+// can be mounted read-only, the methods mutating the content store or
+// registry return ErrReadOnly.
+func WithReadOnly() LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.readOnly = true
+		return nil
+	}
+}
+
+// WithNamespace sets the containerd namespace of the blobs and images in
+// metadata database, DefaultNamespace by default. All the operations of
+// provider and its content store are isolated in the namespace regardless
+// of the namespace in context.
+func WithNamespace(namespace string) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		if err := identifiers.Validate(namespace); err != nil {
+			return errors.Wrapf(err, "invalid namespace %q", namespace)
+		}
+		opts.namespace = namespace
+		return nil
+	}
+}
+
+type PullOpts struct {
+	progress     ProgressFunc
+	platformMC   platforms.MatchComparer
+	force        bool
+	knownDigests map[digest.Digest]struct{}
+	// expected is the digest the reference must be resolved to.
+	expected digest.Digest
+}
+
+type PullOpt func(opts *PullOpts) error
+
+type PushOpts struct {
+	progress       ProgressFunc
+	mountFrom      []string
+	manifestFormat ManifestFormat
+	skipExisting   bool
+	overwrite      bool
+	result         *PushResult
+}
+
+type PushOpt func(opts *PushOpts) error
+
+// WithPullProgress reports the downloaded bytes of each blob by fn.
+func WithPullProgress(fn ProgressFunc) PullOpt {
+	return func(opts *PullOpts) error {
+		opts.progress = fn
+		return nil
+	}
+}
+
+// WithPullPlatform pulls the platforms matched by platformMC rather than
+// the default platforms of provider, `platforms.All` pulls all the
+// manifests of index.
+func WithPullPlatform(platformMC platforms.MatchComparer) PullOpt {
+	return func(opts *PullOpts) error {
+		opts.platformMC = platformMC
+		return nil
+	}
+}
+
+// WithForce fetches the image from registry even if the image is present
+// in content store.
+func WithForce() PullOpt {
+	return func(opts *PullOpts) error {
+		opts.force = true
+		return nil
+	}
+}
+
+// WithPushProgress reports the uploaded bytes of each blob by fn.
+func WithPushProgress(fn ProgressFunc) PushOpt {
+	return func(opts *PushOpts) error {
+		opts.progress = fn
+		return nil
+	}
+}
+
+// WithMountFrom mounts the blobs from the repositories on the same
+// registry rather than uploading them if they don't exist in the target
+// repository, the repositories are specified as the path without host,
+// e.g. `library/busybox`.
+func WithMountFrom(repos ...string) PushOpt {
+	return func(opts *PushOpts) error {
+		opts.mountFrom = append(opts.mountFrom, repos...)
+		return nil
+	}
+}
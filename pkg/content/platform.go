@@ -0,0 +1,91 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/platformutil"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ErrPlatformNotFound is returned by Pull if none of the manifests in
+// image index matches the requested platforms.
+var ErrPlatformNotFound = errors.New("platform not found")
+
+// ErrUnsupportedManifest is returned by Pull if the registry serves the
+// deprecated Docker schema1 manifest, which isn't supported by conversion.
+var ErrUnsupportedManifest = errors.New("unsupported manifest")
+
+// platformFragment is the suffix of reference selecting the platform of
+// image to be pulled, like `repo:tag@platform=linux/arm64`.
+const platformFragment = "@platform="
+
+// parsePlatformFragment splits the platform fragment from ref, and returns
+// the matcher of the platform, which is nil if there is no fragment.
+func parsePlatformFragment(ref string) (string, platforms.MatchComparer, error) {
+	idx := strings.LastIndex(ref, platformFragment)
+	if idx < 0 {
+		return ref, nil, nil
+	}
+	specifier := ref[idx+len(platformFragment):]
+	if specifier == "" {
+		return "", nil, errors.Errorf("empty platform in reference %s", ref)
+	}
+	platform, err := platforms.Parse(specifier)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "invalid platform in reference %s", ref)
+	}
+	return ref[:idx], platformutil.NewStrictMatchComparer(platform), nil
+}
+
+// checkPlatform returns ErrPlatformNotFound listing the available platforms
+// if the target is an image index without manifest matched by platformMC.
+func checkPlatform(ctx context.Context, store content.Provider, target ocispec.Descriptor, platformMC platforms.MatchComparer) error {
+	if !images.IsIndexType(target.MediaType) {
+		return nil
+	}
+	data, err := content.ReadBlob(ctx, store, target)
+	if err != nil {
+		return errors.Wrap(err, "read image index")
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return errors.Wrap(err, "unmarshal image index")
+	}
+	if len(index.Manifests) == 0 {
+		return nil
+	}
+
+	available := make([]string, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		if desc.Platform == nil || platformMC.Match(*desc.Platform) {
+			return nil
+		}
+		platform := platforms.Format(*desc.Platform)
+		if desc.Platform.OSVersion != "" {
+			platform += " (" + desc.Platform.OSVersion + ")"
+		}
+		available = append(available, platform)
+	}
+
+	return errors.Wrapf(ErrPlatformNotFound, "available platforms: %s", strings.Join(available, ", "))
+}
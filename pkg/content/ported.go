@@ -38,15 +38,21 @@ import (
 	"golang.org/x/sync/singleflight"
 )
 
-var fetchSingleflight = &singleflight.Group{}
-
 // Ported from containerd project, copyright The containerd Authors.
 // github.com/containerd/containerd/blob/main/pull.go
-func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteContext, ref string, limit int) (images.Image, error) {
+//
+// The concurrent fetches of the same blob into store are shared by group,
+// which must not be shared by other stores.
+func fetch(ctx context.Context, store content.Store, group *singleflight.Group, rCtx *containerd.RemoteContext, ref string, limit int) (images.Image, error) {
 	name, desc, err := rCtx.Resolver.Resolve(ctx, ref)
 	if err != nil {
 		return images.Image{}, fmt.Errorf("failed to resolve reference %q: %w", ref, err)
 	}
+	log.G(ctx).WithFields(descFields(desc)).Debug("resolved reference")
+	// nolint:staticcheck
+	if desc.MediaType == images.MediaTypeDockerSchema1Manifest && !rCtx.ConvertSchema1 {
+		return images.Image{}, fmt.Errorf("%w: %s of %q", ErrUnsupportedManifest, desc.MediaType, ref)
+	}
 
 	fetcher, err := rCtx.Resolver.Fetcher(ctx, name)
 	if err != nil {
@@ -74,7 +80,7 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 		}
 	} else {
 		// Get all the children for a descriptor
-		childrenHandler := images.ChildrenHandler(store)
+		childrenHandler := artifactChildrenHandler(store)
 		// Set any children labels for that content
 		childrenHandler = images.SetChildrenMappedLabels(store, childrenHandler, rCtx.ChildLabelMap)
 		if rCtx.AllMetadata {
@@ -107,7 +113,7 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 		}
 
 		handlers := append(rCtx.BaseHandlers,
-			fetchHandler(store, fetcher),
+			fetchHandler(store, group, fetcher),
 			convertibleHandler,
 			childrenHandler,
 			appendDistSrcLabelHandler,
@@ -147,24 +153,40 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 
 // Ported from containerd project, copyright The containerd Authors.
 // https://github.com/containerd/containerd/blob/main/remotes/handlers.go
-func fetchHandler(ingester content.Ingester, fetcher remotes.Fetcher) images.HandlerFunc {
+func fetchHandler(ingester content.Ingester, group *singleflight.Group, fetcher remotes.Fetcher) images.HandlerFunc {
 	return func(ctx context.Context, desc ocispec.Descriptor) (subdescs []ocispec.Descriptor, err error) {
-		ctx = log.WithLogger(ctx, log.G(ctx).WithFields(log.Fields{
-			"digest":    desc.Digest,
-			"mediatype": desc.MediaType,
-			"size":      desc.Size,
-		}))
+		ctx = log.WithLogger(ctx, log.G(ctx).WithFields(descFields(desc)))
 
 		switch desc.MediaType {
 		case images.MediaTypeDockerSchema1Manifest:
-			return nil, fmt.Errorf("%v not supported", desc.MediaType)
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedManifest, desc.MediaType)
 		default:
-			_, err, _ := fetchSingleflight.Do(string(desc.Digest), func() (interface{}, error) {
+			if images.IsManifestType(desc.MediaType) || images.IsIndexType(desc.MediaType) {
+				log.G(ctx).Debug("fetching manifest")
+			} else if images.IsLayerType(desc.MediaType) {
+				log.G(ctx).Debug("fetching layer")
+			} else {
+				log.G(ctx).Debug("fetching blob")
+			}
+			_, err, _ := group.Do(string(desc.Digest), func() (interface{}, error) {
 				return nil, remotes.Fetch(ctx, ingester, fetcher, desc)
 			})
 			if errdefs.IsAlreadyExists(err) {
+				log.G(ctx).Debug("blob already exists")
 				return nil, nil
 			}
+			if err == nil {
+				log.G(ctx).Debug("committed blob")
+			}
+			// The fully written but corrupted blob can't be resumed,
+			// abort it so that it's fetched from scratch next time.
+			if errdefs.IsFailedPrecondition(err) {
+				if manager, ok := ingester.(content.IngestManager); ok {
+					if abortErr := manager.Abort(ctx, remotes.MakeRefKey(ctx, desc)); abortErr != nil && !errdefs.IsNotFound(abortErr) {
+						log.G(ctx).WithError(abortErr).Warn("failed to abort corrupted ingest")
+					}
+				}
+			}
 			return nil, err
 		}
 	}
@@ -0,0 +1,142 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ProgressFunc is called with the transferred and total bytes of a blob
+// each time a chunk of the blob is transferred. The blobs are transferred
+// in parallel, so the function must be safe for concurrent use.
+type ProgressFunc func(desc ocispec.Descriptor, transferred, total int64)
+
+// transferFunc is called with the size of each transferred chunk of
+// blob, and the transferred bytes of the blob so far.
+type transferFunc func(desc ocispec.Descriptor, n, transferred int64)
+
+// transferResolver wraps the fetcher and pusher of resolver to observe
+// the transfer of blobs.
+type transferResolver struct {
+	remotes.Resolver
+	transfer transferFunc
+}
+
+func newProgressResolver(resolver remotes.Resolver, progress ProgressFunc) remotes.Resolver {
+	if progress == nil {
+		return resolver
+	}
+	return newTransferResolver(resolver, func(desc ocispec.Descriptor, n, transferred int64) {
+		progress(desc, transferred, desc.Size)
+	})
+}
+
+func newTransferResolver(resolver remotes.Resolver, transfer transferFunc) remotes.Resolver {
+	return &transferResolver{
+		Resolver: resolver,
+		transfer: transfer,
+	}
+}
+
+func (resolver *transferResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := resolver.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		reader := &transferReader{
+			ReadCloser: rc,
+			desc:       desc,
+			transfer:   resolver.transfer,
+		}
+		if _, ok := rc.(io.Seeker); ok {
+			return &transferReadSeeker{reader}, nil
+		}
+		return reader, nil
+	}), nil
+}
+
+func (resolver *transferResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	pusher, err := resolver.Resolver.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.PusherFunc(func(ctx context.Context, desc ocispec.Descriptor) (content.Writer, error) {
+		cw, err := pusher.Push(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		return &transferWriter{
+			Writer:   cw,
+			desc:     desc,
+			transfer: resolver.transfer,
+		}, nil
+	}), nil
+}
+
+type transferReader struct {
+	io.ReadCloser
+	desc        ocispec.Descriptor
+	transferred int64
+	transfer    transferFunc
+}
+
+func (reader *transferReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	if n > 0 {
+		reader.transferred += int64(n)
+		reader.transfer(reader.desc, int64(n), reader.transferred)
+	}
+	return n, err
+}
+
+// transferReadSeeker keeps the seeker of fetched blob, which is used to
+// resume the download from the offset of partially written blob.
+type transferReadSeeker struct {
+	*transferReader
+}
+
+func (reader *transferReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := reader.ReadCloser.(io.Seeker).Seek(offset, whence)
+	if err == nil {
+		reader.transferred = n
+	}
+	return n, err
+}
+
+type transferWriter struct {
+	content.Writer
+	desc        ocispec.Descriptor
+	transferred int64
+	transfer    transferFunc
+}
+
+func (writer *transferWriter) Write(p []byte) (int, error) {
+	n, err := writer.Writer.Write(p)
+	if n > 0 {
+		writer.transferred += int64(n)
+		writer.transfer(writer.desc, int64(n), writer.transferred)
+	}
+	return n, err
+}
@@ -0,0 +1,142 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Prune deletes the blobs which aren't reachable from any image, such as
+// the blobs left by failed conversions and aborted pulls, and returns the
+// number and total size of deleted blobs. The blobs reachable from the
+// leases of in-flight operations are kept.
+func (pvd *LocalProvider) Prune(ctx context.Context) (int, int64, error) {
+	if pvd.isClosed() {
+		return 0, 0, ErrClosed
+	}
+	if pvd.readOnly {
+		return 0, 0, ErrReadOnly
+	}
+
+	pvd.gcMutex.Lock()
+	defer pvd.gcMutex.Unlock()
+
+	reachable, err := pvd.reachableBlobs(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	store := *pvd.store
+	var unreachable []content.Info
+	if err := store.Walk(ctx, func(info content.Info) error {
+		if _, ok := reachable[info.Digest]; !ok {
+			unreachable = append(unreachable, info)
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, errors.Wrap(err, "walk content store")
+	}
+
+	var (
+		removed int
+		freed   int64
+	)
+	for _, info := range unreachable {
+		if err := store.Delete(ctx, info.Digest); err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return removed, freed, errors.Wrapf(err, "delete blob %s", info.Digest)
+		}
+		removed++
+		freed += info.Size
+	}
+
+	// The deleted blobs are removed from disk by garbage collection of
+	// metadata database, unless they're still referenced by another namespace.
+	if removed > 0 {
+		if _, err := pvd.db.GarbageCollect(ctx); err != nil {
+			return removed, freed, errors.Wrap(err, "garbage collect")
+		}
+	}
+
+	return removed, freed, nil
+}
+
+// reachableBlobs collects the blobs reachable from the images and the
+// blobs of leases in the namespace of provider.
+func (pvd *LocalProvider) reachableBlobs(ctx context.Context) (map[digest.Digest]struct{}, error) {
+	pvd.mutex.Lock()
+	targets := make([]ocispec.Descriptor, 0, len(pvd.images))
+	for _, desc := range pvd.images {
+		targets = append(targets, *desc)
+	}
+	pvd.mutex.Unlock()
+
+	nsCtx := namespaces.WithNamespace(ctx, pvd.namespace)
+	leaseList, err := pvd.leaseManager.List(nsCtx)
+	if err != nil {
+		return nil, errors.Wrap(err, "list leases")
+	}
+	for _, lease := range leaseList {
+		resources, err := pvd.leaseManager.ListResources(nsCtx, lease)
+		if err != nil {
+			return nil, errors.Wrapf(err, "list resources of lease %s", lease.ID)
+		}
+		for _, resource := range resources {
+			if resource.Type != "content" {
+				continue
+			}
+			dgst, err := digest.Parse(resource.ID)
+			if err != nil {
+				continue
+			}
+			targets = append(targets, ocispec.Descriptor{Digest: dgst})
+		}
+	}
+
+	store := *pvd.store
+	reachable := map[digest.Digest]struct{}{}
+	childrenHandler := artifactChildrenHandler(store)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, ok := reachable[desc.Digest]; ok {
+			return nil, nil
+		}
+		// The blobs of other platforms may not be pulled.
+		if _, err := store.Info(ctx, desc.Digest); err != nil {
+			if errdefs.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		reachable[desc.Digest] = struct{}{}
+		// The leased blobs have no media type, the children handler
+		// returns nothing for them.
+		return childrenHandler(ctx, desc)
+	})
+	if err := images.Walk(ctx, handler, targets...); err != nil {
+		return nil, errors.Wrap(err, "walk reachable blobs")
+	}
+
+	return reachable, nil
+}
@@ -0,0 +1,99 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Referrers returns the descriptors of manifests referring to the image
+// ref in registry, such as the SBOMs and signatures attached to it, which
+// are filtered by the artifact type if it's not empty. The referrers are
+// looked up by the `<alg>-<hex>` tag if the registry doesn't support the
+// referrers API, an empty slice is returned if there are no referrers.
+func (pvd *LocalProvider) Referrers(ctx context.Context, ref string, artifactType string) ([]ocispec.Descriptor, error) {
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	credFunc, insecure, err := pvd.hosts(ref)
+	if err != nil {
+		return nil, err
+	}
+	opts := []remote.ResolverOpt{}
+	if pvd.anonymousFallback {
+		opts = append(opts, remote.WithAnonymousFallback())
+	}
+	resolver, err := pvd.newResolver(ref, opts...)
+	if err != nil {
+		return nil, err
+	}
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve reference %s", ref)
+	}
+
+	fetcher := remote.NewReferrersFetcher(insecure, pvd.usePlainHTTP, credFunc, pvd.remoteOpts(opts...)...)
+	referrers, err := fetcher.Referrers(ctx, ref, desc.Digest, artifactType)
+	if err == nil {
+		return referrers, nil
+	}
+	if !errdefs.IsNotImplemented(err) {
+		return nil, errors.Wrapf(err, "fetch referrers of %s", desc.Digest)
+	}
+
+	// Fall back to the tag schema, see also:
+	// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#referrers-tag-schema
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse reference %s", ref)
+	}
+	tagRef := spec.Locator + ":" + strings.Replace(desc.Digest.String(), ":", "-", 1)
+	name, indexDesc, err := resolver.Resolve(ctx, tagRef)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return []ocispec.Descriptor{}, nil
+		}
+		return nil, errors.Wrapf(err, "resolve referrers tag %s", tagRef)
+	}
+	indexFetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get fetcher for %s", name)
+	}
+	var index ocispec.Index
+	if err := fetchJSON(ctx, indexFetcher, indexDesc, maxManifestSize, &index); err != nil {
+		return nil, errors.Wrapf(err, "fetch referrers index %s", indexDesc.Digest)
+	}
+
+	referrers = []ocispec.Descriptor{}
+	for _, desc := range index.Manifests {
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			referrers = append(referrers, desc)
+		}
+	}
+
+	return referrers, nil
+}
@@ -0,0 +1,444 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+type testRequest struct {
+	Method string
+	Path   string
+}
+
+// testRegistry is a minimal in-memory implementation of the distribution
+// API, it is only used to test the pull and push of provider.
+type testRegistry struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mutex      sync.Mutex
+	blobs      map[digest.Digest][]byte
+	mediaTypes map[digest.Digest]string
+	tags       map[string]digest.Digest
+	uploads    map[string][]byte
+	requests   []testRequest
+	// The bytes of blob contents sent to clients.
+	sent map[digest.Digest]int64
+
+	// The bearer token authentication is enabled if username isn't empty.
+	username string
+	password string
+	tokens   map[string]bool
+	issued   int
+	// The read-only token is issued for anonymous request if it's enabled.
+	anonymous       bool
+	anonymousTokens map[string]bool
+
+	// hook is called before serving each request, the request
+	// is considered as handled if it returns true.
+	hook func(w http.ResponseWriter, r *http.Request) bool
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	reg := newUnstartedTestRegistry(t)
+	reg.server = httptest.NewServer(http.HandlerFunc(reg.serve))
+	t.Cleanup(reg.server.Close)
+	return reg
+}
+
+// newIPv6TestRegistry creates the registry listening on the IPv6 loopback
+// address, the test is skipped if IPv6 isn't available.
+func newIPv6TestRegistry(t *testing.T) *testRegistry {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is unavailable: %s", err)
+	}
+	reg := newUnstartedTestRegistry(t)
+	reg.server = httptest.NewUnstartedServer(http.HandlerFunc(reg.serve))
+	reg.server.Listener.Close()
+	reg.server.Listener = listener
+	reg.server.Start()
+	t.Cleanup(reg.server.Close)
+	return reg
+}
+
+func newUnstartedTestRegistry(t *testing.T) *testRegistry {
+	return &testRegistry{
+		t:          t,
+		blobs:      map[digest.Digest][]byte{},
+		mediaTypes: map[digest.Digest]string{},
+		tags:       map[string]digest.Digest{},
+		uploads:    map[string][]byte{},
+		sent:       map[digest.Digest]int64{},
+		tokens:     map[string]bool{},
+
+		anonymousTokens: map[string]bool{},
+	}
+}
+
+func (reg *testRegistry) host() string {
+	return reg.server.Listener.Addr().String()
+}
+
+// ref returns the full image reference of the repository in registry.
+func (reg *testRegistry) ref(name string) string {
+	return fmt.Sprintf("%s/%s", reg.host(), name)
+}
+
+func (reg *testRegistry) addBlob(mediaType string, data []byte) ocispec.Descriptor {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	dgst := digest.FromBytes(data)
+	reg.blobs[dgst] = data
+	reg.mediaTypes[dgst] = mediaType
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(data)),
+	}
+}
+
+func (reg *testRegistry) addJSON(mediaType string, v interface{}) ocispec.Descriptor {
+	data, err := json.Marshal(v)
+	require.NoError(reg.t, err)
+	return reg.addBlob(mediaType, data)
+}
+
+func (reg *testRegistry) tag(repo, tag string, desc ocispec.Descriptor) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.tags[repo+":"+tag] = desc.Digest
+}
+
+// addManifest creates an image manifest with the specified uncompressed
+// layers and returns the manifest descriptor.
+func (reg *testRegistry) addManifest(platform *ocispec.Platform, layers ...[]byte) ocispec.Descriptor {
+	config := ocispec.Image{
+		RootFS: ocispec.RootFS{Type: "layers"},
+	}
+	if platform != nil {
+		config.OS = platform.OS
+		config.Architecture = platform.Architecture
+		config.Variant = platform.Variant
+	}
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+	}
+	for _, layer := range layers {
+		desc := reg.addBlob(ocispec.MediaTypeImageLayer, layer)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, desc.Digest)
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+	manifest.Config = reg.addJSON(ocispec.MediaTypeImageConfig, config)
+	desc := reg.addJSON(ocispec.MediaTypeImageManifest, manifest)
+	desc.Platform = platform
+	return desc
+}
+
+// addImage creates and tags a single platform image in repository.
+func (reg *testRegistry) addImage(repo, tag string, layers ...[]byte) ocispec.Descriptor {
+	desc := reg.addManifest(nil, layers...)
+	reg.tag(repo, tag, desc)
+	return desc
+}
+
+// addIndex creates and tags a multi-platform image in repository.
+func (reg *testRegistry) addIndex(repo, tag string, manifests ...ocispec.Descriptor) ocispec.Descriptor {
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	}
+	desc := reg.addJSON(ocispec.MediaTypeImageIndex, index)
+	reg.tag(repo, tag, desc)
+	return desc
+}
+
+// enableAuth requires the bearer token issued for the credential
+// to access the registry.
+func (reg *testRegistry) enableAuth(username, password string) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.username = username
+	reg.password = password
+}
+
+// allowAnonymous enables the anonymous pull from registry.
+func (reg *testRegistry) allowAnonymous() {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.anonymous = true
+}
+
+// revokeTokens makes all issued tokens expired.
+func (reg *testRegistry) revokeTokens() {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.tokens = map[string]bool{}
+}
+
+func (reg *testRegistry) issuedTokens() int {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	return reg.issued
+}
+
+// authorize checks the authorization of request, and responds
+// the request and returns false if it's unauthorized.
+func (reg *testRegistry) authorize(w http.ResponseWriter, r *http.Request) bool {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	if reg.username == "" {
+		return true
+	}
+
+	if r.URL.Path == "/token" {
+		username, password, ok := r.BasicAuth()
+		if r.Method == http.MethodPost {
+			r.ParseForm()
+			username, password, ok = r.Form.Get("username"), r.Form.Get("password"), true
+		}
+		anonymous := r.Method == http.MethodGet && !ok && reg.anonymous
+		if !anonymous && (!ok || username != reg.username || password != reg.password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		token := uuid.NewString()
+		if anonymous {
+			reg.anonymousTokens[token] = true
+		} else {
+			reg.tokens[token] = true
+			reg.issued++
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":        token,
+			"access_token": token,
+			"expires_in":   300,
+		})
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if !reg.tokens[token] && !(readOnly && reg.anonymousTokens[token]) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="test-registry"`, r.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// setHook replaces the hook, the requests still being served by the
+// previous hook aren't affected.
+func (reg *testRegistry) setHook(hook func(w http.ResponseWriter, r *http.Request) bool) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.hook = hook
+}
+
+// count returns the number of requests with specified method and
+// the path containing the specified substring.
+func (reg *testRegistry) count(method, path string) int {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	n := 0
+	for _, req := range reg.requests {
+		if req.Method == method && strings.Contains(req.Path, path) {
+			n++
+		}
+	}
+	return n
+}
+
+func (reg *testRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	reg.mutex.Lock()
+	reg.requests = append(reg.requests, testRequest{Method: r.Method, Path: r.URL.Path})
+	hook := reg.hook
+	reg.mutex.Unlock()
+
+	if hook != nil && hook(w, r) {
+		return
+	}
+
+	if !reg.authorize(w, r) {
+		return
+	}
+
+	path := r.URL.Path
+	if path == "/v2/" || path == "/v2" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		reg.serveUpload(w, r)
+	case strings.Contains(path, "/manifests/"):
+		idx := strings.LastIndex(path, "/manifests/")
+		repo := strings.TrimPrefix(path[:idx], "/v2/")
+		reg.serveManifest(w, r, repo, path[idx+len("/manifests/"):])
+	case strings.Contains(path, "/blobs/"):
+		idx := strings.LastIndex(path, "/blobs/")
+		reg.serveBlob(w, r, digest.Digest(path[idx+len("/blobs/"):]))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (reg *testRegistry) serveContent(w http.ResponseWriter, r *http.Request, dgst digest.Digest) {
+	reg.mutex.Lock()
+	data, ok := reg.blobs[dgst]
+	mediaType := reg.mediaTypes[dgst]
+	reg.mutex.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" && r.Method == http.MethodGet {
+		var start int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err != nil || start >= len(data) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+		data = data[start:]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		reg.addSent(dgst, len(data))
+		w.Write(data)
+	}
+}
+
+func (reg *testRegistry) addSent(dgst digest.Digest, n int) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.sent[dgst] += int64(n)
+}
+
+// sentBytes returns the bytes of blob contents sent to clients.
+func (reg *testRegistry) sentBytes(dgst digest.Digest) int64 {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	return reg.sent[dgst]
+}
+
+func (reg *testRegistry) serveManifest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		dgst, err := digest.Parse(ref)
+		if err != nil {
+			reg.mutex.Lock()
+			dgst = reg.tags[repo+":"+ref]
+			reg.mutex.Unlock()
+		}
+		reg.serveContent(w, r, dgst)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		require.NoError(reg.t, err)
+		desc := reg.addBlob(r.Header.Get("Content-Type"), data)
+		if _, err := digest.Parse(ref); err != nil {
+			reg.tag(repo, ref, desc)
+		}
+		w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (reg *testRegistry) serveBlob(w http.ResponseWriter, r *http.Request, dgst digest.Digest) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		reg.serveContent(w, r, dgst)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (reg *testRegistry) serveUpload(w http.ResponseWriter, r *http.Request) {
+	idx := strings.LastIndex(r.URL.Path, "/blobs/uploads/")
+	prefix := r.URL.Path[:idx+len("/blobs/uploads/")]
+	id := r.URL.Path[idx+len("/blobs/uploads/"):]
+
+	switch r.Method {
+	case http.MethodPost:
+		id = uuid.NewString()
+		reg.mutex.Lock()
+		reg.uploads[id] = nil
+		reg.mutex.Unlock()
+		w.Header().Set("Location", prefix+id)
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch, http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		require.NoError(reg.t, err)
+		reg.mutex.Lock()
+		upload, ok := reg.uploads[id]
+		if ok {
+			upload = append(upload, data...)
+			reg.uploads[id] = upload
+		}
+		reg.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			w.Header().Set("Location", prefix+id)
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(upload)-1))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		dgst := digest.Digest(r.URL.Query().Get("digest"))
+		if dgst != digest.FromBytes(upload) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.addBlob("application/octet-stream", upload)
+		reg.mutex.Lock()
+		delete(reg.uploads, id)
+		reg.mutex.Unlock()
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
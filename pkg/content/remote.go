@@ -0,0 +1,180 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+var _ Provider = &RemoteProvider{}
+
+// RemoteProvider provides the image content from a running containerd
+// daemon, the images are pulled by containerd and the blobs are read from
+// the content store of containerd directly, which avoids to store the
+// same image twice on the host.
+type RemoteProvider struct {
+	client                 *containerd.Client
+	usePlainHTTP           bool
+	maxConcurrentDownloads int
+	hosts                  remote.HostFunc
+	platformMC             platforms.MatchComparer
+	resolverOpts           []remote.ResolverOpt
+}
+
+// NewRemoteProvider dials the containerd daemon on the address, the
+// namespace is used if there is no namespace specified in context. The
+// resolver options are applied to the resolvers of Pull and Push, and the
+// default platform is used if platformMC is nil.
+func NewRemoteProvider(
+	address, namespace string,
+	hosts remote.HostFunc,
+	platformMC platforms.MatchComparer,
+	resolverOpts ...remote.ResolverOpt,
+) (*RemoteProvider, error) {
+	client, err := containerd.New(address, containerd.WithDefaultNamespace(namespace))
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect to containerd %s", address)
+	}
+	return newRemoteProvider(client, hosts, platformMC, resolverOpts...), nil
+}
+
+func newRemoteProvider(client *containerd.Client, hosts remote.HostFunc, platformMC platforms.MatchComparer, resolverOpts ...remote.ResolverOpt) *RemoteProvider {
+	if platformMC == nil {
+		platformMC = platforms.DefaultStrict()
+	}
+	return &RemoteProvider{
+		client:                 client,
+		maxConcurrentDownloads: defaultMaxConcurrentDownloads,
+		hosts:                  hosts,
+		platformMC:             platformMC,
+		resolverOpts:           resolverOpts,
+	}
+}
+
+func (pvd *RemoteProvider) UsePlainHTTP() {
+	pvd.usePlainHTTP = true
+}
+
+// SetMaxConcurrentDownloads sets the max concurrent downloaded layer
+// limit for Pull, the containerd default (unlimited) is used if n <= 0.
+func (pvd *RemoteProvider) SetMaxConcurrentDownloads(n int) {
+	pvd.maxConcurrentDownloads = n
+}
+
+func (pvd *RemoteProvider) Resolver(ref string) (remotes.Resolver, error) {
+	credFunc, insecure, err := pvd.hosts(ref)
+	if err != nil {
+		return nil, err
+	}
+	return remote.NewResolver(insecure, pvd.usePlainHTTP, credFunc, pvd.resolverOpts...), nil
+}
+
+func (pvd *RemoteProvider) Pull(ctx context.Context, ref string, opts ...PullOpt) error {
+	var options PullOpts
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return errors.Wrap(err, "apply pull option")
+		}
+	}
+
+	resolver, err := pvd.Resolver(ref)
+	if err != nil {
+		return err
+	}
+
+	platformMC := pvd.platformMC
+	if options.platformMC != nil {
+		platformMC = options.platformMC
+	}
+	remoteOpts := []containerd.RemoteOpt{
+		containerd.WithResolver(newProgressResolver(resolver, options.progress)),
+		containerd.WithPlatformMatcher(platformMC),
+	}
+	if pvd.maxConcurrentDownloads > 0 {
+		remoteOpts = append(remoteOpts, containerd.WithMaxConcurrentDownloads(pvd.maxConcurrentDownloads))
+	}
+
+	if _, err := pvd.client.Fetch(ctx, ref, remoteOpts...); err != nil {
+		return errors.Wrap(err, "pull source image")
+	}
+
+	return nil
+}
+
+func (pvd *RemoteProvider) Push(ctx context.Context, desc ocispec.Descriptor, ref string, opts ...PushOpt) error {
+	var options PushOpts
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return errors.Wrap(err, "apply push option")
+		}
+	}
+
+	if options.manifestFormat != "" {
+		// The lease prevents the translated manifests from being reclaimed
+		// by the garbage collection of containerd before they are pushed.
+		leaseCtx, done, err := pvd.client.WithLease(ctx)
+		if err != nil {
+			return errors.Wrap(err, "create lease")
+		}
+		defer done(leaseCtx)
+		ctx = leaseCtx
+		converted, err := convertManifestFormat(ctx, pvd.client.ContentStore(), desc, options.manifestFormat)
+		if err != nil {
+			return errors.Wrapf(err, "convert manifest to %s format", options.manifestFormat)
+		}
+		desc = converted
+	}
+
+	resolver, err := pvd.Resolver(ref)
+	if err != nil {
+		return err
+	}
+
+	return pvd.client.Push(
+		ctx, ref, desc,
+		containerd.WithResolver(newProgressResolver(newMountResolver(resolver, options.mountFrom), options.progress)),
+		containerd.WithPlatformMatcher(pvd.platformMC),
+	)
+}
+
+func (pvd *RemoteProvider) Image(ctx context.Context, ref string) (*ocispec.Descriptor, error) {
+	img, err := pvd.client.ImageService().Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &img.Target, nil
+}
+
+func (pvd *RemoteProvider) DeleteImage(ctx context.Context, ref string) error {
+	return pvd.client.ImageService().Delete(ctx, ref, images.SynchronousDelete())
+}
+
+func (pvd *RemoteProvider) ContentStore() content.Store {
+	return pvd.client.ContentStore()
+}
+
+// Close closes the connection to containerd daemon.
+func (pvd *RemoteProvider) Close() error {
+	return pvd.client.Close()
+}
@@ -0,0 +1,104 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestContainerdClient creates a containerd client on the local stores
+// instead of the services of a containerd daemon.
+func newTestContainerdClient(t *testing.T) *containerd.Client {
+	dir := t.TempDir()
+	store, err := local.NewStore(filepath.Join(dir, "content"))
+	require.NoError(t, err)
+	bdb, err := bolt.Open(filepath.Join(dir, "meta.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { bdb.Close() })
+	db := metadata.NewDB(bdb, store, nil)
+	require.NoError(t, db.Init(context.Background()))
+
+	client, err := containerd.New("", containerd.WithServices(
+		containerd.WithContentStore(db.ContentStore()),
+		containerd.WithImageStore(metadata.NewImageStore(db)),
+		containerd.WithLeasesService(metadata.NewLeaseManager(db)),
+	))
+	require.NoError(t, err)
+	return client
+}
+
+func TestRemoteProvider(t *testing.T) {
+	reg := newTestRegistry(t)
+	defaultPlatform := platforms.DefaultSpec()
+	otherPlatform := ocispec.Platform{OS: "linux", Architecture: "s390x"}
+	if defaultPlatform.Architecture == otherPlatform.Architecture {
+		otherPlatform.Architecture = "ppc64le"
+	}
+	reg.addIndex("library/foo", "latest",
+		reg.addManifest(&defaultPlatform, []byte("default-layer")),
+		reg.addManifest(&otherPlatform, []byte("other-layer")),
+	)
+	var mutex sync.Mutex
+	userAgents := map[string]struct{}{}
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		userAgents[r.UserAgent()] = struct{}{}
+		return false
+	})
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	// The default platform is pulled if no platform is specified.
+	pvd := newRemoteProvider(newTestContainerdClient(t), hosts, nil, remote.WithUserAgent("remote-provider-test"))
+	pvd.UsePlainHTTP()
+	ctx := namespaces.WithNamespace(context.Background(), DefaultNamespace)
+	ref := reg.ref("library/foo:latest")
+
+	require.NoError(t, pvd.Pull(ctx, ref))
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, ocispec.MediaTypeImageIndex, desc.MediaType)
+	_, err = pvd.ContentStore().Info(ctx, digest.FromBytes([]byte("default-layer")))
+	require.NoError(t, err)
+	_, err = pvd.ContentStore().Info(ctx, digest.FromBytes([]byte("other-layer")))
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	// The resolver options are applied.
+	mutex.Lock()
+	require.Equal(t, map[string]struct{}{"remote-provider-test": {}}, userAgents)
+	mutex.Unlock()
+
+	require.NoError(t, pvd.DeleteImage(ctx, ref))
+	_, err = pvd.Image(ctx, ref)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
@@ -0,0 +1,134 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// WithReproducible makes the blobs repackaged by provider, like the
+// squashed layer, byte-identical for the same file trees: the tar entries
+// are sorted by path, the timestamps are normalized to the Unix epoch and
+// the owners are canonicalized to root, the creation time of history is
+// also omitted.
+func WithReproducible(enabled bool) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.reproducible = enabled
+		return nil
+	}
+}
+
+// The PAX records overridden by normalizeHeader.
+var normalizedPAXRecords = []string{"atime", "ctime", "mtime", "uid", "gid", "uname", "gname"}
+
+// normalizeHeader normalizes the fields of tar header which vary with the
+// environment building the layer, the path is cleaned as well.
+func normalizeHeader(hdr *tar.Header) *tar.Header {
+	normalized := *hdr
+	normalized.Name = cleanPath(hdr.Name)
+	if normalized.Name == "" {
+		normalized.Name = "."
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		normalized.Name += "/"
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		normalized.Linkname = cleanPath(hdr.Linkname)
+	}
+	normalized.ModTime = time.Unix(0, 0)
+	normalized.AccessTime = time.Time{}
+	normalized.ChangeTime = time.Time{}
+	normalized.Uid = 0
+	normalized.Gid = 0
+	normalized.Uname = ""
+	normalized.Gname = ""
+	if len(hdr.PAXRecords) > 0 {
+		normalized.PAXRecords = make(map[string]string, len(hdr.PAXRecords))
+		for k, v := range hdr.PAXRecords {
+			normalized.PAXRecords[k] = v
+		}
+		for _, k := range normalizedPAXRecords {
+			delete(normalized.PAXRecords, k)
+		}
+	}
+	// The format is chosen by tar writer with the normalized fields.
+	normalized.Format = tar.FormatUnknown
+	return &normalized
+}
+
+type spooledEntry struct {
+	hdr    *tar.Header
+	offset int64
+}
+
+// sortedTarWriter spools the entries into a temporary file, and writes
+// them sorted by path on flush, the hard links are written after the other
+// entries so that their targets exist on extraction.
+type sortedTarWriter struct {
+	tw      *tar.Writer
+	spool   *os.File
+	offset  int64
+	entries []spooledEntry
+}
+
+func newSortedTarWriter(tw *tar.Writer) (*sortedTarWriter, error) {
+	spool, err := os.CreateTemp("", "acceld-sorted-tar-")
+	if err != nil {
+		return nil, err
+	}
+	return &sortedTarWriter{tw: tw, spool: spool}, nil
+}
+
+func (w *sortedTarWriter) add(hdr *tar.Header, reader io.Reader) error {
+	n, err := io.Copy(w.spool, reader)
+	if err != nil {
+		return err
+	}
+	hdr.Size = n
+	w.entries = append(w.entries, spooledEntry{hdr: hdr, offset: w.offset})
+	w.offset += n
+	return nil
+}
+
+// flush writes the spooled entries sorted into the tar writer.
+func (w *sortedTarWriter) flush() error {
+	sort.SliceStable(w.entries, func(i, j int) bool {
+		li := w.entries[i].hdr.Typeflag == tar.TypeLink
+		lj := w.entries[j].hdr.Typeflag == tar.TypeLink
+		if li != lj {
+			return lj
+		}
+		return w.entries[i].hdr.Name < w.entries[j].hdr.Name
+	})
+	for _, entry := range w.entries {
+		if err := w.tw.WriteHeader(entry.hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w.tw, io.NewSectionReader(w.spool, entry.offset, entry.hdr.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discard removes the spool.
+func (w *sortedTarWriter) discard() {
+	w.spool.Close()
+	os.Remove(w.spool.Name())
+}
@@ -0,0 +1,51 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"io"
+
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SetResumeDownloads sets whether Pull resumes the partially written blobs
+// left in the ingest area of content store by an interrupted pull, it's
+// enabled by default. The blob is resumed from the written offset by a
+// range request, the completed blobs are never downloaded again.
+func (pvd *LocalProvider) SetResumeDownloads(enabled bool) {
+	pvd.resumeDownloads = enabled
+}
+
+// noResumeResolver hides the seeker of fetched blobs, so the content
+// store discards the bytes up to the written offset rather than seeking.
+type noResumeResolver struct {
+	remotes.Resolver
+}
+
+func (resolver *noResumeResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := resolver.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		return struct{ io.ReadCloser }{rc}, nil
+	}), nil
+}
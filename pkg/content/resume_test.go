@@ -0,0 +1,81 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// abortLayer makes the registry close the connection after sending half
+// of the layer, the retries by range request are aborted too until the
+// returned function is called.
+func abortLayer(reg *testRegistry, layer []byte) func() {
+	dgst := digest.FromBytes(layer)
+	var recovered int32
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, dgst.String()) {
+			return false
+		}
+		if atomic.LoadInt32(&recovered) == 1 {
+			return false
+		}
+		if r.Header.Get("Range") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(layer)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(layer[:len(layer)/2])
+			w.(http.Flusher).Flush()
+			reg.addSent(dgst, len(layer)/2)
+		}
+		panic(http.ErrAbortHandler)
+	})
+	return func() {
+		atomic.StoreInt32(&recovered, 1)
+	}
+}
+
+func TestResumeDownloads(t *testing.T) {
+	for _, resume := range []bool{true, false} {
+		reg := newTestRegistry(t)
+		layer := bytes.Repeat([]byte("foo-layer-1"), 100000)
+		reg.addImage("library/foo", "latest", layer)
+		recoverLayer := abortLayer(reg, layer)
+
+		pvd := newTestProvider(t)
+		pvd.SetResumeDownloads(resume)
+		ctx := testContext()
+		ref := reg.ref("library/foo:latest")
+		require.Error(t, pvd.Pull(ctx, ref))
+		recoverLayer()
+		// The seeker is kept by the progress wrapper.
+		progress := func(desc ocispec.Descriptor, transferred, total int64) {}
+		require.NoError(t, pvd.Pull(ctx, ref, WithPullProgress(progress)))
+
+		sent := reg.sentBytes(digest.FromBytes(layer))
+		if resume {
+			require.Equal(t, int64(len(layer)), sent)
+		} else {
+			require.Equal(t, int64(len(layer)+len(layer)/2), sent)
+		}
+	}
+}
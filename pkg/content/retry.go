@@ -0,0 +1,140 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/sirupsen/logrus"
+
+	accelerrdefs "github.com/goharbor/acceleration-service/pkg/errdefs"
+)
+
+// RetryConfig configures the retry policy of Pull and Push on
+// retryable errors, the operation is retried with an exponential
+// backoff bounded by InitialBackoff * Multiplier ^ (attempt - 1), the
+// exact bound is used only if jitter is disabled.
+type RetryConfig struct {
+	// Max attempts of the operation, retry is disabled if <= 1.
+	MaxAttempts int
+	// Backoff before the first retry.
+	InitialBackoff time.Duration
+	// Multiplier of backoff for each retry, defaults to 2 if <= 0.
+	Multiplier float64
+	// The backoff is picked randomly in [0, backoff) by default, so the
+	// workers failed at the same time, e.g. on the expiry of tokens, don't
+	// retry in lockstep and overload the registry or token service.
+	// DisableJitter uses the exact backoff instead.
+	DisableJitter bool
+}
+
+// DefaultRetryConfig returns the retry policy with 3 attempts and the
+// jittered exponential backoff from 500ms.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		Multiplier:     2,
+	}
+}
+
+// SetRetryConfig sets the retry policy of Pull and Push.
+func (pvd *LocalProvider) SetRetryConfig(cfg RetryConfig) {
+	pvd.retryConfig = cfg
+}
+
+func (cfg RetryConfig) backoff(attempt int) time.Duration {
+	multiplier := cfg.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	backoff := float64(cfg.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= multiplier
+	}
+	return time.Duration(backoff)
+}
+
+// delay returns the backoff before the retry of attempt, which is picked
+// by random from [0, backoff) unless jitter is disabled.
+func (cfg RetryConfig) delay(attempt int, random func(n int64) int64) time.Duration {
+	backoff := cfg.backoff(attempt)
+	if cfg.DisableJitter || backoff <= 0 {
+		return backoff
+	}
+	return time.Duration(random(int64(backoff)))
+}
+
+// retry calls op until it succeeds, or a non-retryable error is
+// returned, or the max attempts is reached, or ctx is done.
+func retry(ctx context.Context, cfg RetryConfig, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= cfg.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		backoff := cfg.delay(attempt, rand.Int63n)
+		logrus.WithError(err).Warnf("retry after %s (attempt %d/%d)", backoff, attempt, cfg.MaxAttempts)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable returns whether err is transient, that is, the network
+// errors, 429 and 5xx HTTP status errors.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// Let caller retry with plain HTTP instead.
+	if accelerrdefs.NeedsRetryWithHTTP(err) {
+		return false
+	}
+	if errdefs.IsNotFound(err) || errors.Is(err, docker.ErrInvalidAuthorization) {
+		return false
+	}
+
+	var statusErr remoteerrors.ErrUnexpectedStatus
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,101 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPull(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest", []byte("foo-layer-1"))
+
+	var failures int32 = 2
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.Contains(r.URL.Path, "/manifests/") && atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	ref := reg.ref("library/foo:latest")
+
+	require.Error(t, pvd.Pull(ctx, ref))
+
+	atomic.StoreInt32(&failures, 2)
+	pvd.SetRetryConfig(RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	})
+	require.NoError(t, pvd.Pull(ctx, ref))
+}
+
+func TestRetryNotFound(t *testing.T) {
+	reg := newTestRegistry(t)
+
+	pvd := newTestProvider(t)
+	pvd.SetRetryConfig(RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	})
+
+	err := pvd.Pull(testContext(), reg.ref("library/foo:latest"))
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.Equal(t, 1, reg.count(http.MethodHead, "/manifests/"))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	cfg := RetryConfig{
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     3,
+	}
+	require.Equal(t, 100*time.Millisecond, cfg.backoff(1))
+	require.Equal(t, 300*time.Millisecond, cfg.backoff(2))
+	require.Equal(t, 900*time.Millisecond, cfg.backoff(3))
+}
+
+func TestRetryJitter(t *testing.T) {
+	// The jitter is enabled by default.
+	cfg := RetryConfig{InitialBackoff: 100 * time.Millisecond}
+	require.False(t, DefaultRetryConfig().DisableJitter)
+
+	random := rand.New(rand.NewSource(1))
+	for attempt := 1; attempt <= 3; attempt++ {
+		backoff := cfg.backoff(attempt)
+		delays := map[time.Duration]struct{}{}
+		for i := 0; i < 100; i++ {
+			delay := cfg.delay(attempt, random.Int63n)
+			require.GreaterOrEqual(t, delay, time.Duration(0))
+			require.Less(t, delay, backoff)
+			delays[delay] = struct{}{}
+		}
+		// The delays of concurrent retries are spread out.
+		require.Greater(t, len(delays), 90)
+	}
+
+	cfg.DisableJitter = true
+	require.Equal(t, cfg.backoff(2), cfg.delay(2, random.Int63n))
+}
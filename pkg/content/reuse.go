@@ -0,0 +1,69 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// reusableImage resolves the reference pulled before and returns the
+// resolved manifest if the manifest and all the blobs matched by platform
+// are present in content store, so the image can be reused without
+// fetching, the moved tag is resolved to the new manifest. It returns nil
+// if the image needs to be fetched, the errors are ignored since the fetch
+// reports them anyway.
+func (pvd *LocalProvider) reusableImage(
+	ctx context.Context,
+	resolver remotes.Resolver,
+	ref string,
+	platformMC platforms.MatchComparer,
+	force bool,
+) *ocispec.Descriptor {
+	pvd.mutex.Lock()
+	_, ok := pvd.images[ref]
+	pvd.mutex.Unlock()
+	if !ok || force {
+		return nil
+	}
+
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil
+	}
+
+	store := *pvd.store
+	childrenHandler := images.FilterPlatforms(artifactChildrenHandler(store), platformMC)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, err := store.Info(ctx, desc.Digest); err != nil {
+			return nil, err
+		}
+		return childrenHandler(ctx, desc)
+	})
+	if err := images.Walk(ctx, handler, desc); err != nil {
+		if !errdefs.IsNotFound(err) {
+			log.G(ctx).WithError(err).Warn("failed to check local image")
+		}
+		return nil
+	}
+
+	return &desc
+}
@@ -0,0 +1,54 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ReferenceRewriter rewrites the normalized reference of image before
+// resolution, e.g. to redirect `docker.io/*` to the namespace of an
+// internal mirror. The rewritten reference must be returned as is if it's
+// rewritten again.
+type ReferenceRewriter func(ref string) (string, error)
+
+// WithReferenceRewriter rewrites the references of the operations on
+// provider, like Pull, Push and Image, both the registry requests and the
+// images in content store use the rewritten references, while the logs
+// and audit records keep the original ones.
+func WithReferenceRewriter(rewriter ReferenceRewriter) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.referenceRewriter = rewriter
+		return nil
+	}
+}
+
+// rewriteRef returns the normalized reference rewritten by the rewriter
+// of provider, the digests of manifests are returned as is.
+func (pvd *LocalProvider) rewriteRef(ref string) (string, error) {
+	if _, err := digest.Parse(ref); err == nil {
+		return ref, nil
+	}
+	ref = normalizeRef(ref)
+	if pvd.referenceRewriter == nil {
+		return ref, nil
+	}
+	rewritten, err := pvd.referenceRewriter(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "rewrite reference %s", ref)
+	}
+	return normalizeRef(rewritten), nil
+}
@@ -0,0 +1,156 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	defaultMaxConcurrentLarge = 1
+	defaultMaxConcurrentSmall = 8
+)
+
+// FetchSchedule limits the layers downloaded in parallel by their sizes,
+// so the many small layers are downloaded with high concurrency, while the
+// few large layers don't spike the memory and bandwidth.
+type FetchSchedule struct {
+	// LargeLayerSize is the size in bytes from which a layer is large,
+	// the schedule is disabled if it's <= 0.
+	LargeLayerSize int64
+	// MaxConcurrentLarge limits the large layers downloaded in parallel,
+	// defaults to 1 if it's <= 0.
+	MaxConcurrentLarge int
+	// MaxConcurrentSmall limits the small layers downloaded in parallel,
+	// defaults to 8 if it's <= 0.
+	MaxConcurrentSmall int
+}
+
+// fetchScheduler holds the download slots of layers shared by all the
+// Pull calls of provider.
+type fetchScheduler struct {
+	largeSize int64
+	large     *semaphore.Weighted
+	small     *semaphore.Weighted
+}
+
+// SetFetchSchedule schedules the layer downloads of Pull by schedule,
+// which replaces the limit set by SetMaxConcurrentDownloads, the manifests
+// and configs aren't limited.
+func (pvd *LocalProvider) SetFetchSchedule(schedule FetchSchedule) {
+	if schedule.LargeLayerSize <= 0 {
+		pvd.fetchScheduler = nil
+		return
+	}
+	large, small := schedule.MaxConcurrentLarge, schedule.MaxConcurrentSmall
+	if large <= 0 {
+		large = defaultMaxConcurrentLarge
+	}
+	if small <= 0 {
+		small = defaultMaxConcurrentSmall
+	}
+	pvd.fetchScheduler = &fetchScheduler{
+		largeSize: schedule.LargeLayerSize,
+		large:     semaphore.NewWeighted(int64(large)),
+		small:     semaphore.NewWeighted(int64(small)),
+	}
+}
+
+// slots returns the download slots of desc, it's nil for the blobs other
+// than layers.
+func (s *fetchScheduler) slots(desc ocispec.Descriptor) *semaphore.Weighted {
+	if !images.IsLayerType(desc.MediaType) {
+		return nil
+	}
+	if desc.Size >= s.largeSize {
+		return s.large
+	}
+	return s.small
+}
+
+// scheduleResolver holds a download slot of layer from fetching until
+// the fetched reader is closed.
+type scheduleResolver struct {
+	remotes.Resolver
+	scheduler *fetchScheduler
+}
+
+func newScheduleResolver(resolver remotes.Resolver, scheduler *fetchScheduler) remotes.Resolver {
+	if scheduler == nil {
+		return resolver
+	}
+	return &scheduleResolver{
+		Resolver:  resolver,
+		scheduler: scheduler,
+	}
+}
+
+func (resolver *scheduleResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := resolver.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		slots := resolver.scheduler.slots(desc)
+		if slots == nil {
+			return fetcher.Fetch(ctx, desc)
+		}
+		if err := slots.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			slots.Release(1)
+			return nil, err
+		}
+		reader := &scheduledReader{ReadCloser: rc, slots: slots}
+		if _, ok := rc.(io.Seeker); ok {
+			return &scheduledReadSeeker{reader}, nil
+		}
+		return reader, nil
+	}), nil
+}
+
+type scheduledReader struct {
+	io.ReadCloser
+	slots *semaphore.Weighted
+	once  sync.Once
+}
+
+// Close releases the download slot once.
+func (reader *scheduledReader) Close() error {
+	err := reader.ReadCloser.Close()
+	reader.once.Do(func() {
+		reader.slots.Release(1)
+	})
+	return err
+}
+
+// scheduledReadSeeker keeps the seeker of fetched blob, which is used to
+// resume the download from the offset of partially written blob.
+type scheduledReadSeeker struct {
+	*scheduledReader
+}
+
+func (reader *scheduledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return reader.ReadCloser.(io.Seeker).Seek(offset, whence)
+}
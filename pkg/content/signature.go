@@ -0,0 +1,226 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// The signature format of cosign, the signatures of image are the layers
+// of manifest tagged `sha256-<hex>.sig` in the same repository, see also:
+// https://github.com/sigstore/cosign/blob/main/specs/SIGNATURE_SPEC.md
+const (
+	cosignSignatureTagSuffix = ".sig"
+	cosignPayloadMediaType   = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignSignatureKey       = "dev.cosignproject.cosign/signature"
+	cosignSignatureType      = "cosign container image signature"
+	// maxSignaturePayloadSize limits the size of payload read into memory.
+	maxSignaturePayloadSize = 1 << 20
+)
+
+// ErrSignatureVerification is returned by Pull if the image has no
+// signature verified by the public keys.
+var ErrSignatureVerification = errors.New("signature verification failed")
+
+// WithSignatureVerification verifies the cosign signature of image on Pull
+// by the PEM encoded public keys (ECDSA, RSA or Ed25519), the image isn't
+// accepted unless its manifest digest is signed by any of the keys. The
+// keyless signatures of Fulcio certificates and Rekor entries are not
+// supported yet.
+func WithSignatureVerification(pems ...[]byte) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		for _, data := range pems {
+			key, err := parsePublicKey(data)
+			if err != nil {
+				return errors.Wrap(err, "parse verification key")
+			}
+			opts.verificationKeys = append(opts.verificationKeys, key)
+		}
+		return nil
+	}
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM data")
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// simpleSigning is the payload signed by cosign.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// verifySignature verifies the signature of manifest dgst in the
+// repository of ref, nil is returned if verification is disabled.
+func (pvd *LocalProvider) verifySignature(ctx context.Context, resolver remotes.Resolver, ref string, dgst digest.Digest) error {
+	if len(pvd.verificationKeys) == 0 {
+		return nil
+	}
+
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return errors.Wrapf(err, "parse reference %s", ref)
+	}
+	sigRef := spec.Locator + ":" + strings.Replace(dgst.String(), ":", "-", 1) + cosignSignatureTagSuffix
+	name, desc, err := resolver.Resolve(ctx, sigRef)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return errors.Wrapf(ErrSignatureVerification, "no signature of %s found", dgst)
+		}
+		return errors.Wrapf(err, "resolve signature %s", sigRef)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "get fetcher for %s", name)
+	}
+
+	var manifest ocispec.Manifest
+	if err := fetchJSON(ctx, fetcher, desc, maxManifestSize, &manifest); err != nil {
+		return errors.Wrapf(err, "fetch signature manifest %s", desc.Digest)
+	}
+
+	for _, layer := range manifest.Layers {
+		signature, ok := layer.Annotations[cosignSignatureKey]
+		if layer.MediaType != cosignPayloadMediaType || !ok {
+			continue
+		}
+		payload, err := fetchBlob(ctx, fetcher, layer, maxSignaturePayloadSize)
+		if err != nil {
+			return errors.Wrapf(err, "fetch signature payload %s", layer.Digest)
+		}
+		if err := pvd.verifyPayload(payload, signature, dgst); err != nil {
+			continue
+		}
+		return nil
+	}
+
+	return errors.Wrapf(ErrSignatureVerification, "no signature of %s verified by the keys", dgst)
+}
+
+// verifyPayload verifies the signature of payload by any of keys, and the
+// payload is the signing of manifest dgst.
+func (pvd *LocalProvider) verifyPayload(payload []byte, signature string, dgst digest.Digest) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.Wrap(err, "decode signature")
+	}
+	verified := false
+	for _, key := range pvd.verificationKeys {
+		if verifySignatureByKey(key, payload, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return fmt.Errorf("signature isn't verified by the keys")
+	}
+
+	var signing simpleSigning
+	if err := json.Unmarshal(payload, &signing); err != nil {
+		return errors.Wrap(err, "unmarshal signature payload")
+	}
+	if signing.Critical.Type != cosignSignatureType {
+		return fmt.Errorf("unexpected signature type %q", signing.Critical.Type)
+	}
+	if signing.Critical.Image.DockerManifestDigest != dgst {
+		return fmt.Errorf("signature is for manifest %s", signing.Critical.Image.DockerManifestDigest)
+	}
+
+	return nil
+}
+
+func verifySignatureByKey(key crypto.PublicKey, payload, sig []byte) bool {
+	hashed := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hashed[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+	return false
+}
+
+func fetchBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, limit int64) ([]byte, error) {
+	// The digest from registry may have an unavailable algorithm.
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid digest %s", desc.Digest)
+	}
+	if desc.Size > limit {
+		return nil, fmt.Errorf("blob %s exceeds the max size %d", desc.Digest, limit)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, desc.Size))
+	if err != nil {
+		return nil, err
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(data); actual != desc.Digest {
+		return nil, fmt.Errorf("blob %s has unexpected digest %s", desc.Digest, actual)
+	}
+	return data, nil
+}
+
+func fetchJSON(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, limit int64, v interface{}) error {
+	data, err := fetchBlob(ctx, fetcher, desc, limit)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
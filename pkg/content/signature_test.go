@@ -0,0 +1,122 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func newSigningKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// sign attaches the cosign signature of manifest to repository.
+func sign(t *testing.T, reg *testRegistry, repo string, key *ecdsa.PrivateKey, manifest digest.Digest) ocispec.Descriptor {
+	return signWithType(t, reg, repo, key, manifest, cosignSignatureType)
+}
+
+func signWithType(t *testing.T, reg *testRegistry, repo string, key *ecdsa.PrivateKey, manifest digest.Digest, typ string) ocispec.Descriptor {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":%q},"optional":null}`, repo, manifest, typ))
+	hashed := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hashed[:])
+	require.NoError(t, err)
+
+	layer := reg.addBlob(cosignPayloadMediaType, payload)
+	layer.Annotations = map[string]string{cosignSignatureKey: base64.StdEncoding.EncodeToString(sig)}
+	desc := reg.addJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    reg.addJSON(ocispec.MediaTypeImageConfig, ocispec.Image{}),
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	reg.tag(repo, signatureTag(manifest), desc)
+	return desc
+}
+
+func signatureTag(manifest digest.Digest) string {
+	return strings.Replace(manifest.String(), ":", "-", 1) + ".sig"
+}
+
+func TestSignatureVerification(t *testing.T) {
+	key, pub := newSigningKey(t)
+	otherKey, _ := newSigningKey(t)
+
+	reg := newTestRegistry(t)
+	signed := reg.addImage("library/signed", "latest", []byte("signed-layer"))
+	sign(t, reg, "library/signed", key, signed.Digest)
+	reg.addImage("library/unsigned", "latest", []byte("unsigned-layer"))
+	untrusted := reg.addImage("library/untrusted", "latest", []byte("untrusted-layer"))
+	sign(t, reg, "library/untrusted", otherKey, untrusted.Digest)
+	// The signature of another manifest is rejected.
+	mismatched := reg.addImage("library/mismatched", "latest", []byte("mismatched-layer"))
+	reg.tag("library/mismatched", signatureTag(mismatched.Digest), sign(t, reg, "library/mismatched", key, signed.Digest))
+	// The payload of other types is rejected.
+	untyped := reg.addImage("library/untyped", "latest", []byte("untyped-layer"))
+	signWithType(t, reg, "library/untyped", key, untyped.Digest, "other signature")
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.All, WithSignatureVerification(pub))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/signed:latest")))
+	for _, repo := range []string{"library/unsigned", "library/untrusted", "library/mismatched", "library/untyped"} {
+		ref := reg.ref(repo + ":latest")
+		err := pvd.Pull(ctx, ref)
+		require.ErrorIs(t, err, ErrSignatureVerification, repo)
+		_, err = pvd.Image(ctx, ref)
+		require.ErrorIs(t, err, errdefs.ErrNotFound)
+	}
+
+	// Unsigned images are allowed without verification.
+	pvd = newTestProvider(t)
+	require.NoError(t, pvd.Pull(ctx, reg.ref("library/unsigned:latest")))
+
+	_, _, err = NewLocalProvider(t.TempDir(), hosts, platforms.All, WithSignatureVerification([]byte("invalid")))
+	require.Error(t, err)
+}
+
+func TestFetchBlobInvalidDigest(t *testing.T) {
+	resolver := remote.NewResolver(false, true, nil)
+	fetcher, err := resolver.Fetcher(testContext(), "127.0.0.1:1/library/foo:latest")
+	require.NoError(t, err)
+	// The unavailable algorithm is rejected before fetching.
+	_, err = fetchBlob(testContext(), fetcher, ocispec.Descriptor{Digest: "md5:d41d8cd98f00b204e9800998ecf8427e", Size: 1}, 1)
+	require.ErrorContains(t, err, "invalid digest")
+}
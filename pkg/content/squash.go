@@ -0,0 +1,374 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// The whiteout files of OCI image layer, see also:
+// https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// Squash flattens the layers of pulled image of srcRef into a single
+// layer, and records the squashed image as dstRef. The layers are
+// applied in order, so the whiteout files of upper layers delete the
+// files of lower ones. Only the pulled manifest best matched by the
+// platform of provider is squashed for the multi-platform image.
+func (pvd *LocalProvider) Squash(ctx context.Context, srcRef, dstRef string) (*ocispec.Descriptor, error) {
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	if pvd.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	srcRef, err := pvd.rewriteRef(srcRef)
+	if err != nil {
+		return nil, err
+	}
+	if dstRef, err = pvd.rewriteRef(dstRef); err != nil {
+		return nil, err
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	target, err := pvd.getImage(ctx, srcRef)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get image %s", srcRef)
+	}
+
+	store := *pvd.store
+	manifestDesc, err := matchManifest(ctx, store, *target, pvd.platformMC)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := readJSON(ctx, store, manifestDesc, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "read manifest %s", manifestDesc.Digest)
+	}
+	// The config is kept as raw fields, which may be unknown to image spec.
+	var config map[string]json.RawMessage
+	if err := readJSON(ctx, store, manifest.Config, &config); err != nil {
+		return nil, errors.Wrapf(err, "read config %s", manifest.Config.Digest)
+	}
+
+	layer, diffID, err := squashLayers(ctx, store, manifestDesc, manifest.Layers, pvd.reproducible)
+	if err != nil {
+		return nil, errors.Wrapf(err, "squash layers of %s", srcRef)
+	}
+
+	if err := setJSONField(config, "rootfs", ocispec.RootFS{
+		Type:    "layers",
+		DiffIDs: []digest.Digest{diffID},
+	}); err != nil {
+		return nil, err
+	}
+	history := ocispec.History{
+		Comment: fmt.Sprintf("squashed %d layers of %s", len(manifest.Layers), manifestDesc.Digest),
+	}
+	if !pvd.reproducible {
+		created := time.Now().UTC()
+		history.Created = &created
+	}
+	if err := setJSONField(config, "history", []ocispec.History{history}); err != nil {
+		return nil, err
+	}
+	manifest.Config, err = writeJSON(ctx, store, manifest.Config.MediaType, config, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "write squashed config")
+	}
+
+	manifest.Layers = []ocispec.Descriptor{layer}
+	desc, err := writeJSON(ctx, store, manifestDesc.MediaType, manifest, map[string]string{
+		"containerd.io/gc.ref.content.config": manifest.Config.Digest.String(),
+		"containerd.io/gc.ref.content.l.0":    layer.Digest.String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write squashed manifest")
+	}
+	desc.Platform = manifestDesc.Platform
+
+	if err := pvd.setImage(ctx, dstRef, &desc); err != nil {
+		return nil, errors.Wrap(err, "set squashed image")
+	}
+
+	return &desc, nil
+}
+
+// matchManifest returns the manifest of target, the pulled one best matched
+// by platform is chosen if the target is an index. The image may be pulled
+// for the platforms other than the given ones, so the pulled manifests of
+// other platforms are chosen if none of the matched ones is pulled.
+func matchManifest(ctx context.Context, store content.Store, target ocispec.Descriptor, platformMC platforms.MatchComparer) (ocispec.Descriptor, error) {
+	switch target.MediaType {
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		return target, nil
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+	default:
+		return target, errors.Wrapf(errdefs.ErrNotImplemented, "unsupported media type %s of %s", target.MediaType, target.Digest)
+	}
+
+	var index ocispec.Index
+	if err := readJSON(ctx, store, target, &index); err != nil {
+		return target, errors.Wrapf(err, "read index %s", target.Digest)
+	}
+	var candidates, others []ocispec.Descriptor
+	for _, desc := range index.Manifests {
+		if _, err := store.Info(ctx, desc.Digest); err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return target, errors.Wrapf(err, "get info of manifest %s", desc.Digest)
+		}
+		if desc.Platform == nil || platformMC.Match(*desc.Platform) {
+			candidates = append(candidates, desc)
+		} else {
+			others = append(others, desc)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Platform == nil {
+			return false
+		}
+		if candidates[j].Platform == nil {
+			return true
+		}
+		return platformMC.Less(*candidates[i].Platform, *candidates[j].Platform)
+	})
+	candidates = append(candidates, others...)
+	if len(candidates) == 0 {
+		return target, errors.Wrapf(errdefs.ErrNotFound, "no manifest is pulled in %s", target.Digest)
+	}
+
+	return matchManifest(ctx, store, candidates[0], platformMC)
+}
+
+func readJSON(ctx context.Context, store content.Store, desc ocispec.Descriptor, v interface{}) error {
+	data, err := content.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSON(ctx context.Context, store content.Store, mediaType string, v interface{}, gcLabels map[string]string) (ocispec.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	ref := "squash-" + desc.Digest.String()
+	if err := content.WriteBlob(ctx, store, ref, bytes.NewReader(data), desc, content.WithLabels(gcLabels)); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func setJSONField(fields map[string]json.RawMessage, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %s", key)
+	}
+	fields[key] = data
+	return nil
+}
+
+// squashLayers writes the merged layer of layers into content store as a
+// gzip compressed layer, and returns its descriptor and diff ID.
+//
+// The layers are read twice, the first pass finds the layer owning each
+// path in the merged layer, and the second one copies the owned entries
+// in the order of layers, so the hard links are after their targets. The
+// entries are sorted and normalized if reproducible is set.
+func squashLayers(ctx context.Context, store content.Store, manifestDesc ocispec.Descriptor, layers []ocispec.Descriptor, reproducible bool) (ocispec.Descriptor, digest.Digest, error) {
+	owners := map[string]int{}
+	for idx, layer := range layers {
+		if err := walkLayer(ctx, store, layer, func(hdr *tar.Header, _ io.Reader) error {
+			name := cleanPath(hdr.Name)
+			dir, base := path.Split(name)
+			dir = strings.TrimSuffix(dir, "/")
+			switch {
+			case base == whiteoutOpaque:
+				deleteChildren(owners, dir, idx)
+			case strings.HasPrefix(base, whiteoutPrefix):
+				deleted := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				deleteLower(owners, deleted, idx)
+				deleteChildren(owners, deleted, idx)
+			default:
+				// The non-directory replaces the directory of lower layers.
+				if hdr.Typeflag != tar.TypeDir {
+					deleteChildren(owners, name, idx)
+				}
+				owners[name] = idx
+			}
+			return nil
+		}); err != nil {
+			return ocispec.Descriptor{}, "", errors.Wrapf(err, "read layer %s", layer.Digest)
+		}
+	}
+
+	writer, err := content.OpenWriter(ctx, store, content.WithRef("squash-"+manifestDesc.Digest.String()))
+	if err != nil {
+		return ocispec.Descriptor{}, "", errors.Wrap(err, "open writer")
+	}
+	defer writer.Close()
+	if err := writer.Truncate(0); err != nil {
+		return ocispec.Descriptor{}, "", errors.Wrap(err, "truncate writer")
+	}
+
+	diffID := digest.SHA256.Digester()
+	gw := gzip.NewWriter(writer)
+	tw := tar.NewWriter(io.MultiWriter(gw, diffID.Hash()))
+	writeEntry := func(hdr *tar.Header, reader io.Reader) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, reader)
+		return err
+	}
+	var sorted *sortedTarWriter
+	if reproducible {
+		sorted, err = newSortedTarWriter(tw)
+		if err != nil {
+			return ocispec.Descriptor{}, "", errors.Wrap(err, "create sorted tar writer")
+		}
+		defer sorted.discard()
+		writeEntry = func(hdr *tar.Header, reader io.Reader) error {
+			return sorted.add(normalizeHeader(hdr), reader)
+		}
+	}
+	for idx, layer := range layers {
+		if err := walkLayer(ctx, store, layer, func(hdr *tar.Header, reader io.Reader) error {
+			if owner, ok := owners[cleanPath(hdr.Name)]; !ok || owner != idx {
+				return nil
+			}
+			return writeEntry(hdr, reader)
+		}); err != nil {
+			return ocispec.Descriptor{}, "", errors.Wrapf(err, "copy layer %s", layer.Digest)
+		}
+	}
+	if sorted != nil {
+		if err := sorted.flush(); err != nil {
+			return ocispec.Descriptor{}, "", errors.Wrap(err, "write sorted entries")
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return ocispec.Descriptor{}, "", errors.Wrap(err, "close tar writer")
+	}
+	if err := gw.Close(); err != nil {
+		return ocispec.Descriptor{}, "", errors.Wrap(err, "close gzip writer")
+	}
+
+	status, err := writer.Status()
+	if err != nil {
+		return ocispec.Descriptor{}, "", errors.Wrap(err, "get writer status")
+	}
+	mediaType := ocispec.MediaTypeImageLayerGzip
+	if manifestDesc.MediaType == images.MediaTypeDockerSchema2Manifest {
+		mediaType = images.MediaTypeDockerSchema2LayerGzip
+	}
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    writer.Digest(),
+		Size:      status.Offset,
+	}
+	if err := writer.Commit(ctx, desc.Size, desc.Digest, content.WithLabels(map[string]string{
+		labels.LabelUncompressed: diffID.Digest().String(),
+	})); err != nil && !errdefs.IsAlreadyExists(err) {
+		return ocispec.Descriptor{}, "", errors.Wrap(err, "commit squashed layer")
+	}
+
+	return desc, diffID.Digest(), nil
+}
+
+// walkLayer calls fn for each entry of the decompressed layer.
+func walkLayer(ctx context.Context, store content.Store, layer ocispec.Descriptor, fn func(hdr *tar.Header, reader io.Reader) error) error {
+	ra, err := store.ReaderAt(ctx, layer)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+	reader, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return errors.Wrap(err, "decompress layer")
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanPath normalizes the path of tar entry like `./foo/bar/` to `foo/bar`.
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// deleteLower deletes the path owned by the layers lower than idx.
+func deleteLower(owners map[string]int, name string, idx int) {
+	if owner, ok := owners[name]; ok && owner < idx {
+		delete(owners, name)
+	}
+}
+
+// deleteChildren deletes the children of directory owned by the layers
+// lower than idx, the directory itself is kept.
+func deleteChildren(owners map[string]int, dir string, idx int) {
+	prefix := dir + "/"
+	for name, owner := range owners {
+		if owner < idx && name != dir && (dir == "" || strings.HasPrefix(name, prefix)) {
+			delete(owners, name)
+		}
+	}
+}
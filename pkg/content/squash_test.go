@@ -0,0 +1,215 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	data     string
+	modTime  time.Time
+	uid      int
+	linkname string
+}
+
+func newTarLayer(t *testing.T, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Mode:     0644,
+			Size:     int64(len(entry.data)),
+			ModTime:  entry.modTime,
+			Uid:      entry.uid,
+			Linkname: entry.linkname,
+		}
+		if entry.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(entry.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestSquash(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.addImage("library/foo", "latest",
+		newTarLayer(t,
+			tarEntry{name: "etc/", typeflag: tar.TypeDir},
+			tarEntry{name: "etc/deleted", typeflag: tar.TypeReg, data: "deleted"},
+			tarEntry{name: "etc/kept", typeflag: tar.TypeReg, data: "old"},
+			tarEntry{name: "opt/", typeflag: tar.TypeDir},
+			tarEntry{name: "opt/hidden", typeflag: tar.TypeReg, data: "hidden"},
+		),
+		newTarLayer(t,
+			tarEntry{name: "etc/.wh.deleted", typeflag: tar.TypeReg},
+			tarEntry{name: "./etc/kept", typeflag: tar.TypeReg, data: "new"},
+			tarEntry{name: "opt/.wh..wh..opq", typeflag: tar.TypeReg},
+			tarEntry{name: "opt/added", typeflag: tar.TypeReg, data: "added"},
+		),
+	)
+	src := reg.ref("library/foo:latest")
+	dst := reg.ref("library/foo:squashed")
+
+	pvd := newTestProvider(t)
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, src))
+	desc, err := pvd.Squash(ctx, src, dst)
+	require.NoError(t, err)
+
+	image, err := pvd.Image(ctx, dst)
+	require.NoError(t, err)
+	require.Equal(t, *desc, *image)
+
+	// The squashed image survives the deletion of source image.
+	require.NoError(t, pvd.DeleteImage(ctx, src))
+	store := pvd.ContentStore()
+	manifest, err := images.Manifest(ctx, store, *desc, nil)
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 1)
+	require.Equal(t, ocispec.MediaTypeImageLayerGzip, manifest.Layers[0].MediaType)
+
+	var config ocispec.Image
+	require.NoError(t, readJSON(ctx, store, manifest.Config, &config))
+	require.Len(t, config.RootFS.DiffIDs, 1)
+	require.Len(t, config.History, 1)
+
+	files := map[string]string{}
+	require.NoError(t, walkLayer(ctx, store, manifest.Layers[0], func(hdr *tar.Header, reader io.Reader) error {
+		data, err := io.ReadAll(reader)
+		files[hdr.Name] = string(data)
+		return err
+	}))
+	require.Equal(t, map[string]string{
+		"etc/":       "",
+		"opt/":       "",
+		"./etc/kept": "new",
+		"opt/added":  "added",
+	}, files)
+
+	_, err = pvd.Squash(ctx, src, dst)
+	require.Error(t, err)
+}
+
+func TestSquashReproducible(t *testing.T) {
+	reg := newTestRegistry(t)
+	now := time.Now()
+	reg.addImage("library/foo", "latest",
+		newTarLayer(t,
+			tarEntry{name: "etc/", typeflag: tar.TypeDir, modTime: now},
+			tarEntry{name: "etc/b", typeflag: tar.TypeReg, data: "b", modTime: now, uid: 1000},
+		),
+		newTarLayer(t,
+			tarEntry{name: "etc/a", typeflag: tar.TypeReg, data: "a", modTime: now},
+			tarEntry{name: "etc/link", typeflag: tar.TypeLink, linkname: "etc/b", modTime: now},
+		),
+	)
+	// The same file tree built in another order and time.
+	later := now.Add(time.Hour)
+	reg.addImage("library/bar", "latest",
+		newTarLayer(t,
+			tarEntry{name: "./etc/a", typeflag: tar.TypeReg, data: "a", modTime: later},
+			tarEntry{name: "./etc/link", typeflag: tar.TypeLink, linkname: "./etc/b", modTime: later},
+			tarEntry{name: "./etc/b", typeflag: tar.TypeReg, data: "b", modTime: later},
+			tarEntry{name: "./etc", typeflag: tar.TypeDir, modTime: later},
+		),
+	)
+	foo := reg.ref("library/foo:latest")
+	bar := reg.ref("library/bar:latest")
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.All, WithReproducible(true))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+	require.NoError(t, pvd.Pull(ctx, foo))
+	require.NoError(t, pvd.Pull(ctx, bar))
+
+	squash := func(src, dst string) (ocispec.Descriptor, ocispec.Descriptor) {
+		desc, err := pvd.Squash(ctx, src, dst)
+		require.NoError(t, err)
+		manifest, err := images.Manifest(ctx, pvd.ContentStore(), *desc, nil)
+		require.NoError(t, err)
+		return *desc, manifest.Layers[0]
+	}
+
+	firstImage, first := squash(foo, reg.ref("library/foo:first"))
+	secondImage, second := squash(foo, reg.ref("library/foo:second"))
+	require.Equal(t, firstImage, secondImage)
+	require.Equal(t, first, second)
+	_, other := squash(bar, reg.ref("library/bar:squashed"))
+	require.Equal(t, first, other)
+
+	var names []string
+	require.NoError(t, walkLayer(ctx, pvd.ContentStore(), first, func(hdr *tar.Header, reader io.Reader) error {
+		names = append(names, hdr.Name)
+		require.Equal(t, time.Unix(0, 0), hdr.ModTime)
+		require.Equal(t, 0, hdr.Uid)
+		return nil
+	}))
+	// The hard link is after its target.
+	require.Equal(t, []string{"etc/", "etc/a", "etc/b", "etc/link"}, names)
+}
+
+func TestSquashWithRewriter(t *testing.T) {
+	reg := newTestRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	reg.addIndex("library/foo", "latest",
+		reg.addManifest(&amd64, newTarLayer(t, tarEntry{name: "amd64", typeflag: tar.TypeReg})),
+		reg.addManifest(&arm64, newTarLayer(t, tarEntry{name: "arm64", typeflag: tar.TypeReg})),
+	)
+
+	hosts := func(ref string) (remote.CredentialFunc, bool, error) {
+		return nil, false, nil
+	}
+	rewriter := func(ref string) (string, error) {
+		return strings.Replace(ref, "docker.io/", reg.host()+"/", 1), nil
+	}
+	pvd, _, err := NewLocalProvider(t.TempDir(), hosts, platforms.OnlyStrict(amd64), WithReferenceRewriter(rewriter))
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	ctx := testContext()
+	// The image is pulled for a platform other than the provider's.
+	require.NoError(t, pvd.Pull(ctx, "docker.io/library/foo:latest", WithPullPlatform(platforms.OnlyStrict(arm64))))
+
+	desc, err := pvd.Squash(ctx, "docker.io/library/foo:latest", "docker.io/library/foo:squashed")
+	require.NoError(t, err)
+	require.Equal(t, &arm64, desc.Platform)
+	image, err := pvd.Image(ctx, reg.ref("library/foo:squashed"))
+	require.NoError(t, err)
+	require.Equal(t, *desc, *image)
+}
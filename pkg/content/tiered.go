@@ -0,0 +1,165 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DefaultMaxCachedBlobSize is the size limit of the blobs cached in memory
+// by WithMemoryCache, which covers the manifests and configs of images.
+const DefaultMaxCachedBlobSize = 1 << 20
+
+var _ content.Store = &tieredStore{}
+
+// WithMemoryCache caches the small blobs like manifests and configs read
+// from the content directory in memory, at most size bytes, the least
+// recently read blobs are evicted first.
+func WithMemoryCache(size int64) LocalProviderOpt {
+	return func(opts *LocalProviderOpts) error {
+		opts.memoryCacheSize = size
+		return nil
+	}
+}
+
+type cachedBlob struct {
+	dgst digest.Digest
+	data []byte
+}
+
+// tieredStore serves the reads of blobs from an in-memory LRU cache in
+// front of the underlying store, the blobs missed in cache are read from
+// the underlying store and cached. The writes go to the underlying store,
+// and the committed or deleted blobs are evicted from cache.
+type tieredStore struct {
+	content.Store
+	size        int64
+	maxBlobSize int64
+
+	mutex  sync.Mutex
+	used   int64
+	blobs  map[digest.Digest]*list.Element
+	recent *list.List
+}
+
+// NewTieredStore wraps store with an in-memory cache of at most size bytes,
+// only the blobs of at most maxBlobSize bytes are cached.
+func NewTieredStore(store content.Store, size, maxBlobSize int64) content.Store {
+	return &tieredStore{
+		Store:       store,
+		size:        size,
+		maxBlobSize: maxBlobSize,
+		blobs:       map[digest.Digest]*list.Element{},
+		recent:      list.New(),
+	}
+}
+
+func (s *tieredStore) get(dgst digest.Digest) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	elem, ok := s.blobs[dgst]
+	if !ok {
+		return nil, false
+	}
+	s.recent.MoveToFront(elem)
+	return elem.Value.(*cachedBlob).data, true
+}
+
+func (s *tieredStore) add(dgst digest.Digest, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.blobs[dgst]; ok {
+		return
+	}
+	s.blobs[dgst] = s.recent.PushFront(&cachedBlob{dgst: dgst, data: data})
+	s.used += int64(len(data))
+	for s.used > s.size {
+		s.removeElement(s.recent.Back())
+	}
+}
+
+func (s *tieredStore) remove(dgst digest.Digest) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if elem, ok := s.blobs[dgst]; ok {
+		s.removeElement(elem)
+	}
+}
+
+func (s *tieredStore) removeElement(elem *list.Element) {
+	blob := s.recent.Remove(elem).(*cachedBlob)
+	delete(s.blobs, blob.dgst)
+	s.used -= int64(len(blob.data))
+}
+
+func (s *tieredStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	if data, ok := s.get(desc.Digest); ok {
+		return &memoryReaderAt{bytes.NewReader(data)}, nil
+	}
+	if desc.Size <= 0 || desc.Size > s.maxBlobSize || desc.Size > s.size {
+		return s.Store.ReaderAt(ctx, desc)
+	}
+
+	ra, err := s.Store.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer ra.Close()
+	// The size of blob in store prevails over the descriptor.
+	if ra.Size() > s.maxBlobSize {
+		return s.Store.ReaderAt(ctx, desc)
+	}
+	data := make([]byte, ra.Size())
+	if n, err := ra.ReadAt(data, 0); err != nil && !(err == io.EOF && n == len(data)) {
+		return nil, err
+	}
+	s.add(desc.Digest, data)
+	return &memoryReaderAt{bytes.NewReader(data)}, nil
+}
+
+func (s *tieredStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	s.remove(dgst)
+	return s.Store.Delete(ctx, dgst)
+}
+
+func (s *tieredStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	w, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &tieredWriter{Writer: w, store: s}, nil
+}
+
+type tieredWriter struct {
+	content.Writer
+	store *tieredStore
+}
+
+func (w *tieredWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	dgst := expected
+	if dgst == "" {
+		dgst = w.Writer.Digest()
+	}
+	w.store.remove(dgst)
+	return w.Writer.Commit(ctx, size, expected, opts...)
+}
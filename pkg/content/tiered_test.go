@@ -0,0 +1,79 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the reads of blobs from the underlying store.
+type countingStore struct {
+	content.Store
+	reads int32
+}
+
+func (s *countingStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	atomic.AddInt32(&s.reads, 1)
+	return s.Store.ReaderAt(ctx, desc)
+}
+
+func TestTieredStore(t *testing.T) {
+	l2 := &countingStore{Store: newMemoryStore()}
+	store := NewTieredStore(l2, 16, 8)
+	ctx := context.Background()
+	write := func(data string) ocispec.Descriptor {
+		desc := ocispec.Descriptor{Digest: digest.FromString(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, store, data, strings.NewReader(data), desc))
+		return desc
+	}
+	read := func(desc ocispec.Descriptor) int32 {
+		before := atomic.LoadInt32(&l2.reads)
+		data, err := content.ReadBlob(ctx, store, desc)
+		require.NoError(t, err)
+		require.Equal(t, desc.Digest, digest.FromBytes(data))
+		return atomic.LoadInt32(&l2.reads) - before
+	}
+
+	// The second read of small blob is served from cache.
+	small := write("small-1")
+	require.Equal(t, int32(1), read(small))
+	require.Equal(t, int32(0), read(small))
+
+	// The large blob isn't cached.
+	large := write("large-blob")
+	require.Equal(t, int32(1), read(large))
+	require.Equal(t, int32(1), read(large))
+
+	// The least recently read blob is evicted.
+	other := write("small-2")
+	require.Equal(t, int32(1), read(other))
+	require.Equal(t, int32(0), read(small))
+	require.Equal(t, int32(1), read(write("small-3")))
+	require.Equal(t, int32(1), read(other))
+
+	// The deleted blob is evicted.
+	require.NoError(t, store.Delete(ctx, small.Digest))
+	_, err := content.ReadBlob(ctx, store, small)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
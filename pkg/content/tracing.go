@@ -0,0 +1,133 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"io"
+
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans created by provider.
+const tracerName = "github.com/goharbor/acceleration-service/pkg/content"
+
+// SetTracerProvider enables the provider to create the spans of Pull,
+// Push, resolving and blob fetches as the children of the span in context,
+// the spans are not recorded if it's not set.
+func (pvd *LocalProvider) SetTracerProvider(tp trace.TracerProvider) {
+	pvd.tracer = tp.Tracer(tracerName)
+}
+
+// endSpan records the error of operation and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func descAttributes(desc ocispec.Descriptor) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("digest", desc.Digest.String()),
+		attribute.String("media_type", desc.MediaType),
+		attribute.Int64("size", desc.Size),
+	}
+}
+
+// tracingResolver creates the spans of resolving and blob fetches.
+type tracingResolver struct {
+	remotes.Resolver
+	tracer trace.Tracer
+}
+
+func newTracingResolver(resolver remotes.Resolver, tracer trace.Tracer) remotes.Resolver {
+	return &tracingResolver{
+		Resolver: resolver,
+		tracer:   tracer,
+	}
+}
+
+func (resolver *tracingResolver) Resolve(ctx context.Context, ref string) (string, ocispec.Descriptor, error) {
+	ctx, span := resolver.tracer.Start(ctx, "content.Resolve", trace.WithAttributes(attribute.String("ref", ref)))
+	name, desc, err := resolver.Resolver.Resolve(ctx, ref)
+	if err == nil {
+		span.SetAttributes(descAttributes(desc)...)
+	}
+	endSpan(span, err)
+	return name, desc, err
+}
+
+func (resolver *tracingResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := resolver.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		ctx, span := resolver.tracer.Start(ctx, "content.Fetch", trace.WithAttributes(descAttributes(desc)...))
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+		reader := &tracingReader{
+			ReadCloser: rc,
+			span:       span,
+		}
+		if _, ok := rc.(io.Seeker); ok {
+			return &tracingReadSeeker{reader}, nil
+		}
+		return reader, nil
+	}), nil
+}
+
+// tracingReader counts the fetched bytes, the span is ended on Close.
+type tracingReader struct {
+	io.ReadCloser
+	span    trace.Span
+	fetched int64
+	err     error
+}
+
+func (reader *tracingReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	reader.fetched += int64(n)
+	if err != nil && err != io.EOF {
+		reader.err = err
+	}
+	return n, err
+}
+
+func (reader *tracingReader) Close() error {
+	err := reader.ReadCloser.Close()
+	reader.span.SetAttributes(attribute.Int64("fetched_bytes", reader.fetched))
+	endSpan(reader.span, reader.err)
+	return err
+}
+
+// tracingReadSeeker keeps the seeker of fetched blob, which is used to
+// resume the download from the offset of partially written blob.
+type tracingReadSeeker struct {
+	*tracingReader
+}
+
+func (reader *tracingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return reader.ReadCloser.(io.Seeker).Seek(offset, whence)
+}
@@ -0,0 +1,165 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanRecorder is an in-memory tracer recording the started spans.
+type spanRecorder struct {
+	mutex  sync.Mutex
+	spans  []*recordedSpan
+	nextID byte
+}
+
+type recordedSpan struct {
+	// The span is embedded for the methods not recorded.
+	trace.Span
+	recorder *spanRecorder
+	name     string
+	parent   trace.SpanID
+	context  trace.SpanContext
+	attrs    map[attribute.Key]attribute.Value
+	status   codes.Code
+	ended    bool
+}
+
+func (r *spanRecorder) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return r
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.nextID++
+	span := &recordedSpan{
+		Span:     trace.SpanFromContext(context.Background()),
+		recorder: r,
+		name:     name,
+		parent:   trace.SpanContextFromContext(ctx).SpanID(),
+		context: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{1},
+			SpanID:  trace.SpanID{r.nextID},
+		}),
+		attrs: map[attribute.Key]attribute.Value{},
+	}
+	config := trace.NewSpanStartConfig(opts...)
+	for _, attr := range config.Attributes() {
+		span.attrs[attr.Key] = attr.Value
+	}
+	r.spans = append(r.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (r *spanRecorder) find(name string) []*recordedSpan {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var spans []*recordedSpan
+	for _, span := range r.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func (s *recordedSpan) SpanContext() trace.SpanContext {
+	return s.context
+}
+
+func (s *recordedSpan) IsRecording() bool {
+	return true
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...attribute.KeyValue) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, description string) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	s.status = code
+}
+
+func (s *recordedSpan) End(opts ...trace.SpanEndOption) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	s.ended = true
+}
+
+func TestTracing(t *testing.T) {
+	reg := newTestRegistry(t)
+	layers := [][]byte{[]byte("foo-layer-1"), []byte("foo-layer-2")}
+	reg.addImage("library/foo", "latest", layers...)
+	ref := reg.ref("library/foo:latest")
+
+	pvd := newTestProvider(t)
+	recorder := &spanRecorder{}
+	pvd.SetTracerProvider(recorder)
+	ctx, parent := recorder.Start(testContext(), "convert")
+	require.NoError(t, pvd.Pull(ctx, ref))
+	parent.End()
+
+	pulls := recorder.find("content.Pull")
+	require.Len(t, pulls, 1)
+	pull := pulls[0]
+	require.True(t, pull.ended)
+	require.Equal(t, parent.SpanContext().SpanID(), pull.parent)
+	require.Equal(t, ref, pull.attrs["ref"].AsString())
+	desc, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, desc.Digest.String(), pull.attrs["digest"].AsString())
+
+	resolves := recorder.find("content.Resolve")
+	require.NotEmpty(t, resolves)
+	require.Equal(t, pull.context.SpanID(), resolves[0].parent)
+
+	// There are the fetches of manifest, config and layers.
+	fetches := recorder.find("content.Fetch")
+	require.Len(t, fetches, len(layers)+2)
+	fetched := map[string]int64{}
+	for _, fetch := range fetches {
+		require.True(t, fetch.ended)
+		require.Equal(t, pull.context.SpanID(), fetch.parent)
+		fetched[fetch.attrs["digest"].AsString()] = fetch.attrs["fetched_bytes"].AsInt64()
+	}
+	for _, layer := range layers {
+		require.Equal(t, int64(len(layer)), fetched[digest.FromBytes(layer).String()])
+	}
+
+	// The failed operation is recorded in span.
+	reg.setHook(func(w http.ResponseWriter, r *http.Request) bool {
+		w.WriteHeader(http.StatusNotFound)
+		return true
+	})
+	require.Error(t, pvd.Push(ctx, *desc, reg.ref("library/bar:latest")))
+	pushes := recorder.find("content.Push")
+	require.Len(t, pushes, 1)
+	require.Equal(t, codes.Error, pushes[0].status)
+}
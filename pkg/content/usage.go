@@ -0,0 +1,105 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// UnknownMediaType is the media type of blobs which aren't referenced by
+// any image, such as the blobs to be garbage collected.
+const UnknownMediaType = "unknown"
+
+// Usage returns the total size in bytes of the blobs stored on disk,
+// including the blobs not garbage collected yet.
+func (pvd *LocalProvider) Usage(ctx context.Context) (int64, error) {
+	if pvd.isClosed() {
+		return 0, ErrClosed
+	}
+	var size int64
+	if err := pvd.backend.Walk(ctx, func(info content.Info) error {
+		size += info.Size
+		return nil
+	}); err != nil {
+		return 0, errors.Wrap(err, "walk content store")
+	}
+	return size, nil
+}
+
+// UsageByMediaType returns the size in bytes of the blobs stored on disk
+// by their media types.
+func (pvd *LocalProvider) UsageByMediaType(ctx context.Context) (map[string]int64, error) {
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	mediaTypes, err := pvd.mediaTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string]int64{}
+	if err := pvd.backend.Walk(ctx, func(info content.Info) error {
+		mediaType, ok := mediaTypes[info.Digest]
+		if !ok {
+			mediaType = UnknownMediaType
+		}
+		usage[mediaType] += info.Size
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "walk content store")
+	}
+
+	return usage, nil
+}
+
+// mediaTypes collects the media types of blobs referenced by images.
+func (pvd *LocalProvider) mediaTypes(ctx context.Context) (map[digest.Digest]string, error) {
+	pvd.mutex.Lock()
+	targets := make([]ocispec.Descriptor, 0, len(pvd.images))
+	for _, desc := range pvd.images {
+		targets = append(targets, *desc)
+	}
+	pvd.mutex.Unlock()
+
+	mediaTypes := map[digest.Digest]string{}
+	childrenHandler := artifactChildrenHandler(pvd.backend)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, ok := mediaTypes[desc.Digest]; ok {
+			return nil, nil
+		}
+		mediaTypes[desc.Digest] = desc.MediaType
+		children, err := childrenHandler(ctx, desc)
+		// The manifests of other platforms are not pulled.
+		if errdefs.IsNotFound(err) {
+			return nil, nil
+		}
+		return children, err
+	})
+
+	for _, target := range targets {
+		if err := images.Walk(ctx, handler, target); err != nil {
+			return nil, errors.Wrapf(err, "walk image %s", target.Digest)
+		}
+	}
+
+	return mediaTypes, nil
+}
@@ -0,0 +1,153 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// SetVerifyOnPull enables Pull to re-read the blobs of pulled image from
+// content store and verify their digests, the corrupted blobs are deleted
+// so that they can be fetched again. It's disabled by default since all
+// the blobs are read once more.
+func (pvd *LocalProvider) SetVerifyOnPull(enabled bool) {
+	pvd.verifyOnPull = enabled
+}
+
+type VerifyOpts struct {
+	repair bool
+}
+
+type VerifyOpt func(opts *VerifyOpts) error
+
+// WithRepair deletes the corrupted blobs found by Verify, so that they
+// can be fetched again by Pull.
+func WithRepair() VerifyOpt {
+	return func(opts *VerifyOpts) error {
+		opts.repair = true
+		return nil
+	}
+}
+
+// Verify reads every blob in content store and checks its digest and
+// size like fsck, e.g. after an unclean shutdown, and returns the digests
+// of corrupted blobs, which are deleted if WithRepair is specified.
+func (pvd *LocalProvider) Verify(ctx context.Context, opts ...VerifyOpt) ([]digest.Digest, error) {
+	var options VerifyOpts
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return nil, errors.Wrap(err, "apply verify option")
+		}
+	}
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	if options.repair && pvd.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	pvd.gcMutex.Lock()
+	defer pvd.gcMutex.Unlock()
+
+	store := *pvd.store
+	var infos []content.Info
+	if err := store.Walk(ctx, func(info content.Info) error {
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "walk content store")
+	}
+
+	corrupted := []digest.Digest{}
+	for _, info := range infos {
+		desc := ocispec.Descriptor{Digest: info.Digest, Size: info.Size}
+		if err := verifyBlob(ctx, store, desc); err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			pvd.logger.Warnf("verify content store: %s", err)
+			corrupted = append(corrupted, info.Digest)
+		}
+	}
+
+	if options.repair {
+		for _, dgst := range corrupted {
+			// The blob in backend is reused by metadata database if it's
+			// not deleted, see verifyImage.
+			for _, s := range []content.Store{store, pvd.backend} {
+				if err := s.Delete(ctx, dgst); err != nil && !errdefs.IsNotFound(err) {
+					return corrupted, errors.Wrapf(err, "delete corrupted blob %s", dgst)
+				}
+			}
+		}
+	}
+
+	return corrupted, nil
+}
+
+// verifyImage verifies all the blobs of image matched by platform.
+func (pvd *LocalProvider) verifyImage(ctx context.Context, desc ocispec.Descriptor, platformMC platforms.MatchComparer) error {
+	store := *pvd.store
+	verifyHandler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if err := verifyBlob(ctx, store, desc); err != nil {
+			// The blob in backend is reused by metadata database if it's
+			// not deleted, which makes the corruption permanent.
+			for _, s := range []content.Store{store, pvd.backend} {
+				if delErr := s.Delete(ctx, desc.Digest); delErr != nil && !errdefs.IsNotFound(delErr) {
+					return nil, errors.Wrapf(delErr, "delete corrupted blob %s", desc.Digest)
+				}
+			}
+			return nil, err
+		}
+		return nil, nil
+	})
+	childrenHandler := images.FilterPlatforms(artifactChildrenHandler(store), platformMC)
+
+	return images.Walk(ctx, images.Handlers(verifyHandler, childrenHandler), desc)
+}
+
+// verifyBlob reads the blob from content store and checks its digest and
+// size, the blob is read by stream to avoid loading large layers into memory.
+func verifyBlob(ctx context.Context, store content.Store, desc ocispec.Descriptor) error {
+	ra, err := store.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrapf(err, "open blob %s", desc.Digest)
+	}
+	defer ra.Close()
+
+	digester := desc.Digest.Algorithm().Digester()
+	size, err := io.Copy(digester.Hash(), content.NewReader(ra))
+	if err != nil {
+		return errors.Wrapf(err, "read blob %s", desc.Digest)
+	}
+	if size != desc.Size {
+		return fmt.Errorf("blob %s is corrupted, expected size %d, got %d", desc.Digest, desc.Size, size)
+	}
+	if actual := digester.Digest(); actual != desc.Digest {
+		return fmt.Errorf("blob %s is corrupted, got digest %s", desc.Digest, actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Walk returns the descriptors reachable from image ref, that is, the
+// index and the manifests of all platforms, the configs and the layers,
+// each of them is returned once in walking order.
+//
+// The pulled image is walked in content store without network access,
+// where the children of manifests not pulled, e.g. of other platforms, are
+// omitted. Otherwise the image is resolved and the indexes and manifests
+// are fetched and cached in content store, but the layers aren't.
+func (pvd *LocalProvider) Walk(ctx context.Context, ref string) ([]ocispec.Descriptor, error) {
+	if pvd.isClosed() {
+		return nil, ErrClosed
+	}
+	ref, err := pvd.rewriteRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	pvd.gcMutex.RLock()
+	defer pvd.gcMutex.RUnlock()
+
+	var (
+		provider content.Provider = *pvd.store
+		local                     = true
+	)
+	desc, err := pvd.getImage(ctx, ref)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+		resolver, err := pvd.newResolver(ref)
+		if err != nil {
+			return nil, err
+		}
+		name, target, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve reference %s", ref)
+		}
+		fetcher, err := resolver.Fetcher(ctx, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get fetcher for %s", name)
+		}
+		provider = &fetchingProvider{store: *pvd.store, fetcher: fetcher}
+		desc, local = &target, false
+	}
+
+	var descs []ocispec.Descriptor
+	seen := map[digest.Digest]struct{}{}
+	childrenHandler := artifactChildrenHandler(provider)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, ok := seen[desc.Digest]; ok {
+			return nil, nil
+		}
+		seen[desc.Digest] = struct{}{}
+		descs = append(descs, desc)
+		children, err := childrenHandler(ctx, desc)
+		if local && errdefs.IsNotFound(err) {
+			return nil, nil
+		}
+		return children, err
+	})
+	if err := images.Walk(ctx, handler, *desc); err != nil {
+		return nil, errors.Wrapf(err, "walk image %s", ref)
+	}
+
+	return descs, nil
+}
@@ -98,6 +98,11 @@ func (cvt *Converter) Convert(ctx context.Context, source, target string) (*Metr
 	source = sourceNamed.String()
 	target = targetNamed.String()
 
+	// Prevent the source image from being evicted during conversion.
+	if acquirer, ok := cvt.provider.(content.Acquirer); ok {
+		defer acquirer.Acquire(source)()
+	}
+
 	logger.Infof("pulling image %s", source)
 	start := time.Now()
 	if err := cvt.pull(ctx, source); err != nil {
@@ -16,6 +16,7 @@ package metrics
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -25,6 +26,16 @@ var subsystem = "acceleration_service_core"
 
 var Conversion ConversionMetric
 
+// WorkerQueueDepth is the number of conversions dispatched but not yet
+// picked up by workers.
+var WorkerQueueDepth = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Subsystem: subsystem,
+		Name:      "worker_queue_depth",
+		Help:      "How many conversions are waiting for workers.",
+	},
+)
+
 type OpWrapper struct {
 	OpDuration   *prometheus.HistogramVec
 	OpTotal      *prometheus.CounterVec
@@ -43,9 +54,165 @@ func init() {
 		Conversion.OpDuration,
 		Conversion.OpTotal,
 		Conversion.OpErrorTotal,
+		WorkerQueueDepth,
 	)
 }
 
+// TransferMetric measures the data transferred between the content
+// provider and registries, labeled by direction (pull or push) and
+// registry host.
+type TransferMetric struct {
+	Bytes    *prometheus.CounterVec
+	Duration *prometheus.HistogramVec
+}
+
+// NewTransferMetric creates the transfer metrics and registers them
+// into the registry.
+func NewTransferMetric(registry *prometheus.Registry) (*TransferMetric, error) {
+	labelNames := []string{"direction", "host"}
+	metric := &TransferMetric{
+		Bytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: subsystem,
+				Name:      "transferred_bytes_total",
+				Help:      "The bytes transferred from or to registries.",
+			},
+			labelNames,
+		),
+		Duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem: subsystem,
+				Name:      "transfer_duration_seconds",
+				Help:      "The latency of pulls and pushes in seconds, including the failed ones.",
+				Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+			},
+			labelNames,
+		),
+	}
+	for _, collector := range []prometheus.Collector{metric.Bytes, metric.Duration} {
+		if err := registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return metric, nil
+}
+
+// ObserveDuration records the elapsed time since start whether the
+// operation succeeds or not.
+func (metric *TransferMetric) ObserveDuration(start time.Time, direction, host string) {
+	elapsed := float64(time.Since(start)) / float64(time.Second)
+	metric.Duration.WithLabelValues(direction, host).Observe(elapsed)
+}
+
+// CacheMetric counts the pulls served from the local content store (hits)
+// and from registries (misses), labeled by level (image or layer).
+type CacheMetric struct {
+	Hits   *prometheus.CounterVec
+	Misses *prometheus.CounterVec
+}
+
+// NewCacheMetric creates the cache metrics and registers them into the
+// registry.
+func NewCacheMetric(registry *prometheus.Registry) (*CacheMetric, error) {
+	labelNames := []string{"level"}
+	metric := &CacheMetric{
+		Hits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: subsystem,
+				Name:      "pull_cache_hits_total",
+				Help:      "How many images or layers of pulls are served from the local content store.",
+			},
+			labelNames,
+		),
+		Misses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: subsystem,
+				Name:      "pull_cache_misses_total",
+				Help:      "How many images or layers of pulls are fetched from registries.",
+			},
+			labelNames,
+		),
+	}
+	for _, collector := range []prometheus.Collector{metric.Hits, metric.Misses} {
+		if err := registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return metric, nil
+}
+
+// Observe counts a hit or miss of level.
+func (metric *CacheMetric) Observe(level string, hit bool) {
+	if hit {
+		metric.Hits.WithLabelValues(level).Inc()
+	} else {
+		metric.Misses.WithLabelValues(level).Inc()
+	}
+}
+
+// InFlightMetric measures the pulls and pushes being executed by the
+// content provider, labeled by operation (pull or push).
+type InFlightMetric struct {
+	Operations *prometheus.GaugeVec
+}
+
+// NewInFlightMetric creates the in-flight metrics and registers them into
+// the registry.
+func NewInFlightMetric(registry *prometheus.Registry) (*InFlightMetric, error) {
+	metric := &InFlightMetric{
+		Operations: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: subsystem,
+				Name:      "in_flight_operations",
+				Help:      "How many pulls or pushes are being executed.",
+			},
+			[]string{"op"},
+		),
+	}
+	if err := registry.Register(metric.Operations); err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
+
+// Track counts an operation of op in flight until the returned function
+// is called.
+func (metric *InFlightMetric) Track(op string) func() {
+	gauge := metric.Operations.WithLabelValues(op)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// StatusMetric counts the responses received from registries, labeled by
+// registry host and HTTP status code.
+type StatusMetric struct {
+	Responses *prometheus.CounterVec
+}
+
+// NewStatusMetric creates the status metrics and registers them into the
+// registry.
+func NewStatusMetric(registry *prometheus.Registry) (*StatusMetric, error) {
+	metric := &StatusMetric{
+		Responses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: subsystem,
+				Name:      "registry_responses_total",
+				Help:      "How many responses are received from registries by status code.",
+			},
+			[]string{"host", "code"},
+		),
+	}
+	if err := registry.Register(metric.Responses); err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
+
+// Observe counts a response of host with the status code.
+func (metric *StatusMetric) Observe(host string, statusCode int) {
+	metric.Responses.WithLabelValues(host, strconv.Itoa(statusCode)).Inc()
+}
+
 func NewOpWrapper(scope string, labelNames []string) *OpWrapper {
 	return &OpWrapper{
 		OpDuration: prometheus.NewHistogramVec(
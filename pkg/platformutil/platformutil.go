@@ -51,7 +51,49 @@ func NewMatchComparer(all bool, ss []string) (platforms.MatchComparer, error) {
 		return platforms.DefaultStrict(), nil
 	}
 	op, err := NewOCISpecPlatformSlice(false, ss)
-	return platforms.Ordered(op...), err
+	return NewStrictMatchComparer(op...), err
+}
+
+// NewStrictMatchComparer matches the platforms by the full triple of OS,
+// architecture and variant, unlike platforms.Ordered, the compatible
+// variants like arm64/v7 for arm64/v8 are not matched. The OS version is
+// also matched if it's specified, e.g. for Windows. The platforms are
+// preferred in order.
+func NewStrictMatchComparer(ps ...ocispec.Platform) platforms.MatchComparer {
+	normalized := make([]ocispec.Platform, 0, len(ps))
+	for _, p := range ps {
+		normalized = append(normalized, platforms.Normalize(p))
+	}
+	return &strictMatchComparer{platforms: normalized}
+}
+
+type strictMatchComparer struct {
+	platforms []ocispec.Platform
+}
+
+func (m *strictMatchComparer) index(platform ocispec.Platform) int {
+	platform = platforms.Normalize(platform)
+	for idx, p := range m.platforms {
+		if p.OS == platform.OS &&
+			p.Architecture == platform.Architecture &&
+			p.Variant == platform.Variant &&
+			(p.OSVersion == "" || p.OSVersion == platform.OSVersion) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (m *strictMatchComparer) Match(platform ocispec.Platform) bool {
+	return m.index(platform) >= 0
+}
+
+func (m *strictMatchComparer) Less(p1, p2 ocispec.Platform) bool {
+	idx1, idx2 := m.index(p1), m.index(p2)
+	if idx1 < 0 {
+		return false
+	}
+	return idx2 < 0 || idx1 < idx2
 }
 
 // Ported from nerdctl project, copyright The containerd Authors.
@@ -0,0 +1,49 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"net/http"
+	"strings"
+)
+
+// WithManifestAccepted calls fn with the manifest push answered by 202
+// Accepted, that is, the registry makes the manifest visible later. The
+// response is handled as 201 Created, as the containerd pusher rejects
+// 202 Accepted for manifests.
+func WithManifestAccepted(fn func(req *http.Request)) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.manifestAccepted = fn
+	}
+}
+
+type acceptedTransport struct {
+	transport http.RoundTripper
+	accepted  func(req *http.Request)
+}
+
+func (t *acceptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusAccepted && req.Method == http.MethodPut &&
+		strings.Contains(req.URL.Path, "/manifests/") {
+		t.accepted(req)
+		resp.StatusCode = http.StatusCreated
+		resp.Status = "201 Created"
+	}
+	return resp, nil
+}
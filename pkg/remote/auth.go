@@ -0,0 +1,158 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/sirupsen/logrus"
+)
+
+// refreshAuthorizer wraps the docker authorizer to refresh credentials,
+// the docker authorizer caches the token and never calls credential
+// function again after the first authorization of a host, so the transfer
+// fails once the short-lived token expires. refreshAuthorizer recreates the
+// docker authorizer on an unauthorized response of an authorized request,
+// then the credential function is called again to fetch a fresh token.
+type refreshAuthorizer struct {
+	mutex         sync.Mutex
+	authorizer    docker.Authorizer
+	newAuthorizer func() docker.Authorizer
+	// The expired authorization which has been refreshed, avoid to refresh
+	// again for the concurrent requests with the same authorization.
+	refreshed string
+}
+
+func newRefreshAuthorizer(newAuthorizer func() docker.Authorizer) docker.Authorizer {
+	return &refreshAuthorizer{
+		authorizer:    newAuthorizer(),
+		newAuthorizer: newAuthorizer,
+	}
+}
+
+func (a *refreshAuthorizer) current() docker.Authorizer {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.authorizer
+}
+
+func (a *refreshAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	return a.current().Authorize(ctx, req)
+}
+
+func (a *refreshAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	last := responses[len(responses)-1]
+	authorization := last.Request.Header.Get("Authorization")
+	if last.StatusCode == http.StatusUnauthorized &&
+		authorization != "" &&
+		countUnauthorized(responses) == 1 {
+		a.mutex.Lock()
+		if a.refreshed != authorization {
+			a.authorizer = a.newAuthorizer()
+			a.refreshed = authorization
+		}
+		a.mutex.Unlock()
+	}
+	return a.current().AddResponses(ctx, responses)
+}
+
+func countUnauthorized(responses []*http.Response) int {
+	count := 0
+	for _, resp := range responses {
+		if resp.StatusCode == http.StatusUnauthorized {
+			count++
+		}
+	}
+	return count
+}
+
+// anonymousAuthorizer falls back to access the registry anonymously once
+// the authorization with credential fails, it's useful for the public
+// images on an authenticated registry. Only the pull requests (GET and
+// HEAD) fall back, the others are always authorized with credential.
+type anonymousAuthorizer struct {
+	authorizer docker.Authorizer
+	anonymous  docker.Authorizer
+
+	mutex sync.Mutex
+	// The hosts to be accessed anonymously.
+	hosts map[string]bool
+}
+
+func newAnonymousAuthorizer(authorizer, anonymous docker.Authorizer) docker.Authorizer {
+	return &anonymousAuthorizer{
+		authorizer: authorizer,
+		anonymous:  anonymous,
+		hosts:      map[string]bool{},
+	}
+}
+
+func isPullRequest(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+func (a *anonymousAuthorizer) isAnonymous(host string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.hosts[host]
+}
+
+func (a *anonymousAuthorizer) fallback(host string, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !a.hosts[host] {
+		logrus.Warnf("authorization for %s failed, fall back to anonymous access: %s", host, err)
+		a.hosts[host] = true
+	}
+}
+
+func (a *anonymousAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	if !isPullRequest(req) {
+		return a.authorizer.Authorize(ctx, req)
+	}
+	if a.isAnonymous(req.URL.Host) {
+		return a.anonymous.Authorize(ctx, req)
+	}
+
+	err := a.authorizer.Authorize(ctx, req)
+	if err == nil {
+		return nil
+	}
+	a.fallback(req.URL.Host, err)
+
+	return a.anonymous.Authorize(ctx, req)
+}
+
+func (a *anonymousAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	req := responses[len(responses)-1].Request
+	if !isPullRequest(req) {
+		return a.authorizer.AddResponses(ctx, responses)
+	}
+	if a.isAnonymous(req.URL.Host) {
+		return a.anonymous.AddResponses(ctx, responses)
+	}
+
+	// The authorizer refuses to retry if the credential is invalid.
+	err := a.authorizer.AddResponses(ctx, responses)
+	if err == nil || req.Header.Get("Authorization") == "" {
+		return err
+	}
+	a.fallback(req.URL.Host, err)
+
+	return a.anonymous.AddResponses(ctx, responses)
+}
@@ -0,0 +1,199 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCircuitCooldown is the cooldown of open circuit if it's not
+// specified by CircuitBreakerConfig.
+const DefaultCircuitCooldown = 30 * time.Second
+
+// ErrCircuitOpen is returned for the requests to the registry host whose
+// circuit is open, without sending the requests.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitBreakerConfig configures the circuit breaker of registry hosts.
+type CircuitBreakerConfig struct {
+	// Threshold is the consecutive failures to open the circuit of host,
+	// the circuit breaker is disabled if it's <= 0.
+	Threshold int
+	// Window is the duration in which the consecutive failures are
+	// counted, the failures are counted without time limit if it's <= 0.
+	Window time.Duration
+	// Cooldown is the duration of open circuit before a probe request
+	// is allowed, defaults to DefaultCircuitCooldown if it's <= 0.
+	Cooldown time.Duration
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	// circuitHalfOpen allows only the probe request.
+	circuitHalfOpen
+)
+
+type circuit struct {
+	state       circuitState
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+}
+
+// CircuitBreaker fails the requests to registry host fast with
+// ErrCircuitOpen once the consecutive failures (network errors and 5xx
+// responses) reach the threshold. After the cooldown, a probe request is
+// sent, the circuit is closed if it succeeds, otherwise it's opened again.
+// It's shared by the resolvers created with the same breaker.
+type CircuitBreaker struct {
+	cfg      CircuitBreakerConfig
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+// NewCircuitBreaker creates a circuit breaker of registry hosts.
+func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = DefaultCircuitCooldown
+	}
+	return &CircuitBreaker{
+		cfg:      cfg,
+		circuits: map[string]*circuit{},
+		now:      time.Now,
+	}
+}
+
+// WithCircuitBreaker short-circuits the requests to the unhealthy
+// registry hosts by breaker.
+func WithCircuitBreaker(breaker *CircuitBreaker) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.circuitBreaker = breaker
+	}
+}
+
+func (b *CircuitBreaker) circuit(host string) *circuit {
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{}
+		b.circuits[host] = c
+	}
+	return c
+}
+
+// allow returns whether the request to host can be sent, and whether the
+// request is the probe of half-open circuit.
+func (b *CircuitBreaker) allow(host string) (bool, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuit(host)
+	switch c.state {
+	case circuitOpen:
+		if b.now().Sub(c.openedAt) < b.cfg.Cooldown {
+			return false, false
+		}
+		c.state = circuitHalfOpen
+		return true, true
+	case circuitHalfOpen:
+		// The probe is in flight.
+		return false, false
+	}
+	return true, false
+}
+
+func (b *CircuitBreaker) success(host string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuit(host)
+	c.state = circuitClosed
+	c.failures = 0
+}
+
+func (b *CircuitBreaker) failure(host string, probe bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuit(host)
+	now := b.now()
+	if probe {
+		c.state = circuitOpen
+		c.openedAt = now
+		return
+	}
+	if c.state != circuitClosed {
+		return
+	}
+	if c.failures == 0 || (b.cfg.Window > 0 && now.Sub(c.windowStart) > b.cfg.Window) {
+		c.failures = 0
+		c.windowStart = now
+	}
+	c.failures++
+	if c.failures >= b.cfg.Threshold {
+		c.state = circuitOpen
+		c.openedAt = now
+		c.failures = 0
+	}
+}
+
+// cancel allows another probe if the probe is canceled by caller, which
+// tells nothing about the health of host.
+func (b *CircuitBreaker) cancel(host string, probe bool) {
+	if !probe {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuit(host)
+	if c.state == circuitHalfOpen {
+		c.state = circuitOpen
+		c.openedAt = b.now().Add(-b.cfg.Cooldown)
+	}
+}
+
+type circuitBreakerTransport struct {
+	transport http.RoundTripper
+	breaker   *CircuitBreaker
+}
+
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	allowed, probe := t.breaker.allow(host)
+	if !allowed {
+		return nil, errors.Wrapf(ErrCircuitOpen, "registry %s is unhealthy", host)
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	switch {
+	case err != nil && (errors.Is(err, context.Canceled) || req.Context().Err() != nil):
+		t.breaker.cancel(host, probe)
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		t.breaker.failure(host, probe)
+	default:
+		t.breaker.success(host)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,119 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	now := time.Now()
+	advance := func(d time.Duration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now = now.Add(d)
+	}
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{
+		Threshold: 3,
+		Window:    time.Minute,
+		Cooldown:  10 * time.Second,
+	})
+	breaker.now = func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	}
+	client := NewClient(false, WithCircuitBreaker(breaker))
+	get := func() (int, error) {
+		resp, err := client.Get(server.URL + "/v2/")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	// The failures out of window are not consecutive.
+	for i := 0; i < 2; i++ {
+		status, err := get()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusServiceUnavailable, status)
+	}
+	advance(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		_, err := get()
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(5), requests.Load())
+
+	// The circuit is open during cooldown.
+	_, err := get()
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, int32(5), requests.Load())
+
+	// The failed probe opens the circuit again.
+	advance(10 * time.Second)
+	_, err = get()
+	require.NoError(t, err)
+	require.Equal(t, int32(6), requests.Load())
+	_, err = get()
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// The successful probe closes the circuit.
+	healthy.Store(true)
+	advance(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		status, err := get()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+	}
+	require.Equal(t, int32(9), requests.Load())
+
+	// The circuits are isolated by host.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer other.Close()
+	healthy.Store(false)
+	for i := 0; i < 3; i++ {
+		_, err := get()
+		require.NoError(t, err)
+	}
+	_, err = get()
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	resp, err := client.Get(other.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
@@ -0,0 +1,187 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+)
+
+// maxChunkRetries limits the number of resumes of each interrupted chunk.
+const maxChunkRetries = 3
+
+// WithChunkedUpload uploads the blobs larger than chunkSize in chunks by
+// the PATCH requests of distribution API rather than a single PUT request,
+// an interrupted chunk is resumed from the offset acknowledged by registry
+// instead of uploading the whole blob again. It's disabled if chunkSize <= 0.
+func WithChunkedUpload(chunkSize int64) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.chunkSize = chunkSize
+	}
+}
+
+// chunkedTransport splits the monolithic PUT request of blob upload sent
+// by the docker pusher into the PATCH requests of chunks and a final PUT
+// request without body, see also:
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#pushing-a-blob-in-chunks
+type chunkedTransport struct {
+	transport http.RoundTripper
+	chunkSize int64
+}
+
+func (t *chunkedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPut || !strings.Contains(req.URL.Path, "/blobs/uploads/") ||
+		req.Body == nil || req.ContentLength <= t.chunkSize {
+		return t.transport.RoundTrip(req)
+	}
+	defer req.Body.Close()
+
+	location := *req.URL
+	query := location.Query()
+	dgst := query.Get("digest")
+	query.Del("digest")
+	location.RawQuery = query.Encode()
+
+	chunk := make([]byte, t.chunkSize)
+	var offset int64
+	for offset < req.ContentLength {
+		n, err := io.ReadFull(req.Body, chunk)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, errors.Wrap(err, "read blob")
+		}
+		// The body is shorter than expected, the final request will
+		// be rejected by registry for the mismatched digest.
+		if n == 0 {
+			break
+		}
+		next, err := t.uploadChunk(req, &location, chunk[:n], offset)
+		if err != nil {
+			return nil, err
+		}
+		location = *next
+		offset += int64(n)
+	}
+
+	query = location.Query()
+	query.Set("digest", dgst)
+	location.RawQuery = query.Encode()
+	put := newUploadRequest(req, http.MethodPut, &location, nil)
+	put.Header.Set("Content-Type", "application/octet-stream")
+
+	return t.transport.RoundTrip(put)
+}
+
+// uploadChunk sends the chunk from offset, and returns the location of
+// upload for the next request.
+func (t *chunkedTransport) uploadChunk(req *http.Request, location *url.URL, chunk []byte, offset int64) (*url.URL, error) {
+	var sent int64
+	for attempt := 0; ; attempt++ {
+		patch := newUploadRequest(req, http.MethodPatch, location, chunk[sent:])
+		patch.Header.Set("Content-Type", "application/octet-stream")
+		patch.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset+sent, offset+int64(len(chunk))-1))
+		resp, err := t.transport.RoundTrip(patch)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				return nil, errors.Wrapf(remoteerrors.NewUnexpectedStatusErr(resp), "upload chunk at offset %d", offset+sent)
+			}
+			return uploadLocation(location, resp)
+		}
+		if attempt >= maxChunkRetries || req.Context().Err() != nil {
+			return nil, errors.Wrapf(err, "upload chunk at offset %d", offset+sent)
+		}
+
+		acked, next, statusErr := t.uploadStatus(req, location)
+		if statusErr != nil {
+			return nil, errors.Wrapf(statusErr, "get upload status after %s", err)
+		}
+		if acked < offset || acked > offset+int64(len(chunk)) {
+			return nil, errors.Errorf("unexpected acknowledged offset %d of chunk at offset %d", acked, offset)
+		}
+		location, sent = next, acked-offset
+		if sent == int64(len(chunk)) {
+			return location, nil
+		}
+	}
+}
+
+// uploadStatus returns the offset of upload acknowledged by registry.
+func (t *chunkedTransport) uploadStatus(req *http.Request, location *url.URL) (int64, *url.URL, error) {
+	resp, err := t.transport.RoundTrip(newUploadRequest(req, http.MethodGet, location, nil))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, nil, remoteerrors.NewUnexpectedStatusErr(resp)
+	}
+
+	var start, end int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Range"), "%d-%d", &start, &end); err != nil {
+		return 0, nil, errors.Wrapf(err, "parse range %q", resp.Header.Get("Range"))
+	}
+	next, err := uploadLocation(location, resp)
+	if err != nil {
+		return 0, nil, err
+	}
+	// The registry responds `0-0` for the empty upload, so it's
+	// resumed from scratch rather than the second byte.
+	if end == 0 {
+		return 0, next, nil
+	}
+
+	return end + 1, next, nil
+}
+
+// uploadLocation resolves the location of response relative to the
+// request location, it's unchanged if the response has no location.
+func uploadLocation(location *url.URL, resp *http.Response) (*url.URL, error) {
+	header := resp.Header.Get("Location")
+	if header == "" {
+		return location, nil
+	}
+	next, err := location.Parse(header)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse upload location %s", header)
+	}
+	return next, nil
+}
+
+// newUploadRequest creates the request of upload with the headers of
+// original request, such as the authorization and user agent.
+func newUploadRequest(req *http.Request, method string, location *url.URL, body []byte) *http.Request {
+	upload := req.Clone(req.Context())
+	upload.Method = method
+	upload.URL = location
+	upload.Host = location.Host
+	upload.Header.Del("Content-Type")
+	upload.Header.Del("Content-Range")
+	upload.Body = io.NopCloser(bytes.NewReader(body))
+	upload.ContentLength = int64(len(body))
+	upload.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	if len(body) == 0 {
+		upload.Body = http.NoBody
+	}
+	return upload
+}
@@ -0,0 +1,136 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedUpload(t *testing.T) {
+	const chunkSize = 64 << 10
+	data := make([]byte, 4*chunkSize+100)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	dgst := digest.FromBytes(data)
+
+	var (
+		mutex    sync.Mutex
+		uploaded []byte
+		received int
+		patches  int
+		dropped  bool
+		blob     []byte
+	)
+	const location = "/v2/library/foo/blobs/uploads/test-upload"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost:
+			w.Header().Set("Location", location)
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPatch:
+			patches++
+			var start, end int
+			_, err := fmt.Sscanf(r.Header.Get("Content-Range"), "%d-%d", &start, &end)
+			require.NoError(t, err)
+			if start != len(uploaded) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			// Drop the connection in the middle of the third chunk.
+			if !dropped && start == 2*chunkSize {
+				dropped = true
+				half := make([]byte, chunkSize/2)
+				_, err := io.ReadFull(r.Body, half)
+				require.NoError(t, err)
+				uploaded = append(uploaded, half...)
+				received += len(half)
+				conn, _, err := w.(http.Hijacker).Hijack()
+				require.NoError(t, err)
+				conn.Close()
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			uploaded = append(uploaded, body...)
+			received += len(body)
+			w.Header().Set("Location", location)
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(uploaded)-1))
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, location):
+			w.Header().Set("Location", location)
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(uploaded)-1))
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			received += len(body)
+			uploaded = append(uploaded, body...)
+			if r.URL.Query().Get("digest") != digest.FromBytes(uploaded).String() {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			blob = uploaded
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	resolver := NewResolver(false, true, credFunc, WithChunkedUpload(chunkSize))
+	pusher, err := resolver.Pusher(context.Background(), server.Listener.Addr().String()+"/library/foo:latest")
+	require.NoError(t, err)
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    dgst,
+		Size:      int64(len(data)),
+	}
+	writer, err := pusher.Push(context.Background(), desc)
+	require.NoError(t, err)
+	_, err = io.Copy(writer, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, writer.Commit(context.Background(), desc.Size, desc.Digest))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.True(t, dropped)
+	require.Equal(t, data, blob)
+	// 5 chunks and the resume of the interrupted one.
+	require.Equal(t, 6, patches)
+	require.Less(t, received, 2*len(data))
+	require.Equal(t, len(data), received)
+}
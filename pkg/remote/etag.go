@@ -0,0 +1,220 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// maxCachedManifestSize limits the size of manifest bodies kept in cache,
+// the larger manifests are passed through without caching.
+const maxCachedManifestSize = 4 << 20
+
+// DefaultManifestCacheSize is the size limit of manifest cache in bytes.
+const DefaultManifestCacheSize = 64 << 20
+
+type cachedManifest struct {
+	key          string
+	etag         string
+	lastModified string
+	header       http.Header
+	// body is nil for the responses of HEAD requests.
+	body []byte
+}
+
+// size returns the approximate memory used by the cached manifest.
+func (cached *cachedManifest) size() int64 {
+	size := len(cached.key) + len(cached.etag) + len(cached.lastModified) + len(cached.body)
+	for key, values := range cached.header {
+		size += len(key)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	return int64(size)
+}
+
+// ManifestCache caches the manifest responses of tags with the validators
+// (ETag or Last-Modified) by URL, it's shared by the resolvers created
+// with the same cache, so re-resolving an unchanged tag is answered by
+// registry with 304 Not Modified instead of the manifest. The least
+// recently used manifests are evicted once the cache exceeds its size.
+type ManifestCache struct {
+	mutex     sync.Mutex
+	size      int64
+	used      int64
+	manifests map[string]*list.Element
+	recent    *list.List
+}
+
+// NewManifestCache creates an empty manifest cache of at most size bytes.
+func NewManifestCache(size int64) *ManifestCache {
+	return &ManifestCache{
+		size:      size,
+		manifests: map[string]*list.Element{},
+		recent:    list.New(),
+	}
+}
+
+// WithManifestCache sends the conditional requests for the manifests of
+// tags in cache, and the cached responses are reused if registry answers
+// 304 Not Modified.
+func WithManifestCache(cache *ManifestCache) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.manifestCache = cache
+	}
+}
+
+func (c *ManifestCache) get(key string) (cachedManifest, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.manifests[key]
+	if !ok {
+		return cachedManifest{}, false
+	}
+	c.recent.MoveToFront(elem)
+	return *elem.Value.(*cachedManifest), true
+}
+
+func (c *ManifestCache) set(key string, cached cachedManifest) {
+	cached.key = key
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, ok := c.manifests[key]; ok {
+		c.removeElement(elem)
+	}
+	if cached.size() > c.size {
+		return
+	}
+	c.manifests[key] = c.recent.PushFront(&cached)
+	c.used += cached.size()
+	for c.used > c.size {
+		c.removeElement(c.recent.Back())
+	}
+}
+
+func (c *ManifestCache) removeElement(elem *list.Element) {
+	cached := c.recent.Remove(elem).(*cachedManifest)
+	delete(c.manifests, cached.key)
+	c.used -= cached.size()
+}
+
+// manifestCacheKey returns the key of manifest request by tag, the
+// responses vary with the Accept header. The manifests requested by
+// digest are immutable and never cached.
+func manifestCacheKey(req *http.Request) (string, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", false
+	}
+	idx := strings.LastIndex(req.URL.Path, "/manifests/")
+	if idx < 0 {
+		return "", false
+	}
+	if _, err := digest.Parse(req.URL.Path[idx+len("/manifests/"):]); err == nil {
+		return "", false
+	}
+	return strings.Join([]string{req.Method, req.URL.String(), req.Header.Get("Accept")}, "|"), true
+}
+
+type manifestCacheTransport struct {
+	transport http.RoundTripper
+	cache     *ManifestCache
+}
+
+func (t *manifestCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ok := manifestCacheKey(req)
+	if !ok || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return t.transport.RoundTrip(req)
+	}
+
+	cached, hit := t.cache.get(key)
+	if hit {
+		req = req.Clone(req.Context())
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		} else {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if hit && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return cachedResponse(req, cached), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return resp, nil
+	}
+
+	fresh := cachedManifest{
+		etag:         etag,
+		lastModified: lastModified,
+		header:       resp.Header.Clone(),
+	}
+	if req.Method == http.MethodGet {
+		if resp.ContentLength < 0 || resp.ContentLength > maxCachedManifestSize {
+			return resp, nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		fresh.body = body
+	}
+	t.cache.set(key, fresh)
+
+	return resp, nil
+}
+
+// cachedResponse rebuilds the response of request from cache.
+func cachedResponse(req *http.Request, cached cachedManifest) *http.Response {
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     cached.header.Clone(),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	if cached.body != nil {
+		resp.Body = io.NopCloser(bytes.NewReader(cached.body))
+		resp.ContentLength = int64(len(cached.body))
+	} else {
+		resp.ContentLength = -1
+		if n, err := strconv.ParseInt(cached.header.Get("Content-Length"), 10, 64); err == nil {
+			resp.ContentLength = n
+		}
+	}
+	return resp
+}
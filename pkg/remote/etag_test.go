@@ -0,0 +1,132 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestCache(t *testing.T) {
+	var mutex sync.Mutex
+	manifest := []byte(`{"schemaVersion":2,"tag":"v1"}`)
+	etag := `W/"v1"`
+	notModified := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(manifest)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	cache := NewManifestCache(DefaultManifestCacheSize)
+	resolve := func() ocispec.Descriptor {
+		resolver := NewResolver(false, true, credFunc, WithManifestCache(cache))
+		_, desc, err := resolver.Resolve(context.Background(), host+"/library/foo:latest")
+		require.NoError(t, err)
+		return desc
+	}
+
+	first := resolve()
+	require.Equal(t, digest.FromBytes(manifest), first.Digest)
+	// The cached descriptor is reused for 304 Not Modified.
+	require.Equal(t, first, resolve())
+	mutex.Lock()
+	require.Equal(t, 1, notModified)
+	// The tag is moved to another manifest.
+	manifest = []byte(`{"schemaVersion":2,"tag":"v2"}`)
+	etag = `W/"v2"`
+	mutex.Unlock()
+
+	second := resolve()
+	require.Equal(t, digest.FromBytes(manifest), second.Digest)
+	require.Equal(t, int64(len(manifest)), second.Size)
+	mutex.Lock()
+	require.Equal(t, 1, notModified)
+	mutex.Unlock()
+}
+
+func TestManifestCacheKey(t *testing.T) {
+	for url, cached := range map[string]bool{
+		"https://registry.example.com/v2/library/foo/manifests/latest":                               true,
+		"https://registry.example.com/v2/library/foo/manifests/" + digest.FromString("foo").String(): false,
+		"https://registry.example.com/v2/library/foo/blobs/" + digest.FromString("foo").String():     false,
+	} {
+		req, err := http.NewRequest(http.MethodHead, url, nil)
+		require.NoError(t, err)
+		_, ok := manifestCacheKey(req)
+		require.Equal(t, cached, ok, url)
+	}
+}
+
+func TestManifestCacheEviction(t *testing.T) {
+	body := make([]byte, 100)
+	manifest := func(etag string) cachedManifest {
+		return cachedManifest{etag: etag, header: http.Header{}, body: body}
+	}
+	entry := manifest("etag")
+	entry.key = "GET|foo"
+	cache := NewManifestCache(2 * entry.size())
+
+	cache.set("GET|foo", manifest("etag"))
+	cache.set("GET|bar", manifest("etag"))
+	// foo is used more recently than bar.
+	_, ok := cache.get("GET|foo")
+	require.True(t, ok)
+	cache.set("GET|baz", manifest("etag"))
+	_, ok = cache.get("GET|bar")
+	require.False(t, ok)
+	for _, key := range []string{"GET|foo", "GET|baz"} {
+		_, ok = cache.get(key)
+		require.True(t, ok, key)
+	}
+	require.Equal(t, 2*entry.size(), cache.used)
+
+	// The manifest larger than cache isn't cached, and replaces the
+	// stale one.
+	cache.set("GET|foo", cachedManifest{etag: "etag", body: make([]byte, 300)})
+	_, ok = cache.get("GET|foo")
+	require.False(t, ok)
+	require.Equal(t, entry.size(), cache.used)
+}
@@ -0,0 +1,42 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"net"
+	"strings"
+)
+
+// canonicalHost encloses the IPv6 literal in brackets as it's written in
+// references, e.g. `::1` is converted to `[::1]`, the other hosts are
+// returned as is.
+func canonicalHost(host string) string {
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		if ip := net.ParseIP(host); ip != nil {
+			return "[" + host + "]"
+		}
+	}
+	return host
+}
+
+// splitHostname returns the hostname of `host:port`, the IPv6 literal is
+// kept in brackets, e.g. `[::1]`. False is returned if there is no port.
+func splitHostname(host string) (string, bool) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return "", false
+	}
+	return canonicalHost(hostname), true
+}
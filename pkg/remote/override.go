@@ -0,0 +1,67 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// WithHostOverride dials the address like `10.0.0.1` or `10.0.0.1:5000`
+// for registry host instead of resolving it by DNS, the host can be `host`
+// or `host:port`, the later one is preferred if both are set. The port of
+// request is kept if the address has no port. The TLS server name and the
+// Host header still use the original host.
+func WithHostOverride(host, address string) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		if opts.hostOverrides == nil {
+			opts.hostOverrides = map[string]string{}
+		}
+		opts.hostOverrides[canonicalHost(host)] = address
+	}
+}
+
+type dialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// overrideDial replaces the address of dial by host overrides.
+func overrideDial(dial dialFunc, overrides map[string]string) dialFunc {
+	if len(overrides) == 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, network, overrideAddress(addr, overrides))
+	}
+}
+
+func overrideAddress(addr string, overrides map[string]string) string {
+	address, ok := overrides[addr]
+	if !ok {
+		host, split := splitHostname(addr)
+		if !split {
+			return addr
+		}
+		if address, ok = overrides[host]; !ok {
+			return addr
+		}
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		// The address has no port.
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			return net.JoinHostPort(strings.Trim(address, "[]"), port)
+		}
+	}
+	return address
+}
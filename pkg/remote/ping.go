@@ -0,0 +1,123 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/remotes/docker"
+)
+
+// pingAuthorizer probes the API version endpoint `/v2/` of host once the
+// challenge of an unauthorized response can't be answered by authorizer,
+// then the challenge answered by the endpoint is added to authorizer and
+// the request is retried. Some registries behind gateways don't answer
+// the challenge on the other endpoints until the `/v2/` endpoint has been
+// probed, see also:
+// https://docs.docker.com/registry/spec/api/#api-version-check
+type pingAuthorizer struct {
+	authorizer docker.Authorizer
+	client     *http.Client
+	header     http.Header
+
+	mutex sync.Mutex
+	// The probes by the base URL of API, like `https://host/v2/`.
+	pings map[string]*sync.Once
+}
+
+func newPingAuthorizer(authorizer docker.Authorizer, client *http.Client, header http.Header) docker.Authorizer {
+	return &pingAuthorizer{
+		authorizer: authorizer,
+		client:     client,
+		header:     header,
+		pings:      map[string]*sync.Once{},
+	}
+}
+
+// pingURL returns the API version endpoint of request, the path prefix of
+// mirror endpoints like `https://host/prefix/v2/` is kept.
+func pingURL(req *http.Request) (string, bool) {
+	idx := strings.Index(req.URL.Path, "/v2/")
+	if idx < 0 || req.URL.Path == req.URL.Path[:idx+len("/v2/")] {
+		return "", false
+	}
+	return req.URL.Scheme + "://" + req.URL.Host + req.URL.Path[:idx+len("/v2/")], true
+}
+
+func (a *pingAuthorizer) once(url string) *sync.Once {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	once, ok := a.pings[url]
+	if !ok {
+		once = &sync.Once{}
+		a.pings[url] = once
+	}
+	return once
+}
+
+// ping probes the endpoint and returns true if its challenge is added,
+// the other failures are left to the request itself.
+func (a *pingAuthorizer) ping(ctx context.Context, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+	for key, values := range a.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		log.G(ctx).WithError(err).Debugf("failed to ping %s", url)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	if err := a.authorizer.AddResponses(ctx, []*http.Response{resp}); err != nil {
+		log.G(ctx).WithError(err).Debugf("failed to add challenge of %s", url)
+		return false
+	}
+	return true
+}
+
+func (a *pingAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	return a.authorizer.Authorize(ctx, req)
+}
+
+func (a *pingAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	err := a.authorizer.AddResponses(ctx, responses)
+	last := responses[len(responses)-1]
+	if err == nil || last.StatusCode != http.StatusUnauthorized {
+		return err
+	}
+	url, ok := pingURL(last.Request)
+	if !ok {
+		return err
+	}
+	pinged := false
+	a.once(url).Do(func() {
+		pinged = a.ping(ctx, url)
+	})
+	// The request is retried with the challenge of probed endpoint.
+	if pinged {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	var mutex sync.Mutex
+	pings := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		// The gateway answers the challenge only after `/v2/` is probed.
+		challenge := fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL)
+		switch {
+		case r.URL.Path == "/v2/":
+			pings++
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case r.URL.Path == "/token":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"token":"test-token"}`)
+			return
+		case r.Header.Get("Authorization") != "Bearer test-token":
+			if pings > 0 {
+				w.Header().Set("WWW-Authenticate", challenge)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	resolver := NewResolver(false, true, credFunc)
+	for i := 0; i < 2; i++ {
+		_, desc, err := resolver.Resolve(context.Background(), host+"/library/foo:latest")
+		require.NoError(t, err)
+		require.Equal(t, digest.FromBytes(manifest), desc.Digest)
+	}
+	// The endpoint is probed once for the resolver.
+	mutex.Lock()
+	require.Equal(t, 1, pings)
+	mutex.Unlock()
+}
+
+func TestPingURL(t *testing.T) {
+	for url, expected := range map[string]string{
+		"https://registry.example.com/v2/library/foo/manifests/latest": "https://registry.example.com/v2/",
+		"https://mirror.example.com/prefix/v2/library/foo/blobs/x":     "https://mirror.example.com/prefix/v2/",
+		"https://registry.example.com/v2/":                             "",
+		"https://registry.example.com/token":                           "",
+	} {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		pinged, ok := pingURL(req)
+		require.Equal(t, expected != "", ok, url)
+		require.Equal(t, expected, pinged, url)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit limits the requests to registry host (or host:port) by a
+// token bucket, which is filled by rps tokens per second and holds at most
+// burst tokens. The limiter is shared by all the resolvers created with
+// the option, the hosts without limit are unlimited.
+func WithRateLimit(host string, rps float64, burst int) ResolverOpt {
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := rate.NewLimiter(rate.Limit(rps), burst)
+	return func(opts *ResolverOpts) {
+		if opts.limiters == nil {
+			opts.limiters = map[string]*rate.Limiter{}
+		}
+		opts.limiters[canonicalHost(host)] = limiter
+	}
+}
+
+// rateLimitTransport waits for the token of request host before sending
+// the request, the waiting is canceled with the request context.
+type rateLimitTransport struct {
+	transport http.RoundTripper
+	limiters  map[string]*rate.Limiter
+}
+
+func (t *rateLimitTransport) limiter(host string) *rate.Limiter {
+	if limiter, ok := t.limiters[host]; ok {
+		return limiter
+	}
+	if hostname, ok := splitHostname(host); ok {
+		return t.limiters[hostname]
+	}
+	return nil
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if limiter := t.limiter(req.URL.Host); limiter != nil {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return nil, errors.Wrapf(err, "wait for rate limit of %s", req.URL.Host)
+		}
+	}
+	return t.transport.RoundTrip(req)
+}
@@ -0,0 +1,93 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRateLimit(t *testing.T) {
+	blob := []byte("blob")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	host := server.Listener.Addr().String()
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	resolver := NewResolver(false, true, credFunc, WithRateLimit(host, 20, 1))
+	fetcher, err := resolver.Fetcher(context.Background(), host+"/library/foo:latest")
+	require.NoError(t, err)
+
+	fetches := 5
+	start := time.Now()
+	eg, ctx := errgroup.WithContext(context.Background())
+	for i := 0; i < fetches; i++ {
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromString(fmt.Sprintf("blob-%d", i)),
+			Size:      int64(len(blob)),
+		}
+		eg.Go(func() error {
+			rc, err := fetcher.Fetch(ctx, desc)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			_, err = io.ReadAll(rc)
+			return err
+		})
+	}
+	require.NoError(t, eg.Wait())
+	// The first request consumes the burst, then a token every 50ms.
+	require.GreaterOrEqual(t, time.Since(start), time.Duration(fetches-1)*50*time.Millisecond-10*time.Millisecond)
+
+	// The waiting is canceled by context.
+	resolver = NewResolver(false, true, credFunc, WithRateLimit(host, 0.01, 1))
+	fetcher, err = resolver.Fetcher(context.Background(), host+"/library/foo:latest")
+	require.NoError(t, err)
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	rc, err := fetcher.Fetch(context.Background(), desc)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	rc.Close()
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	rc, err = fetcher.Fetch(timeoutCtx, desc)
+	if err == nil {
+		_, err = io.ReadAll(rc)
+		rc.Close()
+	}
+	require.ErrorContains(t, err, "rate limit")
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,164 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// maxReferrersSize limits the size of referrers response read into memory.
+const maxReferrersSize = 4 << 20
+
+// ReferrersFetcher lists the referrers of manifests by the referrers API
+// of registry, see also:
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#listing-referrers
+type ReferrersFetcher struct {
+	hosts   docker.RegistryHosts
+	headers http.Header
+}
+
+// NewReferrersFetcher creates the referrers fetcher with the same hosts,
+// authorization and transport options as NewResolver.
+func NewReferrersFetcher(insecure, plainHTTP bool, credFunc CredentialFunc, opts ...ResolverOpt) *ReferrersFetcher {
+	var options ResolverOpts
+	for _, opt := range opts {
+		opt(&options)
+	}
+	hosts, headers := newRegistryHosts(insecure, plainHTTP, credFunc, options)
+	return &ReferrersFetcher{
+		hosts:   hosts,
+		headers: headers,
+	}
+}
+
+// Referrers returns the descriptors of manifests referring to the manifest
+// dgst in the repository of ref, filtered by the artifact type if it's not
+// empty. The errdefs.ErrNotImplemented is returned if the registry doesn't
+// support the referrers API, the referrers may be found by the tag schema.
+func (f *ReferrersFetcher) Referrers(ctx context.Context, ref string, dgst digest.Digest, artifactType string) ([]ocispec.Descriptor, error) {
+	refspec, err := reference.Parse(ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse reference %s", ref)
+	}
+	hosts, err := f.hosts(refspec.Hostname())
+	if err != nil {
+		return nil, errors.Wrapf(err, "configure hosts of %s", refspec.Hostname())
+	}
+	ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, false)
+	if err != nil {
+		return nil, err
+	}
+	repo := strings.TrimPrefix(refspec.Locator, refspec.Hostname()+"/")
+
+	var lastErr error
+	for _, host := range hosts {
+		if !host.Capabilities.Has(docker.HostCapabilityResolve) {
+			continue
+		}
+		u := url.URL{
+			Scheme: host.Scheme,
+			Host:   host.Host,
+			Path:   path.Join(host.Path, repo, "referrers", dgst.String()),
+		}
+		if artifactType != "" {
+			u.RawQuery = url.Values{"artifactType": {artifactType}}.Encode()
+		}
+		referrers, err := f.fetch(ctx, host, u.String())
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return filterReferrers(referrers, artifactType), nil
+	}
+	if lastErr == nil {
+		lastErr = errors.Errorf("no host of %s to resolve referrers", refspec.Hostname())
+	}
+
+	return nil, lastErr
+}
+
+// fetch requests the referrers of host, the request is retried once with
+// the authorization answering the challenge of registry.
+func (f *ReferrersFetcher) fetch(ctx context.Context, host docker.RegistryHost, u string) ([]ocispec.Descriptor, error) {
+	var responses []*http.Response
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = f.headers.Clone()
+		req.Header.Set("Accept", ocispec.MediaTypeImageIndex)
+		if host.Authorizer != nil {
+			if err := host.Authorizer.Authorize(ctx, req); err != nil {
+				return nil, errors.Wrap(err, "authorize referrers request")
+			}
+		}
+		client := host.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "request referrers %s", u)
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			var index ocispec.Index
+			if err := json.NewDecoder(io.LimitReader(resp.Body, maxReferrersSize)).Decode(&index); err != nil {
+				return nil, errors.Wrapf(err, "decode referrers %s", u)
+			}
+			return index.Manifests, nil
+		case http.StatusNotFound:
+			return nil, errors.Wrapf(errdefs.ErrNotImplemented, "referrers API of %s", host.Host)
+		case http.StatusUnauthorized:
+			responses = append(responses, resp)
+			if host.Authorizer == nil || len(responses) > 1 {
+				return nil, remoteerrors.NewUnexpectedStatusErr(resp)
+			}
+			if err := host.Authorizer.AddResponses(ctx, responses); err != nil {
+				return nil, errors.Wrap(err, "authorize referrers request")
+			}
+		default:
+			return nil, remoteerrors.NewUnexpectedStatusErr(resp)
+		}
+	}
+}
+
+func filterReferrers(referrers []ocispec.Descriptor, artifactType string) []ocispec.Descriptor {
+	// The filter may not be applied by registry.
+	filtered := []ocispec.Descriptor{}
+	for _, desc := range referrers {
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			filtered = append(filtered, desc)
+		}
+	}
+	return filtered
+}
@@ -16,35 +16,120 @@ package remote
 
 import (
 	"crypto/tls"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	dockerconfig "github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/time/rate"
 )
 
-func newDefaultClient(skipTLSVerify bool) *http.Client {
+type proxyFunc = func(*http.Request) (*url.URL, error)
+
+func newDefaultClient(skipTLSVerify bool, options ResolverOpts) *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if options.proxy != "" {
+		proxy = newProxyFunc(options.proxy)
+	}
+
+	var transport http.RoundTripper
+	if len(options.tlsConfigs) == 0 {
+		transport = newTransport(proxy, &tls.Config{
+			InsecureSkipVerify: skipTLSVerify,
+		}, options.transport, options.hostOverrides)
+	} else {
+		transport = newHostTransport(proxy, skipTLSVerify, options.tlsConfigs, options.transport, options.hostOverrides)
+	}
+
+	// The responses are observed before being rewritten by the wrappers.
+	if options.statusObserver != nil {
+		transport = &statusTransport{
+			transport: transport,
+			observe:   options.statusObserver,
+		}
+	}
+
+	if options.requestTimeout > 0 {
+		transport = &timeoutTransport{
+			transport: transport,
+			timeout:   options.requestTimeout,
+		}
+	}
+
+	if len(options.limiters) > 0 {
+		transport = &rateLimitTransport{
+			transport: transport,
+			limiters:  options.limiters,
+		}
+	}
+
+	if options.circuitBreaker != nil && options.circuitBreaker.cfg.Threshold > 0 {
+		transport = &circuitBreakerTransport{
+			transport: transport,
+			breaker:   options.circuitBreaker,
+		}
+	}
+
+	if options.chunkSize > 0 {
+		transport = &chunkedTransport{
+			transport: transport,
+			chunkSize: options.chunkSize,
+		}
+	}
+
+	if options.manifestCache != nil {
+		transport = &manifestCacheTransport{
+			transport: transport,
+			cache:     options.manifestCache,
+		}
+	}
+
+	if options.manifestAccepted != nil {
+		transport = &acceptedTransport{
+			transport: transport,
+			accepted:  options.manifestAccepted,
+		}
+	}
+
 	return &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-				DualStack: true,
-			}).DialContext,
-			MaxIdleConns:          10,
-			IdleConnTimeout:       30 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 5 * time.Second,
-			DisableKeepAlives:     true,
-			TLSNextProto:          make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipTLSVerify,
-			},
-		},
+		Transport: transport,
+	}
+}
+
+// NewClient creates the HTTP client with the transport options of resolver,
+// like the TLS configurations, proxy and timeouts, to access the hosts
+// other than registry, e.g. the URLs of foreign layers.
+func NewClient(insecure bool, opts ...ResolverOpt) *http.Client {
+	var options ResolverOpts
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return newDefaultClient(insecure, options)
+}
+
+// newProxyFunc uses the proxy for both HTTP and HTTPS requests, except
+// for the hosts excluded by the `NO_PROXY` environment variable.
+func newProxyFunc(proxy string) proxyFunc {
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxy,
+		HTTPSProxy: proxy,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
 	}
 }
 
@@ -60,38 +145,221 @@ type HostFunc = func(ref string) (CredentialFunc, bool, error)
 // to communicate with remote registry, `$DOCKER_CONFIG` defaults to `~/.docker`.
 func NewDockerConfigCredFunc() CredentialFunc {
 	return func(host string) (string, string, error) {
-		// The host of docker hub image will be converted to `registry-1.docker.io` in:
-		// github.com/containerd/containerd/remotes/docker/registry.go
-		// But we need use the key `https://index.docker.io/v1/` to find auth from docker config.
-		if host == "registry-1.docker.io" {
-			host = "https://index.docker.io/v1/"
-		}
+		return dockerConfigCred(dockerconfig.LoadDefaultConfigFile(os.Stderr), host)
+	}
+}
+
+// NewDockerConfigHostFunc reads the docker config file on path, the
+// credentials are resolved from the `auths` entries or the `credHelpers`
+// and `credsStore` helper binaries, the registries not listed in config
+// are accessed anonymously.
+func NewDockerConfigHostFunc(path string) (HostFunc, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open docker config")
+	}
+	defer file.Close()
+
+	config := configfile.New(path)
+	if err := config.LoadFromReader(file); err != nil {
+		return nil, errors.Wrapf(err, "load docker config %s", path)
+	}
+
+	credFunc := func(host string) (string, string, error) {
+		return dockerConfigCred(config, host)
+	}
+
+	return func(ref string) (CredentialFunc, bool, error) {
+		return credFunc, false, nil
+	}, nil
+}
 
-		config := dockerconfig.LoadDefaultConfigFile(os.Stderr)
-		authConfig, err := config.GetAuthConfig(host)
-		if err != nil {
-			return "", "", err
+func dockerConfigCred(config *configfile.ConfigFile, host string) (string, string, error) {
+	// The host of docker hub image will be converted to `registry-1.docker.io` in:
+	// github.com/containerd/containerd/remotes/docker/registry.go
+	// But we need use the key `https://index.docker.io/v1/` to find auth from docker config.
+	if host == "registry-1.docker.io" {
+		host = "https://index.docker.io/v1/"
+	}
+
+	authConfig, err := config.GetAuthConfig(host)
+	if err != nil {
+		return "", "", err
+	}
+
+	return authConfig.Username, authConfig.Password, nil
+}
+
+type ResolverOpts struct {
+	mirrors           map[string][]string
+	tlsConfigs        map[string]TLSConfig
+	anonymousFallback bool
+	proxy             string
+	limiters          map[string]*rate.Limiter
+	requestTimeout    time.Duration
+	userAgent         string
+	tokenCache        *TokenCache
+	chunkSize         int64
+	transport         TransportConfig
+	circuitBreaker    *CircuitBreaker
+	hostOverrides     map[string]string
+	manifestCache     *ManifestCache
+	manifestAccepted  func(req *http.Request)
+	statusObserver    func(host string, statusCode int)
+}
+
+type ResolverOpt func(opts *ResolverOpts)
+
+// WithMirrors sets the ordered mirror endpoints of registry host, like
+// `https://mirror.example.com` or `http://127.0.0.1:5000/v2`, the mirrors
+// are tried in order before the registry host for resolving and fetching,
+// but never for pushing.
+func WithMirrors(host string, endpoints ...string) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		if opts.mirrors == nil {
+			opts.mirrors = map[string][]string{}
 		}
+		host = canonicalHost(host)
+		opts.mirrors[host] = append(opts.mirrors[host], endpoints...)
+	}
+}
 
-		return authConfig.Username, authConfig.Password, nil
+// WithAnonymousFallback retries the pull requests anonymously if the
+// authorization with credential fails, it should not be used for pushing
+// to avoid masking the credential misconfiguration.
+func WithAnonymousFallback() ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.anonymousFallback = true
 	}
 }
 
-func NewResolver(insecure, plainHTTP bool, credFunc CredentialFunc) remotes.Resolver {
+// WithProxy sets the proxy URL like `http://proxy.example.com:3128` to
+// access registry, it overrides the `HTTP_PROXY` and `HTTPS_PROXY`
+// environment variables, but the `NO_PROXY` is still respected.
+func WithProxy(proxy string) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.proxy = proxy
+	}
+}
+
+// DefaultUserAgent is the User-Agent header of registry requests if no
+// one is specified by WithUserAgent.
+var DefaultUserAgent = "acceleration-service"
+
+// WithUserAgent sets the User-Agent header of all registry requests,
+// including the requests to token endpoint.
+func WithUserAgent(userAgent string) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.userAgent = userAgent
+	}
+}
+
+func NewResolver(insecure, plainHTTP bool, credFunc CredentialFunc, opts ...ResolverOpt) remotes.Resolver {
+	var options ResolverOpts
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	registryHosts, headers := newRegistryHosts(insecure, plainHTTP, credFunc, options)
+	// The Accept header is taken by resolver for resolving only, the
+	// headers shared with authorizer are left untouched.
+	resolveHeaders := headers.Clone()
+	resolveHeaders.Set("Accept", ManifestAccept)
+
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts:   registryHosts,
+		Headers: resolveHeaders,
+	})
+}
+
+// ManifestAccept is the Accept header of resolving, it lists the manifest
+// types supported by conversion. Unlike the containerd default, "*/*" is
+// not accepted, for which some registries serve the deprecated schema1
+// manifest.
+var ManifestAccept = strings.Join([]string{
+	ocispec.MediaTypeImageIndex,
+	ocispec.MediaTypeImageManifest,
+	images.MediaTypeDockerSchema2ManifestList,
+	images.MediaTypeDockerSchema2Manifest,
+	ocispec.MediaTypeArtifactManifest,
+}, ", ")
+
+// newRegistryHosts configures the hosts of registries with the client
+// and authorizer for options, and returns the headers of requests.
+func newRegistryHosts(insecure, plainHTTP bool, credFunc CredentialFunc, options ResolverOpts) (docker.RegistryHosts, http.Header) {
+	userAgent := options.userAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	headers := http.Header{}
+	headers.Set("User-Agent", userAgent)
+
+	client := newDefaultClient(insecure, options)
+	authorizer := newRefreshAuthorizer(func() docker.Authorizer {
+		return docker.NewDockerAuthorizer(
+			docker.WithAuthClient(client),
+			docker.WithAuthCreds(credFunc),
+			docker.WithAuthHeader(headers),
+		)
+	})
+	if options.tokenCache != nil {
+		authorizer = newCachingAuthorizer(options.tokenCache, authorizer, client, headers, credFunc)
+	}
+	if options.anonymousFallback {
+		authorizer = newAnonymousAuthorizer(authorizer, docker.NewDockerAuthorizer(
+			docker.WithAuthClient(client),
+			docker.WithAuthHeader(headers),
+		))
+	}
+	authorizer = newScopeAuthorizer(authorizer)
+	authorizer = newPingAuthorizer(authorizer, client, headers)
+
 	registryHosts := docker.ConfigureDefaultRegistries(
-		docker.WithAuthorizer(
-			docker.NewDockerAuthorizer(
-				docker.WithAuthClient(newDefaultClient(insecure)),
-				docker.WithAuthCreds(credFunc),
-			),
-		),
-		docker.WithClient(newDefaultClient(insecure)),
+		docker.WithAuthorizer(authorizer),
+		docker.WithClient(client),
 		docker.WithPlainHTTP(func(host string) (bool, error) {
 			return plainHTTP, nil
 		}),
 	)
 
-	return docker.NewResolver(docker.ResolverOptions{
-		Hosts: registryHosts,
-	})
+	if len(options.mirrors) > 0 {
+		registryHosts = withMirrorHosts(registryHosts, options.mirrors)
+	}
+
+	return registryHosts, headers
+}
+
+// withMirrorHosts prepends the mirror hosts to the registry hosts, the
+// mirror hosts are only capable of pulling and resolving, see also:
+// https://github.com/containerd/containerd/blob/main/docs/hosts.md
+func withMirrorHosts(registryHosts docker.RegistryHosts, mirrors map[string][]string) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		hosts, err := registryHosts(host)
+		if err != nil || len(hosts) == 0 {
+			return hosts, err
+		}
+
+		canonical := hosts[0]
+		mirrorHosts := []docker.RegistryHost{}
+		for _, endpoint := range mirrors[host] {
+			if !strings.Contains(endpoint, "://") {
+				endpoint = canonical.Scheme + "://" + endpoint
+			}
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse mirror endpoint %s", endpoint)
+			}
+			mirror := canonical
+			mirror.Scheme = u.Scheme
+			mirror.Host = u.Host
+			mirror.Path = strings.TrimSuffix(u.Path, "/")
+			if !strings.HasSuffix(mirror.Path, "/v2") {
+				mirror.Path += "/v2"
+			}
+			mirror.Capabilities = docker.HostCapabilityPull | docker.HostCapabilityResolve
+			mirrorHosts = append(mirrorHosts, mirror)
+		}
+
+		return append(mirrorHosts, hosts...), nil
+	}
 }
@@ -0,0 +1,175 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerConfigHostFunc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("foo:bar"))
+	config := fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":%q},"https://index.docker.io/v1/":{"auth":%q}}}`, auth, auth)
+	require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+	hosts, err := NewDockerConfigHostFunc(path)
+	require.NoError(t, err)
+	credFunc, insecure, err := hosts("registry.example.com/library/foo:latest")
+	require.NoError(t, err)
+	require.False(t, insecure)
+
+	username, password, err := credFunc("registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "foo", username)
+	require.Equal(t, "bar", password)
+
+	username, password, err = credFunc("registry-1.docker.io")
+	require.NoError(t, err)
+	require.Equal(t, "foo", username)
+	require.Equal(t, "bar", password)
+
+	// Anonymous for the registry not listed.
+	username, password, err = credFunc("other.example.com")
+	require.NoError(t, err)
+	require.Empty(t, username)
+	require.Empty(t, password)
+
+	_, err = NewDockerConfigHostFunc(filepath.Join(t.TempDir(), "config.json"))
+	require.Error(t, err)
+}
+
+func TestResolveWithProxy(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	var mutex sync.Mutex
+	hosts := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		hosts = append(hosts, r.URL.Host)
+		mutex.Unlock()
+		if !strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	t.Setenv("NO_PROXY", "internal.example.com")
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	resolver := NewResolver(false, true, credFunc, WithProxy(proxy.URL))
+	_, desc, err := resolver.Resolve(context.Background(), "registry.example.com/library/foo:latest")
+	require.NoError(t, err)
+	require.Equal(t, digest.FromBytes(manifest), desc.Digest)
+	require.NotEmpty(t, hosts)
+	for _, host := range hosts {
+		require.Equal(t, "registry.example.com", host)
+	}
+
+	proxyFunc := newProxyFunc(proxy.URL)
+	for host, proxied := range map[string]bool{
+		"registry.example.com": true,
+		"internal.example.com": false,
+	} {
+		req, err := http.NewRequest(http.MethodGet, "https://"+host+"/v2/", nil)
+		require.NoError(t, err)
+		u, err := proxyFunc(req)
+		require.NoError(t, err)
+		require.Equal(t, proxied, u != nil, host)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	var mutex sync.Mutex
+	userAgents := map[string]string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		userAgents[r.URL.Path] = r.UserAgent()
+		mutex.Unlock()
+		switch {
+		case r.URL.Path == "/token":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"token":"test-token"}`)
+			return
+		case r.Header.Get("Authorization") != "Bearer test-token":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(manifest)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	for _, expected := range []string{DefaultUserAgent, "test-agent/1.0"} {
+		userAgents = map[string]string{}
+		opts := []ResolverOpt{}
+		if expected != DefaultUserAgent {
+			opts = append(opts, WithUserAgent(expected))
+		}
+		resolver := NewResolver(false, true, credFunc, opts...)
+		name, desc, err := resolver.Resolve(context.Background(), host+"/library/foo:latest")
+		require.NoError(t, err)
+		fetcher, err := resolver.Fetcher(context.Background(), name)
+		require.NoError(t, err)
+		reader, err := fetcher.Fetch(context.Background(), ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+		})
+		require.NoError(t, err)
+		// The blob is lazily fetched on read.
+		_, err = io.ReadAll(reader)
+		require.NoError(t, err)
+		reader.Close()
+
+		mutex.Lock()
+		require.Len(t, userAgents, 3)
+		for path, userAgent := range userAgents {
+			require.Equal(t, expected, userAgent, path)
+		}
+		mutex.Unlock()
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/containerd/remotes/docker/auth"
+)
+
+// scopeAuthorizer requests the tokens with the minimal scopes of operation,
+// e.g. `pull` for resolving and fetching, `pull,push` for pushing, which
+// are carried by the context of request. The actions other than `pull` in
+// the scopes of bearer challenge are dropped for the pulling operations,
+// otherwise the widest challenged scopes, like the `push` scope challenged
+// by a pushing request or by registries challenging all the actions, are
+// requested for all the following requests to host, and denied by the
+// registries issuing the tokens per repository. The challenged scopes of
+// other repositories are kept, e.g. the upstream repository of a
+// pull-through cache.
+type scopeAuthorizer struct {
+	authorizer docker.Authorizer
+}
+
+func newScopeAuthorizer(authorizer docker.Authorizer) docker.Authorizer {
+	return &scopeAuthorizer{authorizer: authorizer}
+}
+
+func (a *scopeAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	return a.authorizer.Authorize(ctx, req)
+}
+
+func (a *scopeAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	scopes := docker.GetTokenScopes(ctx, nil)
+	// The challenged scopes are kept for pushing, or for the requests
+	// without the scopes of operation.
+	if len(scopes) == 0 || hasPushScope(scopes) {
+		return a.authorizer.AddResponses(ctx, responses)
+	}
+	last := responses[len(responses)-1]
+	if header, ok := pullScopesOnly(last.Header); ok {
+		resp := *last
+		resp.Header = header
+		responses = append(append([]*http.Response{}, responses[:len(responses)-1]...), &resp)
+	}
+	return a.authorizer.AddResponses(ctx, responses)
+}
+
+// minimalScopes reduces the token scopes to the `pull` action unless the
+// scopes of operation have `push`, as challenge reduced by AddResponses,
+// it's applied to the tokens requested by cached challenge.
+func minimalScopes(operation, scopes []string) []string {
+	if len(operation) == 0 || hasPushScope(operation) {
+		return scopes
+	}
+	reduced := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if pulled, ok := pullScope(scope); ok {
+			reduced = append(reduced, pulled)
+		}
+	}
+	return reduced
+}
+
+// hasPushScope returns true if any repository scope like
+// `repository:foo:pull,push` has the `push` action.
+func hasPushScope(scopes []string) bool {
+	for _, scope := range scopes {
+		parts := strings.Split(scope, ":")
+		if len(parts) < 3 || parts[0] != "repository" {
+			continue
+		}
+		for _, action := range strings.Split(parts[len(parts)-1], ",") {
+			if action == "push" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pullScope returns the repository scope with the `pull` action only, it
+// returns false if the scope doesn't allow pulling.
+func pullScope(scope string) (string, bool) {
+	parts := strings.Split(scope, ":")
+	if len(parts) < 3 || parts[0] != "repository" {
+		return scope, true
+	}
+	for _, action := range strings.Split(parts[len(parts)-1], ",") {
+		if action == "pull" || action == "*" {
+			return strings.Join(append(parts[:len(parts)-1], "pull"), ":"), true
+		}
+	}
+	return "", false
+}
+
+// pullScopesOnly returns the header with the challenged scopes reduced to
+// the `pull` action, it returns false if none of them is reduced.
+func pullScopesOnly(header http.Header) (http.Header, bool) {
+	challenges := auth.ParseAuthHeader(header)
+	reduced := false
+	for _, c := range challenges {
+		scope, ok := c.Parameters["scope"]
+		if !ok || c.Scheme != auth.BearerAuth {
+			continue
+		}
+		scopes := []string{}
+		for _, s := range strings.Fields(scope) {
+			if pulled, ok := pullScope(s); ok {
+				scopes = append(scopes, pulled)
+			}
+		}
+		if pulled := strings.Join(scopes, " "); pulled != scope {
+			c.Parameters["scope"] = pulled
+			if pulled == "" {
+				delete(c.Parameters, "scope")
+			}
+			reduced = true
+		}
+	}
+	if !reduced {
+		return nil, false
+	}
+
+	values := make([]string, 0, len(challenges))
+	for _, c := range challenges {
+		values = append(values, formatChallenge(c))
+	}
+	header = header.Clone()
+	header["Www-Authenticate"] = values
+	return header, true
+}
+
+func formatChallenge(c auth.Challenge) string {
+	scheme := map[auth.AuthenticationScheme]string{
+		auth.BasicAuth:  "Basic",
+		auth.DigestAuth: "Digest",
+		auth.BearerAuth: "Bearer",
+	}[c.Scheme]
+	keys := make([]string, 0, len(c.Parameters))
+	for key := range c.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(c.Parameters[key])
+		params = append(params, key+`="`+value+`"`)
+	}
+	return scheme + " " + strings.Join(params, ",")
+}
@@ -0,0 +1,159 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinimalScopes(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	var mutex sync.Mutex
+	var scopes []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The tokens are issued for pulling only.
+		if r.URL.Path == "/token" {
+			mutex.Lock()
+			scopes = append(scopes, r.URL.Query()["scope"]...)
+			mutex.Unlock()
+			for _, scope := range r.URL.Query()["scope"] {
+				if strings.Contains(scope, "push") {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"token":"pull-token"}`)
+			return
+		}
+		// The registry challenges all the actions.
+		if r.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:library/foo:pull,push"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(manifest)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	resolver := NewResolver(false, true, credFunc)
+	name, desc, err := resolver.Resolve(context.Background(), host+"/library/foo:latest")
+	require.NoError(t, err)
+	fetcher, err := resolver.Fetcher(context.Background(), name)
+	require.NoError(t, err)
+	rc, err := fetcher.Fetch(context.Background(), desc)
+	require.NoError(t, err)
+	rc.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.NotEmpty(t, scopes)
+	for _, scope := range scopes {
+		require.Equal(t, "repository:library/foo:pull", scope)
+	}
+}
+
+func TestPullScopesOnly(t *testing.T) {
+	for challenge, expected := range map[string]string{
+		`Bearer realm="https://auth.example.com/token",scope="repository:foo:pull,push"`:               `Bearer realm="https://auth.example.com/token",scope="repository:foo:pull"`,
+		`Bearer realm="https://auth.example.com/token",scope="repository:foo:push registry:catalog:*"`: `Bearer realm="https://auth.example.com/token",scope="registry:catalog:*"`,
+		`Bearer realm="https://auth.example.com/token",scope="repository:foo:push"`:                    `Bearer realm="https://auth.example.com/token"`,
+		`Bearer realm="https://auth.example.com/token",scope="repository:foo:pull"`:                    "",
+		`Basic realm="registry"`: "",
+	} {
+		header, ok := pullScopesOnly(http.Header{"Www-Authenticate": []string{challenge}})
+		require.Equal(t, expected != "", ok, challenge)
+		if ok {
+			require.Equal(t, []string{expected}, header["Www-Authenticate"], challenge)
+		}
+	}
+}
+
+func TestMinimalScopesWithTokenCache(t *testing.T) {
+	var mutex sync.Mutex
+	var scopes [][]string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			mutex.Lock()
+			scopes = append(scopes, r.URL.Query()["scope"])
+			mutex.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"token":"token-%d","expires_in":300}`, len(scopes))
+			return
+		}
+		// The registry challenges all the actions.
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:library/foo:pull,push"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// Both the pushed blob and the pulled manifest exist.
+		manifest := []byte(`{"schemaVersion":2}`)
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	cache := NewTokenCache(DefaultTokenRefreshMargin)
+	ref := host + "/library/foo:latest"
+	pusher, err := NewResolver(false, true, credFunc, WithTokenCache(cache)).Pusher(context.Background(), ref)
+	require.NoError(t, err)
+	blob := []byte("foo-blob")
+	_, err = pusher.Push(context.Background(), ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	})
+	require.ErrorIs(t, err, errdefs.ErrAlreadyExists)
+
+	_, _, err = NewResolver(false, true, credFunc, WithTokenCache(cache)).Resolve(context.Background(), ref)
+	require.NoError(t, err)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Len(t, scopes, 2)
+	require.Equal(t, []string{"repository:library/foo:pull,push"}, scopes[0])
+	require.Equal(t, []string{"repository:library/foo:pull"}, scopes[1])
+}
@@ -0,0 +1,43 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"net/http"
+)
+
+// WithStatusObserver calls observe with the registry host and the status
+// code of every response received from registries, including the ones of
+// retried requests and token endpoints, e.g. to measure the distribution
+// of status codes.
+func WithStatusObserver(observe func(host string, statusCode int)) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.statusObserver = observe
+	}
+}
+
+type statusTransport struct {
+	transport http.RoundTripper
+	observe   func(host string, statusCode int)
+}
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.observe(req.URL.Host, resp.StatusCode)
+	return resp, nil
+}
@@ -0,0 +1,60 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goharbor/acceleration-service/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusObserver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			code = http.StatusNotFound
+		}
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	metric, err := metrics.NewStatusMetric(prometheus.NewRegistry())
+	require.NoError(t, err)
+	// The observer composes with the other transport wrappers.
+	client := NewClient(false, WithStatusObserver(metric.Observe), WithRequestTimeout(time.Minute))
+	for _, code := range []int{200, 429, 200, 500, 429, 200} {
+		resp, err := client.Get(server.URL + "/" + strconv.Itoa(code))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, code, resp.StatusCode)
+	}
+
+	count := func(code string) float64 {
+		return testutil.ToFloat64(metric.Responses.WithLabelValues(host, code))
+	}
+	require.Equal(t, float64(3), count("200"))
+	require.Equal(t, float64(2), count("429"))
+	require.Equal(t, float64(1), count("500"))
+	require.Zero(t, count("404"))
+}
@@ -0,0 +1,143 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WithRequestTimeout fails each request to registry if there is no
+// response or no progress on the request or response body within the
+// timeout, so a stalled blob fetch or upload fails fast without limiting
+// the time of a large but healthy one. It's disabled if timeout <= 0.
+func WithRequestTimeout(timeout time.Duration) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.requestTimeout = timeout
+	}
+}
+
+// timeoutError is a net.Error, so the request is considered retryable.
+type timeoutError struct {
+	method  string
+	url     string
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s request to %s timed out without progress for %s", e.method, e.url, e.timeout)
+}
+
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+type timeoutTransport struct {
+	transport http.RoundTripper
+	timeout   time.Duration
+}
+
+// watchdog cancels the request if it isn't reset within the timeout.
+type watchdog struct {
+	mutex    sync.Mutex
+	timer    *time.Timer
+	timedOut bool
+	err      error
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	dog := &watchdog{
+		err: &timeoutError{method: req.Method, url: req.URL.Redacted(), timeout: t.timeout},
+	}
+	dog.timer = time.AfterFunc(t.timeout, func() {
+		dog.mutex.Lock()
+		dog.timedOut = true
+		dog.mutex.Unlock()
+		cancel()
+	})
+
+	req = req.WithContext(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &progressBody{ReadCloser: req.Body, dog: dog, timeout: t.timeout}
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		dog.timer.Stop()
+		cancel()
+		return nil, dog.wrap(err)
+	}
+
+	resp.Body = &timeoutBody{
+		ReadCloser: resp.Body,
+		dog:        dog,
+		timeout:    t.timeout,
+		cancel:     cancel,
+	}
+	return resp, nil
+}
+
+func (dog *watchdog) wrap(err error) error {
+	dog.mutex.Lock()
+	defer dog.mutex.Unlock()
+	if dog.timedOut {
+		return dog.err
+	}
+	return err
+}
+
+// progressBody resets the watchdog on each progress of request body, so
+// a large but healthy blob upload isn't cancelled.
+type progressBody struct {
+	io.ReadCloser
+	dog     *watchdog
+	timeout time.Duration
+}
+
+func (body *progressBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	if n > 0 {
+		body.dog.timer.Reset(body.timeout)
+	}
+	return n, err
+}
+
+// timeoutBody resets the watchdog on each progress of response body.
+type timeoutBody struct {
+	io.ReadCloser
+	dog     *watchdog
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (body *timeoutBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	if n > 0 {
+		body.dog.timer.Reset(body.timeout)
+	}
+	if err != nil && err != io.EOF {
+		err = body.dog.wrap(err)
+	}
+	return n, err
+}
+
+func (body *timeoutBody) Close() error {
+	body.dog.timer.Stop()
+	body.cancel()
+	return body.ReadCloser.Close()
+}
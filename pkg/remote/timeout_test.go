@@ -0,0 +1,144 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	healthy := []byte("healthy-blob")
+	stalled := []byte("stalled-blob")
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := healthy
+		if strings.HasSuffix(r.URL.Path, digest.FromBytes(stalled).String()) {
+			data = stalled
+			// Send the headers and a part of body, then stall.
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(data[:4])
+			w.(http.Flusher).Flush()
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		// The healthy blob is sent slowly but keeps progress.
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		for i := range data {
+			w.Write(data[i : i+1])
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	host := server.Listener.Addr().String()
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	timeout := 100 * time.Millisecond
+	resolver := NewResolver(false, true, credFunc, WithRequestTimeout(timeout))
+	fetcher, err := resolver.Fetcher(context.Background(), host+"/library/foo:latest")
+	require.NoError(t, err)
+
+	fetch := func(data []byte) error {
+		rc, err := fetcher.Fetch(context.Background(), ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+		})
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.ReadAll(rc)
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var healthyErr, stalledErr error
+	var stalledElapsed time.Duration
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		healthyErr = fetch(healthy)
+	}()
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		stalledErr = fetch(stalled)
+		stalledElapsed = time.Since(start)
+	}()
+	wg.Wait()
+
+	require.NoError(t, healthyErr)
+	require.ErrorContains(t, stalledErr, "timed out")
+	require.Less(t, stalledElapsed, 2*time.Second)
+}
+
+func TestRequestTimeoutUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	timeout := 100 * time.Millisecond
+	client := NewClient(false, WithRequestTimeout(timeout))
+	upload := func(stall bool) error {
+		pr, pw := io.Pipe()
+		go func() {
+			// The blob is sent slowly but keeps progress, longer than timeout.
+			for i := 0; i < 20; i++ {
+				if stall && i == 5 {
+					time.Sleep(10 * timeout)
+				}
+				if _, err := pw.Write([]byte("blob")); err != nil {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			pw.Close()
+		}()
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/v2/library/foo/blobs/uploads/1", pr)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	require.NoError(t, upload(false))
+	require.ErrorContains(t, upload(true), "timed out")
+}
@@ -0,0 +1,157 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig is the TLS configuration to communicate with registry,
+// all the files are PEM encoded.
+type TLSConfig struct {
+	// CAFile is the CA certificates to verify the registry certificate,
+	// the system certificate pool is used if it's empty.
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key presented
+	// to the registry which requires mutual TLS authentication.
+	CertFile string
+	KeyFile  string
+}
+
+// WithTLSConfig sets the TLS configuration for registry host, the host
+// can be `host` or `host:port`, the later one is preferred if both are set.
+// The custom CA can't be used with insecure registry, the request to such
+// host fails rather than skipping the verification silently.
+func WithTLSConfig(host string, cfg TLSConfig) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		if opts.tlsConfigs == nil {
+			opts.tlsConfigs = map[string]TLSConfig{}
+		}
+		opts.tlsConfigs[canonicalHost(host)] = cfg
+	}
+}
+
+func (cfg TLSConfig) clientConfig(insecure bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+
+	if cfg.CAFile != "" {
+		if insecure {
+			return nil, errors.New("custom CA is mutually exclusive with insecure")
+		}
+		bytes, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA file")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bytes) {
+			return nil, fmt.Errorf("no valid certificate in CA file %s", cfg.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// hostTransport chooses the transport by the host of request, so that
+// the registry and its mirrors or token server can use different TLS
+// configurations in the same resolver.
+type hostTransport struct {
+	proxy      proxyFunc
+	insecure   bool
+	tlsConfigs map[string]TLSConfig
+	config     TransportConfig
+	overrides  map[string]string
+
+	mutex      sync.Mutex
+	transports map[string]http.RoundTripper
+	errs       map[string]error
+}
+
+func newHostTransport(proxy proxyFunc, insecure bool, tlsConfigs map[string]TLSConfig, config TransportConfig, overrides map[string]string) *hostTransport {
+	return &hostTransport{
+		proxy:      proxy,
+		insecure:   insecure,
+		tlsConfigs: tlsConfigs,
+		config:     config,
+		overrides:  overrides,
+		transports: map[string]http.RoundTripper{},
+		errs:       map[string]error{},
+	}
+}
+
+func (t *hostTransport) transport(host string) (http.RoundTripper, error) {
+	cfg, ok := t.tlsConfigs[host]
+	if !ok {
+		hostname, split := splitHostname(host)
+		if split {
+			cfg, ok = t.tlsConfigs[hostname]
+			if ok {
+				host = hostname
+			}
+		}
+	}
+	if !ok {
+		host = ""
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if transport, ok := t.transports[host]; ok {
+		return transport, nil
+	}
+	if err, ok := t.errs[host]; ok {
+		return nil, err
+	}
+
+	tlsConfig, err := cfg.clientConfig(t.insecure)
+	if err != nil {
+		err = errors.Wrapf(err, "configure TLS for %s", host)
+		t.errs[host] = err
+		return nil, err
+	}
+	transport := newTransport(t.proxy, tlsConfig, t.config, t.overrides)
+	t.transports[host] = transport
+
+	return transport, nil
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, err := t.transport(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
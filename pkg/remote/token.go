@@ -0,0 +1,267 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/containerd/remotes/docker/auth"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultTokenRefreshMargin is the margin before expiry to refresh the
+// cached token, which covers the latency of requests using the token.
+const DefaultTokenRefreshMargin = 30 * time.Second
+
+// defaultTokenExpiresIn is the lifetime of token if the token endpoint
+// doesn't specify `expires_in`, see also:
+// https://docs.docker.com/registry/spec/auth/token/#requesting-a-token
+const defaultTokenExpiresIn = 60 * time.Second
+
+type cachedToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// TokenCache caches the bearer tokens by registry host, credential and
+// scopes, it's shared by the resolvers created with the same cache, so
+// pulling images from the same registry exchanges the token only once.
+type TokenCache struct {
+	mutex  sync.Mutex
+	margin time.Duration
+	tokens map[string]cachedToken
+	// The realm and service of bearer challenges by host.
+	challenges map[string]auth.TokenOptions
+	// The challenged scopes by host and the scopes of operation.
+	scopes map[string][]string
+	group  singleflight.Group
+	now    func() time.Time
+}
+
+// NewTokenCache creates a token cache which refreshes the tokens the
+// margin before their expiry.
+func NewTokenCache(margin time.Duration) *TokenCache {
+	return &TokenCache{
+		margin:     margin,
+		tokens:     map[string]cachedToken{},
+		challenges: map[string]auth.TokenOptions{},
+		scopes:     map[string][]string{},
+		now:        time.Now,
+	}
+}
+
+// WithTokenCache reuses the bearer tokens in cache for the registries
+// with token authentication, the registries with basic authentication
+// are not affected.
+func WithTokenCache(cache *TokenCache) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.tokenCache = cache
+	}
+}
+
+func (c *TokenCache) get(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.tokens[key]
+	if !ok || !c.now().Add(c.margin).Before(cached.expiresAt) {
+		return "", false
+	}
+	return cached.token, true
+}
+
+func (c *TokenCache) set(key, token string, expiresIn int, issuedAt time.Time) {
+	lifetime := defaultTokenExpiresIn
+	if expiresIn > 0 {
+		lifetime = time.Duration(expiresIn) * time.Second
+	}
+	if issuedAt.IsZero() {
+		issuedAt = c.now()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tokens[key] = cachedToken{token: token, expiresAt: issuedAt.Add(lifetime)}
+}
+
+// challenge returns the token options of host with the scopes challenged
+// for the operation, which are specific to the repository of operation,
+// e.g. the upstream repository of a pull-through cache, so they're never
+// shared by the operations of other repositories or with other actions.
+func (c *TokenCache) challenge(host, operation string) (auth.TokenOptions, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	to, ok := c.challenges[host]
+	if ok {
+		to.Scopes = append([]string{}, c.scopes[host+"|"+operation]...)
+	}
+	return to, ok
+}
+
+func (c *TokenCache) setChallenge(host, operation string, to auth.TokenOptions) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.scopes[host+"|"+operation] = to.Scopes
+	to.Scopes = nil
+	c.challenges[host] = to
+}
+
+// operationScopes returns the key of the token scopes carried by the
+// context of request.
+func operationScopes(ctx context.Context) string {
+	return strings.Join(docker.GetTokenScopes(ctx, nil), " ")
+}
+
+// invalidate removes the token rejected by registry.
+func (c *TokenCache) invalidate(token string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, cached := range c.tokens {
+		if cached.token == token {
+			delete(c.tokens, key)
+		}
+	}
+}
+
+// cachingAuthorizer answers the bearer challenges of registry with the
+// tokens in cache, and delegates the other challenges to the authorizer.
+type cachingAuthorizer struct {
+	cache      *TokenCache
+	authorizer docker.Authorizer
+	client     *http.Client
+	header     http.Header
+	credFunc   CredentialFunc
+}
+
+func newCachingAuthorizer(cache *TokenCache, authorizer docker.Authorizer, client *http.Client, header http.Header, credFunc CredentialFunc) docker.Authorizer {
+	return &cachingAuthorizer{
+		cache:      cache,
+		authorizer: authorizer,
+		client:     client,
+		header:     header,
+		credFunc:   credFunc,
+	}
+}
+
+func (a *cachingAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	to, ok := a.cache.challenge(req.URL.Host, operationScopes(ctx))
+	if !ok {
+		return a.authorizer.Authorize(ctx, req)
+	}
+
+	// The credential may be rotated, so it's resolved for each token.
+	if a.credFunc != nil {
+		username, secret, err := a.credFunc(req.URL.Host)
+		if err != nil {
+			return err
+		}
+		to.Username, to.Secret = username, secret
+	}
+	to.Scopes = minimalScopes(docker.GetTokenScopes(ctx, nil), docker.GetTokenScopes(ctx, to.Scopes))
+	sort.Strings(to.Scopes)
+	key := strings.Join([]string{req.URL.Host, to.Realm, to.Service, to.Username, strings.Join(to.Scopes, " ")}, "|")
+
+	token, ok := a.cache.get(key)
+	if !ok {
+		result, err, _ := a.cache.group.Do(key, func() (interface{}, error) {
+			if token, ok := a.cache.get(key); ok {
+				return token, nil
+			}
+			token, expiresIn, issuedAt, err := a.fetchToken(ctx, to)
+			if err != nil {
+				return "", err
+			}
+			a.cache.set(key, token, expiresIn, issuedAt)
+			return token, nil
+		})
+		if err != nil {
+			return err
+		}
+		token = result.(string)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+// fetchToken fetches the token in the same way as the docker authorizer,
+// the OAuth POST request is tried first if there is a secret. The token
+// is fetched from the realm advertised by the registry which answered the
+// request, which may be a pull-through cache with its own token service on
+// another host, and some of them answer the OAuth request without token,
+// the GET request is tried then.
+func (a *cachingAuthorizer) fetchToken(ctx context.Context, to auth.TokenOptions) (string, int, time.Time, error) {
+	if to.Secret != "" {
+		resp, err := auth.FetchTokenWithOAuth(ctx, a.client, a.header, "containerd-client", to)
+		if err == nil {
+			return resp.AccessToken, resp.ExpiresIn, resp.IssuedAt, nil
+		}
+		if !oauthFallback(err, to.Username) {
+			return "", 0, time.Time{}, errors.Wrap(err, "fetch oauth token")
+		}
+	}
+	resp, err := auth.FetchToken(ctx, a.client, a.header, to)
+	if err != nil {
+		return "", 0, time.Time{}, errors.Wrap(err, "fetch token")
+	}
+	return resp.Token, resp.ExpiresIn, resp.IssuedAt, nil
+}
+
+// oauthFallback returns true if the GET request should be tried for the
+// failed OAuth request.
+func oauthFallback(err error, username string) bool {
+	if errors.Is(err, auth.ErrNoToken) {
+		return true
+	}
+	var errStatus remoteerrors.ErrUnexpectedStatus
+	if !errors.As(err, &errStatus) {
+		return false
+	}
+	return (errStatus.StatusCode == http.StatusMethodNotAllowed && username != "") ||
+		errStatus.StatusCode == http.StatusNotFound ||
+		errStatus.StatusCode == http.StatusUnauthorized ||
+		errStatus.StatusCode == http.StatusBadRequest
+}
+
+func (a *cachingAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	last := responses[len(responses)-1]
+	host := last.Request.URL.Host
+	if authorization := last.Request.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		a.cache.invalidate(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	for _, c := range auth.ParseAuthHeader(last.Header) {
+		if c.Scheme != auth.BearerAuth {
+			continue
+		}
+		if _, ok := a.cache.challenge(host, ""); ok && countUnauthorized(responses) > 1 {
+			return errors.Errorf("authorization with cached token failed for %s", host)
+		}
+		to, err := auth.GenerateTokenOptions(ctx, host, "", "", c)
+		if err != nil {
+			return err
+		}
+		a.cache.setChallenge(host, operationScopes(ctx), to)
+		return nil
+	}
+
+	return a.authorizer.AddResponses(ctx, responses)
+}
@@ -0,0 +1,204 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenRegistry creates a registry with token authentication, and
+// returns the function counting the issued tokens.
+func newTokenRegistry(t *testing.T) (*httptest.Server, func() int) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	var mutex sync.Mutex
+	issued := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			mutex.Lock()
+			issued++
+			token := fmt.Sprintf("token-%d", issued)
+			mutex.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"token":%q,"expires_in":300}`, token)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:library/foo:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return issued
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	server, issued := newTokenRegistry(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	refs := []string{host + "/library/foo:v1", host + "/library/foo:v2", host + "/library/foo:v3"}
+
+	// Each resolver exchanges the token without cache.
+	for _, ref := range refs {
+		_, _, err := NewResolver(false, true, credFunc).Resolve(context.Background(), ref)
+		require.NoError(t, err)
+	}
+	require.Equal(t, len(refs), issued())
+
+	cache := NewTokenCache(DefaultTokenRefreshMargin)
+	for _, ref := range refs {
+		_, _, err := NewResolver(false, true, credFunc, WithTokenCache(cache)).Resolve(context.Background(), ref)
+		require.NoError(t, err)
+	}
+	require.Equal(t, len(refs)+1, issued())
+
+	// The token is refreshed the margin before expiry.
+	cache.now = func() time.Time {
+		return time.Now().Add(300*time.Second - DefaultTokenRefreshMargin)
+	}
+	_, _, err := NewResolver(false, true, credFunc, WithTokenCache(cache)).Resolve(context.Background(), refs[0])
+	require.NoError(t, err)
+	require.Equal(t, len(refs)+2, issued())
+}
+
+func TestPullThroughCache(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	var mutex sync.Mutex
+	var tokenRequests []string
+	// The token service of cache is on another host, and it answers the
+	// OAuth request without token.
+	realm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		tokenRequests = append(tokenRequests, r.Method)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			fmt.Fprint(w, `{"token":"oauth-unsupported"}`)
+			return
+		}
+		username, password, _ := r.BasicAuth()
+		query := r.URL.Query()
+		if query.Get("service") != "cache" || username != "foo" || password != "bar" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		scopes := query["scope"]
+		if len(scopes) == 0 || scopes[0] != "repository:dockerhub/library/foo:pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"cache-token","expires_in":300}`)
+	}))
+	defer realm.Close()
+	cache := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer cache-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="cache",scope="repository:dockerhub/library/foo:pull"`, realm.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer cache.Close()
+
+	var credHosts []string
+	credFunc := func(host string) (string, string, error) {
+		mutex.Lock()
+		credHosts = append(credHosts, host)
+		mutex.Unlock()
+		return "foo", "bar", nil
+	}
+	// The upstream registry is unreachable, so the image is resolved by cache.
+	resolver := NewResolver(false, false, credFunc,
+		WithMirrors("registry.invalid", cache.URL),
+		WithTokenCache(NewTokenCache(DefaultTokenRefreshMargin)),
+	)
+	_, desc, err := resolver.Resolve(context.Background(), "registry.invalid/library/foo:latest")
+	require.NoError(t, err)
+	require.Equal(t, digest.FromBytes(manifest), desc.Digest)
+
+	require.Equal(t, []string{http.MethodPost, http.MethodGet}, tokenRequests)
+	require.Equal(t, []string{strings.TrimPrefix(cache.URL, "http://")}, credHosts)
+}
+
+func TestTokenCacheScopes(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	var mutex sync.Mutex
+	var scopes [][]string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			mutex.Lock()
+			scopes = append(scopes, r.URL.Query()["scope"])
+			mutex.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"token":"token-%s","expires_in":300}`, strings.Join(r.URL.Query()["scope"], ","))
+			return
+		}
+		// The challenged scope is the repository of request.
+		repo := strings.TrimPrefix(r.URL.Path[:strings.Index(r.URL.Path, "/manifests/")], "/v2/")
+		if r.Header.Get("Authorization") != "Bearer token-repository:"+repo+":pull" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:%s:pull"`, server.URL, repo))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	credFunc := func(string) (string, string, error) {
+		return "", "", nil
+	}
+	cache := NewTokenCache(DefaultTokenRefreshMargin)
+	for _, ref := range []string{host + "/library/foo:latest", host + "/library/bar:latest"} {
+		_, _, err := NewResolver(false, true, credFunc, WithTokenCache(cache)).Resolve(context.Background(), ref)
+		require.NoError(t, err)
+	}
+
+	// The scope challenged for foo isn't requested for bar.
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Equal(t, [][]string{{"repository:library/foo:pull"}, {"repository:library/bar:pull"}}, scopes)
+}
@@ -0,0 +1,82 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 30 * time.Second
+)
+
+// TransportConfig tunes the connection pooling of the transport to
+// registry, the zero value pools the HTTP/1.1 connections with defaults.
+type TransportConfig struct {
+	// MaxIdleConnsPerHost is the max idle connections kept for each
+	// registry host, defaults to 10 if it's <= 0.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is the time an idle connection is kept before
+	// closing, defaults to 30s if it's <= 0.
+	IdleConnTimeout time.Duration
+	// ForceHTTP2 attempts HTTP/2 for the TLS connections, which
+	// multiplexes the requests to the same registry over a connection.
+	ForceHTTP2 bool
+}
+
+// WithTransportConfig sets the connection pooling of the transport to
+// registry, it's applied to the transports of all hosts.
+func WithTransportConfig(config TransportConfig) ResolverOpt {
+	return func(opts *ResolverOpts) {
+		opts.transport = config
+	}
+}
+
+func newTransport(proxy proxyFunc, tlsConfig *tls.Config, config TransportConfig, overrides map[string]string) *http.Transport {
+	maxIdleConnsPerHost := config.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	idleConnTimeout := config.IdleConnTimeout
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: overrideDial((&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext, overrides),
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 5 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	if config.ForceHTTP2 {
+		transport.ForceAttemptHTTP2 = true
+	} else {
+		// The non-nil empty map disables HTTP/2.
+		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+	}
+
+	return transport
+}
@@ -0,0 +1,183 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportConfig(t *testing.T) {
+	// The zero value pools the HTTP/1.1 connections with defaults.
+	client := newDefaultClient(false, ResolverOpts{})
+	transport := client.Transport.(*http.Transport)
+	require.False(t, transport.DisableKeepAlives)
+	require.Equal(t, defaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	require.Equal(t, defaultIdleConnTimeout, transport.IdleConnTimeout)
+	require.False(t, transport.ForceAttemptHTTP2)
+	require.NotNil(t, transport.TLSNextProto)
+
+	var options ResolverOpts
+	WithTransportConfig(TransportConfig{
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     time.Minute,
+		ForceHTTP2:          true,
+	})(&options)
+	client = newDefaultClient(false, options)
+	transport = client.Transport.(*http.Transport)
+	require.Equal(t, 32, transport.MaxIdleConnsPerHost)
+	require.Equal(t, time.Minute, transport.IdleConnTimeout)
+	require.True(t, transport.ForceAttemptHTTP2)
+	require.Nil(t, transport.TLSNextProto)
+
+	// The config is applied to the transports of hosts with TLS config.
+	WithTLSConfig("registry.example.com", TLSConfig{})(&options)
+	client = newDefaultClient(false, options)
+	hostTransport, err := client.Transport.(*hostTransport).transport("registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, 32, hostTransport.(*http.Transport).MaxIdleConnsPerHost)
+}
+
+func TestForceHTTP2(t *testing.T) {
+	blob := []byte("http2-blob")
+	var mutex sync.Mutex
+	protos := map[int]int{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		protos[r.ProtoMajor]++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.Write(blob)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	fetch := func(opts ...ResolverOpt) {
+		resolver := NewResolver(true, false, nil, opts...)
+		fetcher, err := resolver.Fetcher(context.Background(), host+"/library/foo:latest")
+		require.NoError(t, err)
+		reader, err := fetcher.Fetch(context.Background(), ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromBytes(blob),
+			Size:      int64(len(blob)),
+		})
+		require.NoError(t, err)
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, blob, data)
+	}
+
+	fetch()
+	fetch(WithTransportConfig(TransportConfig{ForceHTTP2: true}))
+	require.Equal(t, map[int]int{1: 1, 2: 1}, protos)
+}
+
+func TestHostOverride(t *testing.T) {
+	blob := []byte("override-blob")
+	var mutex sync.Mutex
+	var hosts, serverNames []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		hosts = append(hosts, r.Host)
+		serverNames = append(serverNames, r.TLS.ServerName)
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.Write(blob)
+	}))
+	server.StartTLS()
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	// The certificate of test server is valid for example.com.
+	host := "example.com:" + port
+	resolver := NewResolver(false, false, nil,
+		WithTLSConfig("example.com", TLSConfig{CAFile: caFile}),
+		WithHostOverride("example.com", "127.0.0.1"),
+	)
+	fetcher, err := resolver.Fetcher(context.Background(), host+"/library/foo:latest")
+	require.NoError(t, err)
+	reader, err := fetcher.Fetch(context.Background(), ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	})
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, blob, data)
+
+	require.Equal(t, []string{host}, hosts)
+	require.Equal(t, []string{"example.com"}, serverNames)
+}
+
+func TestOverrideAddress(t *testing.T) {
+	overrides := map[string]string{
+		"registry.example.com":      "10.0.0.1",
+		"registry.example.com:5000": "10.0.0.2:5001",
+		"mirror.example.com":        "[::1]:8443",
+	}
+	require.Equal(t, "10.0.0.1:443", overrideAddress("registry.example.com:443", overrides))
+	require.Equal(t, "10.0.0.2:5001", overrideAddress("registry.example.com:5000", overrides))
+	require.Equal(t, "[::1]:8443", overrideAddress("mirror.example.com:443", overrides))
+	require.Equal(t, "other.example.com:443", overrideAddress("other.example.com:443", overrides))
+
+	// The IPv6 literals are matched and joined with the port in brackets.
+	var opts ResolverOpts
+	WithHostOverride("::1", "fd00::1")(&opts)
+	WithHostOverride("[2001:db8::1]:5000", "[fd00::2]")(&opts)
+	require.Equal(t, "[fd00::1]:5000", overrideAddress("[::1]:5000", opts.hostOverrides))
+	require.Equal(t, "[fd00::2]:5000", overrideAddress("[2001:db8::1]:5000", opts.hostOverrides))
+	require.Equal(t, "[2001:db8::1]:443", overrideAddress("[2001:db8::1]:443", opts.hostOverrides))
+}
+
+func TestSplitHostname(t *testing.T) {
+	for host, expected := range map[string]string{
+		"registry.example.com:5000": "registry.example.com",
+		"10.0.0.1:5000":             "10.0.0.1",
+		"[::1]:5000":                "[::1]",
+		"[2001:db8::1]:443":         "[2001:db8::1]",
+	} {
+		hostname, ok := splitHostname(host)
+		require.True(t, ok)
+		require.Equal(t, expected, hostname)
+	}
+	for _, host := range []string{"registry.example.com", "[::1]", "::1"} {
+		_, ok := splitHostname(host)
+		require.False(t, ok)
+	}
+	require.Equal(t, "[::1]", canonicalHost("::1"))
+	require.Equal(t, "[::1]", canonicalHost("[::1]"))
+	require.Equal(t, "registry.example.com:5000", canonicalHost("registry.example.com:5000"))
+}